@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/amal5haji/go-webspider/webspider"
+)
+
+func runSitemap(args []string) {
+	fs := flag.NewFlagSet("sitemap", flag.ExitOnError)
+	crawl := addCrawlFlags(fs)
+	fs.Parse(args)
+
+	result := crawl.spider(crawl.spiderOptions())
+
+	file := crawl.createOutput()
+	defer file.Close()
+	if err := writeSitemap(file, result.Pages); err != nil {
+		log.Fatalf("Failed to write sitemap: %v", err)
+	}
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// writeSitemap writes pages as a sitemaps.org urlset sorted by URL, using the
+// published date as lastmod when the page had one.
+func writeSitemap(w io.Writer, pages []webspider.PageResult) error {
+	urlSet := sitemapURLSet{XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	for _, page := range pages {
+		entry := sitemapURL{Loc: page.URL}
+		if !page.PublishedAt.IsZero() {
+			entry.LastMod = page.PublishedAt.Format(time.RFC3339)
+		}
+		urlSet.URLs = append(urlSet.URLs, entry)
+	}
+	sort.Slice(urlSet.URLs, func(i, j int) bool {
+		return urlSet.URLs[i].Loc < urlSet.URLs[j].Loc
+	})
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(urlSet); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func runLinks(args []string) {
+	fs := flag.NewFlagSet("links", flag.ExitOnError)
+	crawl := addCrawlFlags(fs)
+	fs.Parse(args)
+
+	options := crawl.spiderOptions()
+	options.RecordExternalLinks = true
+	result := crawl.spider(options)
+
+	file := crawl.createOutput()
+	defer file.Close()
+	// One tab-separated line per target: times linked, URL, anchor text
+	for _, link := range result.ExternalLinks {
+		if _, err := fmt.Fprintf(file, "%d\t%s\t%s\n", link.Count, link.Href, link.Text); err != nil {
+			log.Fatalf("Failed to write links: %v", err)
+		}
+	}
+}
+
+func runCheck(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	crawl := addCrawlFlags(fs)
+	fs.Parse(args)
+
+	result := crawl.spider(crawl.spiderOptions())
+
+	failedURLs := make([]string, 0, len(result.FailedPages))
+	for failedURL := range result.FailedPages {
+		failedURLs = append(failedURLs, failedURL)
+	}
+	sort.Strings(failedURLs)
+
+	file := crawl.createOutput()
+	for _, failedURL := range failedURLs {
+		if _, err := fmt.Fprintf(file, "%s\t%s\n", failedURL, result.FailedPages[failedURL]); err != nil {
+			log.Fatalf("Failed to write report: %v", err)
+		}
+	}
+	file.Close()
+
+	// Exit non-zero so the check can gate a CI job
+	if len(failedURLs) > 0 {
+		os.Exit(1)
+	}
+}
+
+func runPreflight(args []string) {
+	fs := flag.NewFlagSet("preflight", flag.ExitOnError)
+	var targetURL string
+	var timeout time.Duration
+	var noRobots bool
+	fs.StringVar(&targetURL, "url", "", "The URL to check before crawling")
+	fs.DurationVar(&timeout, "timeout", 30*time.Second, "Timeout for individual page requests")
+	fs.BoolVar(&noRobots, "ignore-robots", false, "Don't report robots.txt rules as problems")
+	fs.Parse(args)
+
+	if targetURL == "" {
+		log.Fatal("Please provide a target URL using the -url flag")
+	}
+
+	options := webspider.DefaultSpiderOptions()
+	options.Timeout = timeout
+	options.RespectRobotsTxt = !noRobots
+	report, err := webspider.Preflight(targetURL, options)
+	if err != nil {
+		log.Fatalf("Preflight failed: %v", err)
+	}
+
+	fmt.Printf("URL:          %s\n", report.URL)
+	if report.Reachable {
+		fmt.Printf("Status:       %d\n", report.StatusCode)
+		fmt.Printf("Final URL:    %s\n", report.FinalURL)
+	}
+	fmt.Printf("robots.txt:   found=%t allowed=%t", report.RobotsFound, report.RobotsAllowed)
+	if report.CrawlDelay > 0 {
+		fmt.Printf(" crawl-delay=%s", report.CrawlDelay)
+	}
+	fmt.Println()
+	for _, sitemap := range report.Sitemaps {
+		fmt.Printf("Sitemap:      %s\n", sitemap)
+	}
+
+	if report.OK() {
+		fmt.Println("\nOK to crawl.")
+		return
+	}
+	fmt.Println("\nProblems:")
+	for _, problem := range report.Problems {
+		fmt.Printf("  %s\n", problem)
+	}
+	os.Exit(1)
+}
+
+func runDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	var jsonOutput bool
+	fs.BoolVar(&jsonOutput, "json", false, "Write the diff as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s diff [-json] <previous> <current>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	previous, err := loadResult(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to load previous crawl: %v", err)
+	}
+	current, err := loadResult(fs.Arg(1))
+	if err != nil {
+		log.Fatalf("Failed to load current crawl: %v", err)
+	}
+
+	diff := webspider.DiffResults(previous, current)
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(diff); err != nil {
+			log.Fatalf("Failed to write diff: %v", err)
+		}
+		return
+	}
+
+	// Broken pages are listed with their error, fixed ones with the old error
+	sections := []struct {
+		name    string
+		urls    []string
+		reasons map[string]string
+	}{
+		{"New", diff.New, nil},
+		{"Removed", diff.Removed, nil},
+		{"Changed", diff.Changed, nil},
+		{"Newly broken", diff.NewlyBroken, current.FailedPages},
+		{"Fixed", diff.Fixed, previous.FailedPages},
+	}
+	for _, section := range sections {
+		fmt.Printf("%s (%d):\n", section.name, len(section.urls))
+		for _, u := range section.urls {
+			if reason, ok := section.reasons[u]; ok {
+				fmt.Printf("  %s\t%s\n", u, reason)
+			} else {
+				fmt.Printf("  %s\n", u)
+			}
+		}
+	}
+	fmt.Printf("Unchanged: %d\n", len(diff.Unchanged))
+}
+
+// loadResult reads a crawl saved with -format json or -format gob, telling
+// them apart by the JSON document's opening brace.
+func loadResult(path string) (*webspider.SpiderResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		b, err := reader.Peek(1)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			reader.Discard(1)
+			continue
+		case '{':
+			return webspider.ReadJSON(reader)
+		}
+		return webspider.ReadGob(reader)
+	}
+}
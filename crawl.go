@@ -0,0 +1,125 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/amal5haji/go-webspider/webspider"
+)
+
+func runCrawl(args []string) {
+	fs := flag.NewFlagSet("crawl", flag.ExitOnError)
+	crawl := addCrawlFlags(fs)
+
+	var gzipOutput bool
+	var inspectFiles bool
+	var pageOrder string
+	var maxTotalChars int
+	var maxBytes int64
+	var format string
+	var filesOutput string
+	var frontMatter bool
+	var linkGraph bool
+
+	fs.StringVar(&filesOutput, "files-output", "", "Write detected file links as JSON lines to this path")
+	fs.StringVar(&format, "format", "markdown", "Output format: markdown, text, json, gob, pdf or zip")
+	fs.BoolVar(&gzipOutput, "gzip", false, "Compress the output with gzip")
+	fs.BoolVar(&frontMatter, "front-matter", false, "Start each page with YAML front matter instead of a URL header")
+	fs.BoolVar(&linkGraph, "link-graph", false, "Include the internal links of each page under linkGraph in JSON output")
+	fs.IntVar(&maxTotalChars, "max-total-chars", 0, "Stop crawling once the output reaches this many characters (0 = unlimited)")
+	fs.Int64Var(&maxBytes, "max-bytes", 0, "Stop crawling once this many bytes have been downloaded (0 = unlimited)")
+	fs.StringVar(&pageOrder, "order", "", "Sort output pages: crawl, depth or depth_desc (default: completion order)")
+	fs.BoolVar(&inspectFiles, "inspect-files", false, "Send HEAD requests to report size and type of detected files")
+
+	fs.Parse(args)
+
+	if format != "markdown" && format != "text" && format != "json" && format != "gob" && format != "pdf" && format != "zip" {
+		log.Fatalf("Unknown output format '%s'", format)
+	}
+	switch webspider.PageOrder(pageOrder) {
+	case webspider.PageOrderCompletion, webspider.PageOrderCrawl, webspider.PageOrderDepth, webspider.PageOrderDepthDescending:
+	default:
+		log.Fatalf("Unknown page order '%s'", pageOrder)
+	}
+
+	options := crawl.spiderOptions()
+	options.InspectFiles = inspectFiles
+	options.PageOrder = webspider.PageOrder(pageOrder)
+	options.MaxTotalChars = maxTotalChars
+	options.StopAtMaxTotalChars = true
+	options.MaxBytes = maxBytes
+	options.MarkdownFrontMatter = frontMatter
+	options.TrackLinkGraph = linkGraph
+
+	result := crawl.spider(options)
+
+	file := crawl.createOutput()
+	defer file.Close()
+
+	var output io.Writer = file
+	var gzipWriter *gzip.Writer
+	if gzipOutput {
+		gzipWriter = gzip.NewWriter(file)
+		output = gzipWriter
+	}
+
+	var err error
+	switch format {
+	case "zip":
+		err = result.WriteZip(output)
+	case "gob":
+		err = result.WriteGob(output)
+	case "pdf":
+		err = result.WritePDF(output, crawl.targetURL, nil)
+	default:
+		var formatted string
+		if formatted, err = webspider.FormatResult(result, format); err == nil {
+			_, err = fmt.Fprint(output, formatted)
+		}
+	}
+	if err != nil {
+		log.Fatalf("Failed to write output: %v", err)
+	}
+
+	// The gzip writer must be closed explicitly to flush the remaining
+	// compressed data and the footer before the file is closed.
+	if gzipWriter != nil {
+		if err := gzipWriter.Close(); err != nil {
+			log.Fatalf("Failed to finish gzip output: %v", err)
+		}
+	}
+
+	// Optionally log failed pages to stderr or a separate file
+	if len(result.FailedPages) > 0 {
+		fmt.Fprintf(os.Stderr, "\nFailed Pages:\n")
+		for url, err := range result.FailedPages {
+			fmt.Fprintf(os.Stderr, "  %s: %s\n", url, err)
+		}
+	}
+	// Optionally log detected files
+	if len(result.DetectedFiles) > 0 {
+		fmt.Fprintf(os.Stderr, "\nDetected File URLs (not crawled):\n")
+		for _, file := range result.DetectedFiles {
+			if file.ContentType == "" && file.Size == 0 {
+				fmt.Fprintf(os.Stderr, "  %s\n", file.URL)
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "  %s (%s, %d bytes)\n", file.URL, file.ContentType, file.Size)
+		}
+	}
+
+	if filesOutput != "" {
+		filesFile, err := os.Create(filesOutput)
+		if err != nil {
+			log.Fatalf("Failed to create files output '%s': %v", filesOutput, err)
+		}
+		defer filesFile.Close()
+		if err := result.WriteDetectedFilesJSONL(filesFile); err != nil {
+			log.Fatalf("Failed to write files output: %v", err)
+		}
+	}
+}
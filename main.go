@@ -2,109 +2,142 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/amal5haji/go-webspider/webspider"
 )
 
+type command struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
+
+var commands = []command{
+	{"crawl", "Crawl a site and write its text content (default)", runCrawl},
+	{"sitemap", "Crawl a site and write a sitemap.xml of the pages found", runSitemap},
+	{"links", "Crawl a site and list the external links it contains", runLinks},
+	{"check", "Crawl a site and report broken pages", runCheck},
+	{"preflight", "Check that a site can be crawled before starting", runPreflight},
+	{"diff", "Compare two crawls saved with -format json or gob", runDiff},
+}
+
 func main() {
-	var targetURL string
-	var maxPages int
-	var maxDepth int
-	var timeout time.Duration
-	var concurrency int
-	var delay time.Duration
-	var outputFile string
-
-	flag.StringVar(&targetURL, "url", "", "Target URL to start crawling from")
-	flag.IntVar(&maxPages, "max-pages", 100, "Maximum number of pages to crawl")
-	flag.IntVar(&maxDepth, "max-depth", 3, "Maximum crawl depth")
-	flag.DurationVar(&timeout, "timeout", 30*time.Second, "Timeout for individual page requests")
-	flag.IntVar(&concurrency, "concurrency", 5, "Number of concurrent crawlers")
-	flag.DurationVar(&delay, "delay", 1*time.Second, "Delay between requests per crawler")
-	flag.StringVar(&outputFile, "output", "", "Output file path (default: stdout)")
-
-	flag.Parse()
-
-	if targetURL == "" {
-		log.Fatal("Please provide a target URL using the -url flag")
+	// Without a subcommand the flags go to crawl, as before subcommands existed
+	name, args := "crawl", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
 	}
 
-	options := &webspider.SpiderOptions{
-		MaxPages:       maxPages,
-		MaxDepth:       maxDepth,
-		Timeout:        timeout,
-		Concurrency:    concurrency,
-		DelayBetween:   delay,
-		CrawlSubDomain: true,
+	for _, cmd := range commands {
+		if cmd.name == name {
+			cmd.run(args)
+			return
+		}
 	}
 
-	// Handle graceful shutdown on Ctrl+C
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	fmt.Fprintf(os.Stderr, "Unknown command '%s'\n\n", name)
+	usage()
+	os.Exit(2)
+}
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-sigChan
-		fmt.Println("\nReceived interrupt signal, shutting down...")
-		cancel()
-	}()
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> -url <TARGET_URL> [OPTIONS]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the options of a command.\n", os.Args[0])
+}
 
-	fmt.Printf("Starting crawl of %s...\n", targetURL)
-	startTime := time.Now()
+// crawlFlags are the options shared by every command.
+type crawlFlags struct {
+	targetURL   string
+	maxPages    int
+	maxDepth    int
+	timeout     time.Duration
+	concurrency int
+	delay       time.Duration
+	outputFile  string
+	excludeFile string
+	noRobots    bool
+}
 
-	result, err := webspider.SpiderWebsite(targetURL, options)
-	if err != nil {
-		log.Fatalf("Crawl failed: %v", err)
-	}
+func addCrawlFlags(fs *flag.FlagSet) *crawlFlags {
+	f := &crawlFlags{}
+	fs.StringVar(&f.targetURL, "url", "", "Target URL to start crawling from")
+	fs.IntVar(&f.maxPages, "max-pages", 100, "Maximum number of pages to crawl")
+	fs.IntVar(&f.maxDepth, "max-depth", 3, "Maximum crawl depth")
+	fs.DurationVar(&f.timeout, "timeout", 30*time.Second, "Timeout for individual page requests")
+	fs.IntVar(&f.concurrency, "concurrency", 5, "Number of concurrent crawlers")
+	fs.DurationVar(&f.delay, "delay", 1*time.Second, "Delay between requests per crawler")
+	fs.StringVar(&f.outputFile, "output", "", "Output file path (default: stdout)")
+	fs.StringVar(&f.excludeFile, "exclude-file", "", "File with URLs to skip, one per line")
+	fs.BoolVar(&f.noRobots, "ignore-robots", false, "Crawl pages robots.txt disallows and ignore its Crawl-delay")
+	return f
+}
 
-	// Check if context was cancelled during crawl
-	select {
-	case <-ctx.Done():
-		log.Println("Crawl was interrupted.")
-		os.Exit(1) // Or handle differently
-	default:
+func (f *crawlFlags) spiderOptions() *webspider.SpiderOptions {
+	if f.targetURL == "" {
+		log.Fatal("Please provide a target URL using the -url flag")
 	}
 
-	duration := time.Since(startTime)
-	fmt.Fprintf(os.Stderr, "Crawl completed in %v\n", duration)
-	fmt.Fprintf(os.Stderr, "Pages crawled successfully: %d\n", result.SuccessfulPages)
-	fmt.Fprintf(os.Stderr, "Pages failed: %d\n", len(result.FailedPages))
-
-	var output *os.File = os.Stdout
-	if outputFile != "" {
-		file, err := os.Create(outputFile)
+	options := &webspider.SpiderOptions{
+		MaxPages:         f.maxPages,
+		MaxDepth:         f.maxDepth,
+		Timeout:          f.timeout,
+		Concurrency:      f.concurrency,
+		DelayBetween:     f.delay,
+		CrawlSubDomain:   true,
+		RespectRobotsTxt: !f.noRobots,
+	}
+	if f.excludeFile != "" {
+		excluded, err := webspider.LoadURLList(f.excludeFile)
 		if err != nil {
-			log.Fatalf("Failed to create output file '%s': %v", outputFile, err)
+			log.Fatalf("Failed to load exclusion list: %v", err)
 		}
-		defer file.Close()
-		output = file
+		options.ExcludeURLs = excluded
 	}
+	return options
+}
 
-	_, err = fmt.Fprint(output, result.Content)
-	if err != nil {
-		log.Fatalf("Failed to write output: %v", err)
+// spider runs the crawl and prints a summary to stderr. Ctrl+C stops the
+// crawl early; the pages crawled until then are still returned.
+func (f *crawlFlags) spider(options *webspider.SpiderOptions) *webspider.SpiderResult {
+	fmt.Fprintf(os.Stderr, "Starting crawl of %s...\n", f.targetURL)
+	startTime := time.Now()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	result, err := webspider.SpiderWebsiteContext(ctx, f.targetURL, options)
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "\nReceived interrupt signal, keeping the pages crawled so far")
+	} else if err != nil {
+		log.Fatalf("Crawl failed: %v", err)
 	}
 
-	// Optionally log failed pages to stderr or a separate file
-	if len(result.FailedPages) > 0 {
-		fmt.Fprintf(os.Stderr, "\nFailed Pages:\n")
-		for url, err := range result.FailedPages {
-			fmt.Fprintf(os.Stderr, "  %s: %s\n", url, err)
-		}
+	fmt.Fprintf(os.Stderr, "Crawl completed in %v\n", time.Since(startTime))
+	fmt.Fprintf(os.Stderr, "Pages crawled successfully: %d\n", result.SuccessfulPages)
+	fmt.Fprintf(os.Stderr, "Pages failed: %d\n", len(result.FailedPages))
+	return result
+}
+
+// createOutput returns the -output file, or stdout when it is not set.
+func (f *crawlFlags) createOutput() *os.File {
+	if f.outputFile == "" {
+		return os.Stdout
 	}
-	// Optionally log detected files
-	if len(result.DetectedFileUrls) > 0 {
-		fmt.Fprintf(os.Stderr, "\nDetected File URLs (not crawled):\n")
-		for _, fileURL := range result.DetectedFileUrls {
-			fmt.Fprintf(os.Stderr, "  %s\n", fileURL)
-		}
+	file, err := os.Create(f.outputFile)
+	if err != nil {
+		log.Fatalf("Failed to create output file '%s': %v", f.outputFile, err)
 	}
+	return file
 }
@@ -0,0 +1,37 @@
+package webcrawl
+
+import (
+	"strings"
+	"unicode"
+)
+
+// extractionAgreement scores how much two extractions of a page share, from
+// 0 (no words in common) to 1 (the same words): the words they have in
+// common, counted with repetition, over the word count of the longer one. It
+// is low when one extractor picked a sidebar or a single comment instead of
+// the article the other found.
+func extractionAgreement(a, b string) float64 {
+	wordsA, wordsB := contentWords(a), contentWords(b)
+	if len(wordsA) == 0 && len(wordsB) == 0 {
+		return 1
+	}
+
+	counts := make(map[string]int, len(wordsA))
+	for _, word := range wordsA {
+		counts[word]++
+	}
+	shared := 0
+	for _, word := range wordsB {
+		if counts[word] > 0 {
+			counts[word]--
+			shared++
+		}
+	}
+	return float64(shared) / float64(max(len(wordsA), len(wordsB)))
+}
+
+func contentWords(content string) []string {
+	return strings.FieldsFunc(strings.ToLower(content), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
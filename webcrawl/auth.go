@@ -0,0 +1,93 @@
+package webcrawl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// TokenProvider returns a bearer token for the crawl, e.g. by running an
+// OAuth refresh flow. It is called for the first request and again whenever
+// the current token is rejected.
+type TokenProvider func(ctx context.Context) (string, error)
+
+type tokenTransport struct {
+	base     http.RoundTripper
+	provider TokenProvider
+
+	mu    sync.Mutex
+	token string
+}
+
+// NewTokenTransport returns a transport that authenticates requests through
+// base (the shared transport when nil) with an "Authorization: Bearer" token
+// from provider. The token is cached; when a response is 401 Unauthorized it
+// is refreshed and the request retried once.
+func NewTokenTransport(base http.RoundTripper, provider TokenProvider) http.RoundTripper {
+	if base == nil {
+		base = defaultTransport
+	}
+	return &tokenTransport{base: base, provider: provider}
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Each attempt sends a fresh copy of the body
+	if req.Body != nil && req.GetBody != nil {
+		defer req.Body.Close()
+	}
+
+	token, err := t.currentToken(req.Context(), "")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.send(req, token)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	// A body that was already sent can only be replayed through GetBody
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	token, err = t.currentToken(req.Context(), token)
+	if err != nil {
+		return nil, err
+	}
+	return t.send(req, token)
+}
+
+// currentToken returns the cached token, fetching a new one when there is
+// none or the cached one is stale. Concurrent requests rejected with the same
+// token trigger a single refresh.
+func (t *tokenTransport) currentToken(ctx context.Context, stale string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.token != "" && t.token != stale {
+		return t.token, nil
+	}
+	token, err := t.provider(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get token: %w", err)
+	}
+	t.token = token
+	return token, nil
+}
+
+func (t *tokenTransport) send(req *http.Request, token string) (*http.Response, error) {
+	authReq := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		authReq.Body = body
+	}
+	authReq.Header.Set("Authorization", "Bearer "+token)
+	return t.base.RoundTrip(authReq)
+}
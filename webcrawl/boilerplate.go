@@ -0,0 +1,78 @@
+package webcrawl
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// boilerplatePattern compiles phrases into one case-insensitive pattern in
+// which any run of whitespace in a phrase matches any other. Longer phrases
+// are tried first so one containing another is removed whole.
+func boilerplatePattern(phrases []string) *regexp.Regexp {
+	var alternatives []string
+	for _, phrase := range phrases {
+		words := strings.Fields(phrase)
+		if len(words) == 0 {
+			continue
+		}
+		for i, word := range words {
+			words[i] = regexp.QuoteMeta(word)
+		}
+		alternatives = append(alternatives, strings.Join(words, `\s+`))
+	}
+	if len(alternatives) == 0 {
+		return nil
+	}
+	sort.SliceStable(alternatives, func(i, j int) bool {
+		return len(alternatives[i]) > len(alternatives[j])
+	})
+	return regexp.MustCompile(`(?i)(?:` + strings.Join(alternatives, "|") + `)`)
+}
+
+// removeBoilerplate deletes each match of pattern that stands on word
+// boundaries, so "Sign up" is not cut out of "Design update", along with the
+// sentence punctuation that ends it.
+func removeBoilerplate(content string, pattern *regexp.Regexp) string {
+	if pattern == nil {
+		return content
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, match := range pattern.FindAllStringIndex(content, -1) {
+		start, end := match[0], match[1]
+		if start < last || !wordBoundary(content, start, end) {
+			continue
+		}
+		for end < len(content) && strings.ContainsRune(".!?:;", rune(content[end])) {
+			end++
+		}
+		b.WriteString(content[last:start])
+		last = end
+	}
+	b.WriteString(content[last:])
+	return b.String()
+}
+
+func wordBoundary(content string, start, end int) bool {
+	before, _ := utf8.DecodeLastRuneInString(content[:start])
+	after, _ := utf8.DecodeRuneInString(content[end:])
+	first, _ := utf8.DecodeRuneInString(content[start:end])
+	lastRune, _ := utf8.DecodeLastRuneInString(content[start:end])
+	// Only a phrase that starts or ends with a letter or digit can run into
+	// a neighbouring word
+	if start > 0 && isWordRune(first) && isWordRune(before) {
+		return false
+	}
+	if end < len(content) && isWordRune(lastRune) && isWordRune(after) {
+		return false
+	}
+	return true
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,115 @@
+package webcrawl
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// extractBreadcrumbs returns the names in the page's breadcrumb trail, from
+// a JSON-LD BreadcrumbList or else a <nav aria-label="breadcrumb">. It must
+// run before the document is cleaned, since navigation is removed.
+func extractBreadcrumbs(doc *goquery.Document) []string {
+	var breadcrumbs []string
+	doc.Find("script[type='application/ld+json']").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		var data interface{}
+		if err := json.Unmarshal([]byte(s.Text()), &data); err != nil {
+			return true
+		}
+		breadcrumbs = breadcrumbListNames(findBreadcrumbList(data))
+		return len(breadcrumbs) == 0
+	})
+	if len(breadcrumbs) > 0 {
+		return breadcrumbs
+	}
+
+	nav := doc.Find("nav[aria-label*='breadcrumb' i], [aria-label*='breadcrumb' i]").First()
+	items := nav.Find("li")
+	if items.Length() == 0 {
+		items = nav.Find("a")
+	}
+	items.Each(func(i int, s *goquery.Selection) {
+		if name := normalizeTitle(s.Text()); name != "" {
+			breadcrumbs = append(breadcrumbs, name)
+		}
+	})
+	return breadcrumbs
+}
+
+// findBreadcrumbList returns the first object typed BreadcrumbList anywhere
+// in a decoded JSON-LD document.
+func findBreadcrumbList(data interface{}) map[string]interface{} {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		if hasJSONLDType(v, "BreadcrumbList") {
+			return v
+		}
+		for _, child := range v {
+			if list := findBreadcrumbList(child); list != nil {
+				return list
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if list := findBreadcrumbList(child); list != nil {
+				return list
+			}
+		}
+	}
+	return nil
+}
+
+func hasJSONLDType(object map[string]interface{}, typ string) bool {
+	switch t := object["@type"].(type) {
+	case string:
+		return t == typ
+	case []interface{}:
+		for _, entry := range t {
+			if s, ok := entry.(string); ok && s == typ {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// breadcrumbListNames returns the item names of a BreadcrumbList in position
+// order. A name may sit on the ListItem or on its nested item.
+func breadcrumbListNames(list map[string]interface{}) []string {
+	elements, _ := list["itemListElement"].([]interface{})
+
+	type crumb struct {
+		position float64
+		name     string
+	}
+	var crumbs []crumb
+	for i, element := range elements {
+		item, ok := element.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := item["name"].(string)
+		if nested, ok := item["item"].(map[string]interface{}); ok && name == "" {
+			name, _ = nested["name"].(string)
+		}
+		name = normalizeTitle(name)
+		if name == "" {
+			continue
+		}
+		position, ok := item["position"].(float64)
+		if !ok {
+			position = float64(i + 1)
+		}
+		crumbs = append(crumbs, crumb{position: position, name: name})
+	}
+	sort.SliceStable(crumbs, func(i, j int) bool {
+		return crumbs[i].position < crumbs[j].position
+	})
+
+	names := make([]string, len(crumbs))
+	for i, c := range crumbs {
+		names[i] = c.name
+	}
+	return names
+}
@@ -0,0 +1,124 @@
+package webcrawl
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// DateSource says where PublishedAt came from, roughly from most to least
+// reliable.
+type DateSource string
+
+const (
+	DateSourceMeta         DateSource = "meta"          // article:published_time or itemprop meta
+	DateSourceJSONLD       DateSource = "json-ld"       // schema.org datePublished
+	DateSourceTimeElement  DateSource = "time"          // <time datetime>
+	DateSourceLastModified DateSource = "last-modified" // HTTP Last-Modified header
+)
+
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+func parseDate(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func extractPublishedAt(doc *goquery.Document, header http.Header) (time.Time, DateSource) {
+	metaSelectors := []string{
+		"meta[property='article:published_time']",
+		"meta[name='article:published_time']",
+		"meta[itemprop='datePublished']",
+	}
+	for _, selector := range metaSelectors {
+		if t, ok := parseDate(doc.Find(selector).First().AttrOr("content", "")); ok {
+			return t, DateSourceMeta
+		}
+	}
+
+	var published time.Time
+	doc.Find("script[type='application/ld+json']").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		var data interface{}
+		if err := json.Unmarshal([]byte(s.Text()), &data); err != nil {
+			return true
+		}
+		if t, ok := parseDate(findJSONString(data, "datePublished")); ok {
+			published = t
+			return false
+		}
+		return true
+	})
+	if !published.IsZero() {
+		return published, DateSourceJSONLD
+	}
+
+	timeSelectors := []string{
+		"time[itemprop='datePublished'][datetime]",
+		"time[pubdate][datetime]",
+		"time[datetime]",
+	}
+	for _, selector := range timeSelectors {
+		if t, ok := parseDate(doc.Find(selector).First().AttrOr("datetime", "")); ok {
+			return t, DateSourceTimeElement
+		}
+	}
+
+	if t, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+		return t, DateSourceLastModified
+	}
+
+	return time.Time{}, ""
+}
+
+// jsonLDChildKeys are the objects findJSONString searches first, since they
+// hold the page's own entity rather than e.g. its publisher or a related item.
+var jsonLDChildKeys = []string{"@graph", "mainEntity", "mainEntityOfPage"}
+
+// findJSONString returns the first string value stored under key anywhere in
+// a decoded JSON document, so @graph arrays and nested objects are covered.
+// Nested objects are searched in a fixed order, jsonLDChildKeys first and the
+// rest sorted, so a document with several matches always gives the same one.
+func findJSONString(data interface{}, key string) string {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		if s, ok := v[key].(string); ok {
+			return s
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			if !slices.Contains(jsonLDChildKeys, k) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range append(slices.Clone(jsonLDChildKeys), keys...) {
+			if s := findJSONString(v[k], key); s != "" {
+				return s
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if s := findJSONString(child, key); s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
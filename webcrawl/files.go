@@ -0,0 +1,154 @@
+package webcrawl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+)
+
+type FileInfo struct {
+	URL         string `json:"url"`
+	Size        int64  `json:"size,omitempty"` // Content-Length; 0 when unknown
+	ContentType string `json:"content_type,omitempty"`
+	Filename    string `json:"filename,omitempty"`
+	Referrer    string `json:"referrer,omitempty"` // Page the file was linked from
+	Text        string `json:"text,omitempty"`     // Anchor text of that link
+}
+
+// InspectFile issues a HEAD request for fileURL and reports its size, type and
+// filename without downloading the body.
+func InspectFile(fileURL string, options *CrawlOptions) (*FileInfo, error) {
+	return InspectFileContext(context.Background(), fileURL, options)
+}
+
+// InspectFileContext is InspectFile with a context that can cancel the
+// request.
+func InspectFileContext(ctx context.Context, fileURL string, options *CrawlOptions) (*FileInfo, error) {
+	if options == nil {
+		options = DefaultCrawlOptions()
+	}
+
+	client := &http.Client{
+		Transport: options.transport(),
+		Timeout:   options.Timeout,
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", fileURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", options.UserAgent)
+	options.addRequestHeaders(req)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if isTimeout(err) {
+			return nil, fmt.Errorf("failed to inspect file: %w: %w", ErrTimeout, err)
+		}
+		return nil, fmt.Errorf("failed to inspect file: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: resp.StatusCode}
+	}
+
+	info := fileInfo(fileURL, resp)
+	if resp.ContentLength > 0 {
+		info.Size = resp.ContentLength
+	}
+	return info, nil
+}
+
+// DownloadFile fetches fileURL and copies its body to w, reporting its size,
+// type and filename like InspectFile. Bodies over MaxBodySize fail with
+// ErrBodyTooLarge after MaxBodySize bytes have been written.
+func DownloadFile(fileURL string, w io.Writer, options *CrawlOptions) (*FileInfo, error) {
+	return DownloadFileContext(context.Background(), fileURL, w, options)
+}
+
+// DownloadFileContext is DownloadFile with a context that can cancel the
+// download, including while the body is copied.
+func DownloadFileContext(ctx context.Context, fileURL string, w io.Writer, options *CrawlOptions) (*FileInfo, error) {
+	if options == nil {
+		options = DefaultCrawlOptions()
+	}
+
+	client := &http.Client{
+		Transport: options.transport(),
+		Timeout:   options.Timeout,
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", fileURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", options.UserAgent)
+	options.addRequestHeaders(req)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if isTimeout(err) {
+			return nil, fmt.Errorf("failed to download file: %w: %w", ErrTimeout, err)
+		}
+		return nil, fmt.Errorf("failed to download file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		statusErr := &StatusError{StatusCode: resp.StatusCode}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			statusErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		}
+		return nil, statusErr
+	}
+
+	var body io.Reader = resp.Body
+	if options.MaxBodySize > 0 {
+		body = io.LimitReader(body, options.MaxBodySize+1)
+	}
+	size, err := io.Copy(w, body)
+	if err != nil {
+		if isTimeout(err) {
+			return nil, fmt.Errorf("failed to download file: %w: %w", ErrTimeout, err)
+		}
+		return nil, fmt.Errorf("failed to download file: %w", err)
+	}
+	if options.MaxBodySize > 0 && size > options.MaxBodySize {
+		return nil, fmt.Errorf("%w: exceeds %d bytes", ErrBodyTooLarge, options.MaxBodySize)
+	}
+
+	info := fileInfo(fileURL, resp)
+	info.Size = size
+	return info, nil
+}
+
+// fileInfo reads the type and filename of a file response. The filename
+// comes from Content-Disposition, falling back to the last segment of the
+// final URL.
+func fileInfo(fileURL string, resp *http.Response) *FileInfo {
+	info := &FileInfo{
+		URL:         fileURL,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		info.Filename = params["filename"]
+	}
+	if info.Filename == "" {
+		info.Filename = filenameFromURL(resp.Request.URL)
+	}
+	return info
+}
+
+func filenameFromURL(u *url.URL) string {
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,40 @@
+package webcrawl
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// extractIframes returns the resolved http(s) sources of the page's iframes,
+// including lazy-loaded ones that keep the URL in data-src, without
+// duplicates.
+func extractIframes(doc *goquery.Document, pageURL string) []string {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil
+	}
+
+	var iframes []string
+	seen := make(map[string]bool)
+	doc.Find("iframe").Each(func(i int, s *goquery.Selection) {
+		src := strings.TrimSpace(s.AttrOr("src", ""))
+		if src == "" || strings.EqualFold(src, "about:blank") {
+			src = strings.TrimSpace(s.AttrOr("data-src", ""))
+		}
+		ref, err := url.Parse(src)
+		if src == "" || err != nil {
+			return
+		}
+		resolved := base.ResolveReference(ref)
+		if resolved.Scheme != "http" && resolved.Scheme != "https" {
+			return
+		}
+		if !seen[resolved.String()] {
+			seen[resolved.String()] = true
+			iframes = append(iframes, resolved.String())
+		}
+	})
+	return iframes
+}
@@ -0,0 +1,143 @@
+package webcrawl
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+type ImageData struct {
+	URL        string `json:"url"`
+	Alt        string `json:"alt,omitempty"`
+	Descriptor string `json:"descriptor,omitempty"` // srcset width/density descriptor, e.g. "800w" or "2x"
+}
+
+type srcsetCandidate struct {
+	url        string
+	descriptor string
+}
+
+func extractImages(doc *goquery.Document, targetURL string) []ImageData {
+	base, err := url.Parse(targetURL)
+	if err != nil {
+		base = &url.URL{}
+	}
+
+	var images []ImageData
+	seen := make(map[string]bool)
+	add := func(rawURL, alt, descriptor string) {
+		rawURL = strings.TrimSpace(rawURL)
+		if rawURL == "" || strings.HasPrefix(rawURL, "data:") {
+			return
+		}
+		ref, err := url.Parse(rawURL)
+		if err != nil {
+			return
+		}
+		resolved := base.ResolveReference(ref).String()
+		if seen[resolved] {
+			return
+		}
+		seen[resolved] = true
+		images = append(images, ImageData{URL: resolved, Alt: alt, Descriptor: descriptor})
+	}
+
+	doc.Find("img, picture source[srcset]").Each(func(i int, s *goquery.Selection) {
+		alt := strings.TrimSpace(s.AttrOr("alt", ""))
+		if goquery.NodeName(s) == "source" {
+			// <source> has no alt; use the one on the picture's fallback <img>
+			alt = strings.TrimSpace(s.Parent().Find("img").AttrOr("alt", ""))
+		}
+
+		add(s.AttrOr("src", ""), alt, "")
+		for _, candidate := range parseSrcset(s.AttrOr("srcset", "")) {
+			add(candidate.url, alt, candidate.descriptor)
+		}
+	})
+
+	return images
+}
+
+var faviconRels = map[string]bool{
+	"icon":                         true,
+	"apple-touch-icon":             true,
+	"apple-touch-icon-precomposed": true,
+}
+
+// extractFavicons returns the icon links declared in the document followed by
+// the implicit /favicon.ico that browsers request when none are declared.
+func extractFavicons(doc *goquery.Document, targetURL string) []string {
+	base, err := url.Parse(targetURL)
+	if err != nil {
+		return nil
+	}
+
+	var icons []string
+	seen := make(map[string]bool)
+	add := func(href string) {
+		ref, err := url.Parse(strings.TrimSpace(href))
+		if err != nil {
+			return
+		}
+		resolved := base.ResolveReference(ref).String()
+		if !seen[resolved] {
+			seen[resolved] = true
+			icons = append(icons, resolved)
+		}
+	}
+
+	doc.Find("link[rel][href]").Each(func(i int, s *goquery.Selection) {
+		for _, rel := range strings.Fields(strings.ToLower(s.AttrOr("rel", ""))) {
+			if faviconRels[rel] {
+				add(s.AttrOr("href", ""))
+				return
+			}
+		}
+	})
+
+	if base.Scheme == "http" || base.Scheme == "https" {
+		add("/favicon.ico")
+	}
+	return icons
+}
+
+// parseSrcset splits a srcset attribute into its image candidates. URLs may
+// themselves contain commas, so a candidate only ends at a comma that follows
+// the URL's whitespace or descriptor.
+func parseSrcset(srcset string) []srcsetCandidate {
+	var candidates []srcsetCandidate
+
+	rest := srcset
+	for {
+		rest = strings.TrimLeft(rest, " \t\n\r\f,")
+		if rest == "" {
+			return candidates
+		}
+
+		end := strings.IndexAny(rest, " \t\n\r\f")
+		if end < 0 {
+			end = len(rest)
+		}
+		candidateURL := rest[:end]
+		rest = rest[end:]
+
+		// A URL directly followed by commas has no descriptor
+		if trimmed := strings.TrimRight(candidateURL, ","); trimmed != candidateURL {
+			candidates = append(candidates, srcsetCandidate{url: trimmed})
+			continue
+		}
+
+		descriptor := rest
+		if comma := strings.IndexByte(rest, ','); comma >= 0 {
+			descriptor = rest[:comma]
+			rest = rest[comma+1:]
+		} else {
+			rest = ""
+		}
+		candidates = append(candidates, srcsetCandidate{
+			url:        candidateURL,
+			descriptor: strings.TrimSpace(descriptor),
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package webcrawl
+
+import (
+	"net/url"
+	"strings"
+)
+
+// parseLinkHeader parses RFC 8288 Link header values such as
+// `</page/2>; rel="next", <https://example.com/a>; rel=canonical` into a map
+// from each rel value to its target, resolved against targetURL. The first
+// target for a rel wins.
+func parseLinkHeader(values []string, targetURL string) map[string]string {
+	base, err := url.Parse(targetURL)
+	if err != nil {
+		return nil
+	}
+
+	links := make(map[string]string)
+	for _, value := range values {
+		for value != "" {
+			value = strings.TrimLeft(value, " \t,")
+			if !strings.HasPrefix(value, "<") {
+				break
+			}
+			end := strings.IndexByte(value, '>')
+			if end < 0 {
+				break
+			}
+			target := value[1:end]
+			value = value[end+1:]
+
+			var params string
+			params, value = splitLinkParams(value)
+
+			ref, err := url.Parse(strings.TrimSpace(target))
+			if err != nil {
+				continue
+			}
+			resolved := base.ResolveReference(ref).String()
+
+			for _, param := range splitQuoted(params, ';') {
+				name, val, ok := strings.Cut(param, "=")
+				if !ok || !strings.EqualFold(strings.TrimSpace(name), "rel") {
+					continue
+				}
+				val = strings.Trim(strings.TrimSpace(val), `"`)
+				for _, rel := range strings.Fields(strings.ToLower(val)) {
+					if _, exists := links[rel]; !exists {
+						links[rel] = resolved
+					}
+				}
+			}
+		}
+	}
+	return links
+}
+
+// splitLinkParams returns the parameters of the current link-value and the
+// remainder of the header after the comma that ends it.
+func splitLinkParams(s string) (string, string) {
+	inQuotes := false
+	for i, r := range s {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+		case r == ',' && !inQuotes:
+			return s[:i], s[i+1:]
+		}
+	}
+	return s, ""
+}
+
+func splitQuoted(s string, sep rune) []string {
+	var parts []string
+	inQuotes := false
+	start := 0
+	for i, r := range s {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+		case r == sep && !inQuotes:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
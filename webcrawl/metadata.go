@@ -0,0 +1,63 @@
+package webcrawl
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+type Metadata struct {
+	Title     string // First usable title in CrawlOptions.TitleSources order
+	HTMLTitle string // <title> element text
+	OGTitle   string // og:title
+	SiteName  string // og:site_name
+}
+
+type TitleSource string
+
+const (
+	TitleOpenGraph TitleSource = "og:title"
+	TitleHTML      TitleSource = "title"
+	TitleHeading   TitleSource = "h1"
+)
+
+// DefaultTitleSources prefers og:title, which is usually the article title
+// without the " | Site" suffix many <title> elements carry.
+var DefaultTitleSources = []TitleSource{TitleOpenGraph, TitleHTML, TitleHeading}
+
+// extractMetadata reads the page titles from <head>. It must run before the
+// document is cleaned, since the first <h1> may sit in a removed header.
+func extractMetadata(doc *goquery.Document, sources []TitleSource) Metadata {
+	metadata := Metadata{
+		HTMLTitle: normalizeTitle(doc.Find("title").First().Text()),
+		OGTitle:   normalizeTitle(doc.Find("meta[property='og:title']").AttrOr("content", "")),
+		SiteName:  normalizeTitle(doc.Find("meta[property='og:site_name']").AttrOr("content", "")),
+	}
+
+	if sources == nil {
+		sources = DefaultTitleSources
+	}
+	for _, source := range sources {
+		var title string
+		switch source {
+		case TitleOpenGraph:
+			// An og:title that only repeats the site name says nothing about the page
+			if !strings.EqualFold(metadata.OGTitle, metadata.SiteName) {
+				title = metadata.OGTitle
+			}
+		case TitleHTML:
+			title = metadata.HTMLTitle
+		case TitleHeading:
+			title = normalizeTitle(doc.Find("h1").First().Text())
+		}
+		if title != "" {
+			metadata.Title = title
+			break
+		}
+	}
+	return metadata
+}
+
+func normalizeTitle(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,103 @@
+package webcrawl
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// MicrodataItem is an HTML Microdata item (itemscope) in the same shape as the
+// WHATWG JSON conversion. Property values are either strings or nested
+// *MicrodataItem values.
+type MicrodataItem struct {
+	Type       []string                 `json:"type,omitempty"`
+	ID         string                   `json:"id,omitempty"`
+	Properties map[string][]interface{} `json:"properties"`
+}
+
+func extractMicrodata(doc *goquery.Document, targetURL string) []MicrodataItem {
+	base, err := url.Parse(targetURL)
+	if err != nil {
+		base = &url.URL{}
+	}
+
+	var items []MicrodataItem
+	doc.Find("[itemscope]").Each(func(i int, s *goquery.Selection) {
+		// Items with an itemprop are property values of another item
+		if _, isProp := s.Attr("itemprop"); isProp {
+			return
+		}
+		items = append(items, *parseMicrodataItem(s, base))
+	})
+	return items
+}
+
+func parseMicrodataItem(s *goquery.Selection, base *url.URL) *MicrodataItem {
+	itemType, _ := s.Attr("itemtype")
+	itemID, _ := s.Attr("itemid")
+
+	item := &MicrodataItem{
+		Type:       strings.Fields(itemType),
+		ID:         strings.TrimSpace(itemID),
+		Properties: make(map[string][]interface{}),
+	}
+	collectMicrodataProperties(s, item, base)
+	return item
+}
+
+func collectMicrodataProperties(s *goquery.Selection, item *MicrodataItem, base *url.URL) {
+	s.Children().Each(func(i int, child *goquery.Selection) {
+		names, hasProp := child.Attr("itemprop")
+		_, isScope := child.Attr("itemscope")
+
+		if hasProp {
+			var value interface{}
+			if isScope {
+				value = parseMicrodataItem(child, base)
+			} else {
+				value = microdataValue(child, base)
+			}
+			for _, name := range strings.Fields(names) {
+				item.Properties[name] = append(item.Properties[name], value)
+			}
+		}
+
+		// Properties below a nested itemscope belong to that item
+		if !isScope {
+			collectMicrodataProperties(child, item, base)
+		}
+	})
+}
+
+func microdataValue(s *goquery.Selection, base *url.URL) string {
+	switch goquery.NodeName(s) {
+	case "meta":
+		return s.AttrOr("content", "")
+	case "audio", "embed", "iframe", "img", "source", "track", "video":
+		return resolveAttr(s, "src", base)
+	case "a", "area", "link":
+		return resolveAttr(s, "href", base)
+	case "object":
+		return resolveAttr(s, "data", base)
+	case "data", "meter":
+		return s.AttrOr("value", "")
+	case "time":
+		if datetime, ok := s.Attr("datetime"); ok {
+			return datetime
+		}
+	}
+	return strings.TrimSpace(s.Text())
+}
+
+func resolveAttr(s *goquery.Selection, attr string, base *url.URL) string {
+	value := strings.TrimSpace(s.AttrOr(attr, ""))
+	if value == "" {
+		return ""
+	}
+	ref, err := url.Parse(value)
+	if err != nil {
+		return value
+	}
+	return base.ResolveReference(ref).String()
+}
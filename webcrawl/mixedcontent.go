@@ -0,0 +1,57 @@
+package webcrawl
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Elements that load a subresource, and the attribute holding its URL
+var subresourceAttributes = []struct {
+	selector string
+	attr     string
+}{
+	{"img[src], script[src], iframe[src], embed[src], audio[src], video[src], source[src], track[src], input[type='image' i][src]", "src"},
+	{"link[rel~='stylesheet' i][href], link[rel~='icon' i][href], link[rel~='preload' i][href], link[rel~='manifest' i][href]", "href"},
+	{"video[poster]", "poster"},
+	{"object[data]", "data"},
+	{"form[action]", "action"},
+}
+
+// extractMixedContent returns the http:// subresources referenced by an
+// https:// page, each once. Navigation links are not mixed content and are
+// ignored.
+func extractMixedContent(doc *goquery.Document, pageURL string) []string {
+	base, err := url.Parse(pageURL)
+	if err != nil || base.Scheme != "https" {
+		return nil
+	}
+
+	var insecure []string
+	seen := make(map[string]bool)
+	add := func(rawURL string) {
+		ref, err := url.Parse(strings.TrimSpace(rawURL))
+		if err != nil {
+			return
+		}
+		resolved := base.ResolveReference(ref)
+		if resolved.Scheme != "http" || seen[resolved.String()] {
+			return
+		}
+		seen[resolved.String()] = true
+		insecure = append(insecure, resolved.String())
+	}
+
+	for _, sub := range subresourceAttributes {
+		doc.Find(sub.selector).Each(func(i int, s *goquery.Selection) {
+			add(s.AttrOr(sub.attr, ""))
+		})
+	}
+	doc.Find("img[srcset], source[srcset]").Each(func(i int, s *goquery.Selection) {
+		for _, candidate := range parseSrcset(s.AttrOr("srcset", "")) {
+			add(candidate.url)
+		}
+	})
+	return insecure
+}
@@ -0,0 +1,74 @@
+package webcrawl
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// OCR recovers the text embedded in an image, e.g. by calling out to an OCR
+// engine such as Tesseract or a cloud vision API.
+type OCR interface {
+	Recognize(image []byte, contentType string) (string, error)
+}
+
+// NoOCR recognizes no text.
+type NoOCR struct{}
+
+func (NoOCR) Recognize(image []byte, contentType string) (string, error) {
+	return "", nil
+}
+
+// imageText downloads an <img> without alt text and returns the text OCR
+// finds in it, formatted for the content. Failures leave the image out.
+func (c *textConverter) imageText(s *goquery.Selection) string {
+	if !c.options.OCRImages || c.options.OCR == nil || strings.TrimSpace(s.AttrOr("alt", "")) != "" {
+		return ""
+	}
+	src := strings.TrimSpace(s.AttrOr("src", ""))
+	if src == "" || strings.HasPrefix(src, "data:") {
+		return ""
+	}
+	ref, err := url.Parse(src)
+	if err != nil {
+		return ""
+	}
+	imageURL := c.base.ResolveReference(ref).String()
+
+	if text, ok := c.ocrText[imageURL]; ok {
+		return text
+	}
+	text := ""
+	if recognized, err := c.recognize(imageURL); err == nil && recognized != "" {
+		text = fmt.Sprintf(" [Image text: %s] ", strings.Join(strings.Fields(recognized), " "))
+	}
+	if c.ocrText == nil {
+		c.ocrText = make(map[string]string)
+	}
+	c.ocrText[imageURL] = text
+	return text
+}
+
+// recognize fetches imageURL as a plain GET with the page's client settings
+// and passes it to OCR.
+func (c *textConverter) recognize(imageURL string) (string, error) {
+	options := *c.options
+	options.Method = ""
+	options.Body = nil
+	options.ContentType = ""
+	options.IfModifiedSince = time.Time{}
+	options.CaptureErrorPages = false
+
+	fetch := FetchPageContext
+	if c.options.FetchImage != nil {
+		fetch = c.options.FetchImage
+	}
+	image, err := fetch(c.ctx, imageURL, &options)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch image: %w", err)
+	}
+	return c.options.OCR.Recognize(image.Body, image.Header.Get("Content-Type"))
+}
@@ -0,0 +1,74 @@
+package webcrawl
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retryableFetchError reports whether another attempt could succeed:
+// network errors, timeouts, 429 and 5xx responses.
+func retryableFetchError(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	if errors.Is(err, ErrTooManyRedirects) || errors.Is(err, ErrBodyTooLarge) {
+		return false
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		// A URL that doesn't parse won't parse next time either
+		return urlErr.Op != "parse"
+	}
+	// The connection broke while the body was read
+	var netErr net.Error
+	return errors.Is(err, ErrTimeout) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr)
+}
+
+// RetryDelay reports whether a request that failed with err on the given
+// attempt, counting from 1, should be tried again under options' MaxRetries
+// and RetryBackoff, and how long to wait first. FetchPageContext retries
+// this way; it is exported for callers making their own requests.
+func RetryDelay(err error, attempt int, options *CrawlOptions) (time.Duration, bool) {
+	if err == nil || attempt > options.MaxRetries || !retryableFetchError(err) {
+		return 0, false
+	}
+	return retryWait(err, options.RetryBackoff, attempt)
+}
+
+// maxRetryAfter is the longest Retry-After always waited for; see
+// CrawlOptions.MaxRetries
+const maxRetryAfter = time.Minute
+
+// retryWait returns how long to wait before retrying after err, and false if
+// the server's Retry-After asks for longer than is worth waiting.
+func retryWait(err error, backoff time.Duration, attempt int) (time.Duration, bool) {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.RetryAfter <= 0 {
+		return backoff * time.Duration(attempt), true
+	}
+	limit := max(backoff<<min(attempt, 16), maxRetryAfter)
+	return statusErr.RetryAfter, statusErr.RetryAfter <= limit
+}
+
+// parseRetryAfter reads a Retry-After header, given either in seconds or as
+// an HTTP date. It returns 0 if the header is missing or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return 0
+}
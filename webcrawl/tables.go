@@ -0,0 +1,61 @@
+package webcrawl
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Table is the cell matrix of an HTML table. Cells spanning several columns
+// are followed by empty cells so columns stay aligned; rowspan is ignored.
+type Table struct {
+	Caption string     `json:"caption,omitempty"`
+	Rows    [][]string `json:"rows"`
+}
+
+func (t *Table) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.WriteAll(t.Rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+func extractTables(doc *goquery.Document) []Table {
+	var tables []Table
+
+	doc.Find("table").Each(func(i int, s *goquery.Selection) {
+		table := Table{
+			Caption: normalizeCellText(s.ChildrenFiltered("caption").Text()),
+		}
+
+		// Only direct rows, so nested tables don't leak into their parent
+		rows := s.ChildrenFiltered("tr").AddSelection(s.ChildrenFiltered("thead, tbody, tfoot").ChildrenFiltered("tr"))
+		rows.Each(func(j int, row *goquery.Selection) {
+			var cells []string
+			row.ChildrenFiltered("th, td").Each(func(k int, cell *goquery.Selection) {
+				cells = append(cells, normalizeCellText(cell.Text()))
+				span, _ := strconv.Atoi(cell.AttrOr("colspan", "1"))
+				for ; span > 1 && span <= 1000; span-- {
+					cells = append(cells, "")
+				}
+			})
+			if len(cells) > 0 {
+				table.Rows = append(table.Rows, cells)
+			}
+		})
+
+		if len(table.Rows) > 0 {
+			tables = append(tables, table)
+		}
+	})
+
+	return tables
+}
+
+func normalizeCellText(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,275 @@
+package webcrawl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultTransport is shared by all crawls so connections are reused. It
+// only speaks HTTP; file:// URLs go through a NewFileTransport. It dials like
+// http.DefaultTransport, racing IPv6 and IPv4 addresses.
+var defaultTransport = http.DefaultTransport.(*http.Transport).Clone()
+
+// How long resolved addresses are reused before looking the host up again
+const dnsCacheTTL = 5 * time.Minute
+
+// How long the first address family gets before the other is tried as well,
+// the default of net.Dialer.FallbackDelay
+const dialFallbackDelay = 300 * time.Millisecond
+
+// NewTransport returns a transport that caches DNS lookups made through
+// resolver (net.DefaultResolver when nil). Hosts in hostIPOverride are dialed
+// at the given IP without a lookup, which is useful for pinning a hostname to
+// a staging server; TLS still verifies against the original hostname. Like
+// net.Dialer, it races the IPv6 and IPv4 addresses of a host.
+func NewTransport(resolver *net.Resolver, hostIPOverride map[string]string) *http.Transport {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	cache := &dnsCache{
+		resolver:  resolver,
+		overrides: hostIPOverride,
+		dialer:    &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+		entries:   make(map[string]dnsEntry),
+		inflight:  make(map[string]*dnsLookup),
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = cache.dialContext
+	return transport
+}
+
+type fileTransport struct {
+	root  string
+	files http.RoundTripper
+}
+
+// NewFileTransport serves file:// URLs below the directory root, which lets a
+// downloaded mirror of a site be crawled offline. Requests for other schemes
+// or for files outside root fail.
+func NewFileTransport(root string) http.RoundTripper {
+	root = path.Clean("/" + root)
+	return &fileTransport{root: root, files: http.NewFileTransport(http.Dir(root))}
+}
+
+func (t *fileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "file" {
+		return nil, fmt.Errorf("unsupported protocol scheme %q for a local mirror", req.URL.Scheme)
+	}
+	p := path.Clean("/" + req.URL.Path)
+	rel, ok := strings.CutPrefix(p, t.root)
+	if !ok || (rel != "" && !strings.HasPrefix(rel, "/") && t.root != "/") {
+		return nil, fmt.Errorf("%s is outside the mirror directory %s", p, t.root)
+	}
+	if t.root == "/" {
+		rel = p
+	}
+
+	// http.Dir resolves the path relative to root and refuses ".." segments
+	local := req.Clone(req.Context())
+	local.URL.Path = "/" + strings.TrimPrefix(rel, "/")
+	local.URL.RawPath = ""
+	resp, err := t.files.RoundTrip(local)
+	if resp != nil {
+		resp.Request = req
+	}
+	return resp, err
+}
+
+// SetTransportTimeouts bounds how long transport may take to resolve and
+// connect to a host, and then to receive the response headers. Zero leaves a
+// limit unchanged. Reading the body is still only bounded by the client's
+// overall timeout, so large downloads are not cut short by a fast-fail dial.
+func SetTransportTimeouts(transport *http.Transport, dialTimeout, responseHeaderTimeout time.Duration) {
+	if dialTimeout > 0 {
+		dial := transport.DialContext
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+			defer cancel()
+			return dial(ctx, network, addr)
+		}
+	}
+	if responseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = responseHeaderTimeout
+	}
+}
+
+// Shared transports for each combination of CrawlOptions timeouts
+var (
+	timeoutTransportsMu sync.Mutex
+	timeoutTransports   = make(map[[2]time.Duration]*http.Transport)
+)
+
+func timeoutTransport(dialTimeout, responseHeaderTimeout time.Duration) *http.Transport {
+	key := [2]time.Duration{dialTimeout, responseHeaderTimeout}
+
+	timeoutTransportsMu.Lock()
+	defer timeoutTransportsMu.Unlock()
+	transport, ok := timeoutTransports[key]
+	if !ok {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+		SetTransportTimeouts(transport, dialTimeout, responseHeaderTimeout)
+		timeoutTransports[key] = transport
+	}
+	return transport
+}
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// dnsLookup is a lookup in progress, which concurrent dials of the same host
+// wait for instead of starting their own
+type dnsLookup struct {
+	done  chan struct{}
+	addrs []string
+	err   error
+}
+
+type dnsCache struct {
+	resolver  *net.Resolver
+	overrides map[string]string
+	dialer    *net.Dialer
+
+	mu       sync.Mutex
+	entries  map[string]dnsEntry
+	inflight map[string]*dnsLookup
+}
+
+func (c *dnsCache) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if ip, ok := c.overrides[host]; ok {
+		return c.dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+	}
+	if net.ParseIP(host) != nil {
+		return c.dialer.DialContext(ctx, network, addr)
+	}
+
+	addrs, err := c.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	return c.dialParallel(ctx, network, addrs, port)
+}
+
+// dialParallel dials the addresses of the first one's family in turn, and
+// the other family's once that fails or dialFallbackDelay passes, returning
+// the first connection made.
+func (c *dnsCache) dialParallel(ctx context.Context, network string, addrs []string, port string) (net.Conn, error) {
+	var primaries, fallbacks []string
+	for _, ip := range addrs {
+		if isIPv4(ip) == isIPv4(addrs[0]) {
+			primaries = append(primaries, ip)
+		} else {
+			fallbacks = append(fallbacks, ip)
+		}
+	}
+	if len(fallbacks) == 0 {
+		return c.dialSerial(ctx, network, primaries, port)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan dialResult, 2)
+	dial := func(ips []string) {
+		conn, err := c.dialSerial(ctx, network, ips, port)
+		results <- dialResult{conn, err}
+	}
+	go dial(primaries)
+	pending, fallbackStarted := 1, false
+	timer := time.NewTimer(dialFallbackDelay)
+	defer timer.Stop()
+
+	var dialErr error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				// The other dial may connect before it sees the cancel
+				go func(pending int) {
+					for range pending {
+						if other := <-results; other.conn != nil {
+							other.conn.Close()
+						}
+					}
+				}(pending)
+				return result.conn, nil
+			}
+			dialErr = errors.Join(dialErr, result.err)
+		}
+		if !fallbackStarted {
+			fallbackStarted = true
+			pending++
+			go dial(fallbacks)
+		}
+	}
+	return nil, dialErr
+}
+
+func (c *dnsCache) dialSerial(ctx context.Context, network string, ips []string, port string) (net.Conn, error) {
+	var dialErr error
+	for _, ip := range ips {
+		conn, err := c.dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		dialErr = errors.Join(dialErr, err)
+	}
+	return nil, dialErr
+}
+
+func isIPv4(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && parsed.To4() != nil
+}
+
+// lookup resolves host, reusing cached addresses and joining a lookup of the
+// same host that is already in flight.
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	if entry, ok := c.entries[host]; ok && time.Now().Before(entry.expires) {
+		c.mu.Unlock()
+		return entry.addrs, nil
+	}
+	if call, ok := c.inflight[host]; ok {
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.addrs, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	call := &dnsLookup{done: make(chan struct{})}
+	c.inflight[host] = call
+	c.mu.Unlock()
+
+	call.addrs, call.err = c.resolver.LookupHost(ctx, host)
+
+	c.mu.Lock()
+	delete(c.inflight, host)
+	if call.err == nil {
+		c.entries[host] = dnsEntry{addrs: call.addrs, expires: time.Now().Add(dnsCacheTTL)}
+	}
+	c.mu.Unlock()
+	close(call.done)
+	return call.addrs, call.err
+}
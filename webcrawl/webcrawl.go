@@ -1,23 +1,95 @@
 package webcrawl
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"path"
 	"regexp"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/go-shiori/go-readability"
 )
 
 type CrawlResult struct {
-	Content      string
-	CrawledURLs  []string
-	PagesCrawled int
-	PageErrors   map[string]string
-	Links        Links
+	Content         string
+	CrawledURLs     []string
+	PagesCrawled    int
+	PageErrors      map[string]string
+	Links           Links
+	Metadata        Metadata
+	MetaRefreshURL  string
+	BytesDownloaded int64
+	Microdata       []MicrodataItem
+	Headings        []Heading
+	StatusCode      int
+	NextURL         string // rel="next" pagination target, if declared
+	PrevURL         string // rel="prev" pagination target, if declared
+	CanonicalURL    string // rel="canonical" target, if declared
+	AMPURL          string // rel="amphtml" target, if declared
+	HasLoginForm    bool   // Page contains a password field
+	HasPaywall      bool   // Page declares itself paywalled (og:type or isAccessibleForFree)
+	PublishedAt     time.Time
+	PublishedSource DateSource // Where PublishedAt came from; empty if not found
+	LastModified    time.Time  // Last-Modified header, if sent
+	FinalURL        string     // URL the content was served from after redirects
+	RedirectChain   []string
+	Images          []ImageData
+	Favicons        []string
+	Tables          []Table
+	MixedContent    []string // http:// subresources of an https:// page, with DetectMixedContent
+	Breadcrumbs     []string // Breadcrumb trail names, from JSON-LD or a breadcrumb <nav>
+	Iframes         []string // Resolved iframe sources, including ones the cleanup removes
+	// ExtractionAgreement is the share of words readability and manual
+	// extraction agree on, from 0 to 1, with CompareExtractors. It is 1 when
+	// readability failed and there was nothing to compare.
+	ExtractionAgreement float64
+}
+
+// FetchedPage is a response downloaded by FetchPage, waiting to be parsed
+// with ExtractPage.
+type FetchedPage struct {
+	URL           string // The URL that was requested
+	FinalURL      string // URL the body was served from after redirects
+	StatusCode    int
+	Header        http.Header
+	Body          []byte
+	RedirectChain []string
+}
+
+// Errors returned by CrawlWebsite wrap one of these so callers can classify
+// failures with errors.Is.
+var (
+	ErrTimeout          = errors.New("request timed out")
+	ErrNonOK            = errors.New("received non-OK status code")
+	ErrBodyTooLarge     = errors.New("response body too large")
+	ErrRobotsDisallowed = errors.New("disallowed by robots.txt")
+	ErrParse            = errors.New("failed to parse HTML")
+	ErrTooManyRedirects = errors.New("too many redirects")
+	ErrNotModified      = errors.New("not modified since IfModifiedSince")
+)
+
+// StatusError reports a response with a non-OK status code. It matches
+// ErrNonOK with errors.Is.
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration // From the Retry-After header of 429 and 503 responses
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %d", ErrNonOK, e.StatusCode)
+}
+
+func (e *StatusError) Is(target error) bool {
+	return target == ErrNonOK
 }
 
 type CrawlOptions struct {
@@ -29,19 +101,160 @@ type CrawlOptions struct {
 	RemovePopups     bool
 	ExtractMainOnly  bool
 	FollowRedirects  bool
+	MaxRedirects     int   // Redirects to follow before failing; 0 means 10
+	MaxBodySize      int64 // Maximum response body size in bytes; 0 means no limit
+	TruncateBody     bool  // Keep the first MaxBodySize bytes of a larger body instead of failing
+	KeepNoscript     bool  // Keep <noscript> fallback content instead of removing it
+	PreserveLinks    bool  // Render anchors as markdown links instead of plain text
+	ExtractImages    bool  // Collect an inventory of images, including srcset candidates
+	DefinitionLists  bool  // Render <dl> as "**term**" lines followed by ": definition"
+	// PreserveFormatting renders <strong>/<b> as **bold** and <em>/<i> as
+	// *italic*.
+	PreserveFormatting bool
+	// DetectMixedContent reports the http:// images, scripts, stylesheets
+	// and other subresources of https:// pages in MixedContent.
+	DetectMixedContent bool
+	// TitleSources is the order in which Metadata.Title is taken from the
+	// page; nil means DefaultTitleSources.
+	TitleSources []TitleSource
+	// ClassifyLinks tags every link with the page region it was found in,
+	// LinkData.Source, whether or not the Remove options strip that region;
+	// the links of stripped regions are still reported. Without it only
+	// links in the content are reported.
+	ClassifyLinks bool
+	// LinkAttributes are the attributes links are read from; nil means
+	// DefaultLinkAttributes. "href" is only read from <a> elements, others
+	// (e.g. "data-href" on JavaScript-driven sites) from any element.
+	LinkAttributes []string
+	// FollowOnclick also reads link targets from onclick handlers that
+	// assign a literal URL to location or pass one to window.open, as in
+	// <a href="#" onclick="location='/page'">. It is a heuristic; other
+	// scripts are ignored.
+	FollowOnclick bool
+	// CompareExtractors also runs the extractor ExtractMainOnly didn't pick
+	// (manual extraction for readability and vice versa) and reports how
+	// well the two agree in ExtractionAgreement. A low score suggests the
+	// main content was misidentified. Content is unaffected.
+	CompareExtractors bool
+	// OCRImages downloads content images that have no alt text and adds the
+	// text OCR recognizes in them. Without OCR set nothing is downloaded.
+	// Images are fetched with FetchImage, or with FetchPageContext and these
+	// options' client settings when it is nil.
+	OCRImages  bool
+	OCR        OCR
+	FetchImage func(ctx context.Context, imageURL string, options *CrawlOptions) (*FetchedPage, error)
+	// Transport sends the requests, e.g. to add logging, sign requests or
+	// replay recorded responses in tests. nil uses a shared transport; see
+	// NewTransport for one with DNS overrides.
+	Transport http.RoundTripper
+	// DialTimeout limits DNS lookup and connecting, and ResponseHeaderTimeout
+	// the wait for response headers once the request is sent, so unreachable
+	// hosts fail fast while Timeout still allows long body downloads. They
+	// are ignored when Transport is set; see SetTransportTimeouts.
+	DialTimeout           time.Duration
+	ResponseHeaderTimeout time.Duration
+	// IfModifiedSince makes the request conditional; a 304 response fails
+	// with ErrNotModified without a body being downloaded.
+	IfModifiedSince time.Time
+	// Method defaults to GET. With POST, Body is sent with ContentType, for
+	// pages such as search results that are only reachable through a form.
+	Method      string
+	Body        []byte
+	ContentType string
+	// Whitespace controls how runs of whitespace in the extracted text are
+	// normalized. <pre> blocks are always kept verbatim.
+	Whitespace WhitespacePolicy
+	// TextSeparator is written between adjacent text runs and inline
+	// elements; empty means a single space.
+	TextSeparator string
+	// BoilerplatePhrases are removed from the extracted text wherever they
+	// appear as whole words, ignoring case, e.g. "Accept all cookies" left
+	// over from a banner the Remove options did not catch. <pre> blocks are
+	// left alone.
+	BoilerplatePhrases []string
+	// CaptureErrorPages parses 4xx and 5xx responses like successful ones
+	// instead of failing with a StatusError; check CrawlResult.StatusCode.
+	CaptureErrorPages bool
+	// MaxRetries retries requests that fail with a network error, a timeout
+	// or a 429 or 5xx response, waiting RetryBackoff times the attempt
+	// number before each retry, or as long as a Retry-After header asks.
+	// A Retry-After longer than a minute, or than RetryBackoff doubled once
+	// per attempt if that is more, isn't waited for: the request fails. The
+	// error of the last attempt is returned.
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// Headers and Cookies are added to every request, e.g. an API key or a
+	// session cookie. Headers replace the defaults set by the crawler, such
+	// as User-Agent and Accept, when their names match.
+	Headers map[string]string
+	Cookies []*http.Cookie
+}
+
+type WhitespacePolicy string
+
+const (
+	// WhitespaceCollapse turns every run of whitespace, including newlines,
+	// into a single space.
+	WhitespaceCollapse WhitespacePolicy = ""
+	// WhitespacePreserveNewlines collapses spaces and tabs but keeps line
+	// breaks, allowing at most one blank line in a row.
+	WhitespacePreserveNewlines WhitespacePolicy = "newlines"
+)
+
+type Heading struct {
+	Level int    `json:"level"`
+	Text  string `json:"text"`
+	ID    string `json:"id,omitempty"`
 }
 
 type LinkData struct {
 	Href       string `json:"href"`
 	Text       string `json:"text"`
 	BaseDomain string `json:"base_domain"`
+	Source     string `json:"source,omitempty"` // Page region the link was found in, with ClassifyLinks
+	Rel        string `json:"rel,omitempty"`    // The link's rel attribute, such as "nofollow"
 }
 
+// Values of LinkData.Source
+const (
+	LinkSourceContent = "content"
+	LinkSourceNav     = "nav" // Navigation menus, sidebars, breadcrumbs and headers
+	LinkSourceFooter  = "footer"
+)
+
 type Links struct {
 	Internal []LinkData `json:"internal"`
 	External []LinkData `json:"external"`
+	Anchors  int        `json:"anchors"` // In-page "#section" links, not listed above
 }
 
+func (o *CrawlOptions) transport() http.RoundTripper {
+	if o.Transport != nil {
+		return o.Transport
+	}
+	if o.DialTimeout > 0 || o.ResponseHeaderTimeout > 0 {
+		return timeoutTransport(o.DialTimeout, o.ResponseHeaderTimeout)
+	}
+	return defaultTransport
+}
+
+// addRequestHeaders applies Headers and Cookies to req, after the defaults
+// so that Headers take precedence.
+func (o *CrawlOptions) addRequestHeaders(req *http.Request) {
+	for name, value := range o.Headers {
+		if strings.EqualFold(name, "Host") {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+	for _, cookie := range o.Cookies {
+		req.AddCookie(cookie)
+	}
+}
+
+var DefaultLinkAttributes = []string{"href"}
+
 func DefaultCrawlOptions() *CrawlOptions {
 	return &CrawlOptions{
 		Timeout:          30 * time.Second,
@@ -56,39 +269,241 @@ func DefaultCrawlOptions() *CrawlOptions {
 }
 
 func CrawlWebsite(targetURL string, options *CrawlOptions) (*CrawlResult, error) {
+	return CrawlWebsiteContext(context.Background(), targetURL, options)
+}
+
+// CrawlWebsiteContext is CrawlWebsite with a context that aborts the request
+// when it is cancelled or its deadline passes, whichever comes before
+// options.Timeout.
+func CrawlWebsiteContext(ctx context.Context, targetURL string, options *CrawlOptions) (*CrawlResult, error) {
 	if options == nil {
 		options = DefaultCrawlOptions()
 	}
 
-	// Create HTTP client with timeout
+	page, err := FetchPageContext(ctx, targetURL, options)
+	if err != nil {
+		return nil, err
+	}
+	return ExtractPageContext(ctx, page, options)
+}
+
+// FetchPage downloads targetURL without parsing it, the network half of
+// CrawlWebsite. Together with ExtractPage it lets fetching and the CPU-bound
+// extraction run in separate worker pools.
+func FetchPage(targetURL string, options *CrawlOptions) (*FetchedPage, error) {
+	return FetchPageContext(context.Background(), targetURL, options)
+}
+
+// FetchPageContext is FetchPage with a context, like CrawlWebsiteContext.
+func FetchPageContext(ctx context.Context, targetURL string, options *CrawlOptions) (*FetchedPage, error) {
+	if options == nil {
+		options = DefaultCrawlOptions()
+	}
+
+	for attempt := 1; ; attempt++ {
+		page, err := fetchPage(ctx, targetURL, options)
+		wait, retry := RetryDelay(err, attempt, options)
+		if err == nil || !retry {
+			if err != nil && attempt > 1 {
+				err = fmt.Errorf("%w (after %d attempts)", err, attempt)
+			}
+			return page, err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+// fetchPage makes a single attempt at fetching targetURL.
+func fetchPage(ctx context.Context, targetURL string, options *CrawlOptions) (*FetchedPage, error) {
+	// Timeout covers the request and reading the body, within any earlier
+	// deadline of ctx
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Timeout)
+		defer cancel()
+	}
+
+	transport := options.transport()
+	if u, err := url.Parse(targetURL); err == nil && u.Scheme == "file" && options.Transport == nil {
+		transport = NewFileTransport(path.Dir(u.Path))
+	}
+
+	var redirectChain []string
 	client := &http.Client{
-		Timeout: options.Timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if !options.FollowRedirects {
+				return http.ErrUseLastResponse
+			}
+			// A web server must never get to serve local files
+			if req.URL.Scheme != via[0].URL.Scheme && (req.URL.Scheme == "file" || via[0].URL.Scheme == "file") {
+				return fmt.Errorf("refusing redirect from %s to %s", via[0].URL.Scheme, req.URL)
+			}
+			maxRedirects := options.MaxRedirects
+			if maxRedirects <= 0 {
+				maxRedirects = 10
+			}
+			if len(via) > maxRedirects {
+				return fmt.Errorf("%w: stopped after %d redirects", ErrTooManyRedirects, maxRedirects)
+			}
+			// The client resolves the Location header against the URL of the
+			// request that was redirected, so relative targets are absolute here.
+			redirectChain = redirectChain[:0]
+			for _, r := range via {
+				redirectChain = append(redirectChain, r.URL.String())
+			}
+			return nil
+		},
 	}
 
 	// Create request
-	req, err := http.NewRequest("GET", targetURL, nil)
+	method := options.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var requestBody io.Reader
+	if options.Body != nil {
+		requestBody = bytes.NewReader(options.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, targetURL, requestBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
+	if options.ContentType != "" {
+		req.Header.Set("Content-Type", options.ContentType)
+	}
+	if !options.IfModifiedSince.IsZero() {
+		req.Header.Set("If-Modified-Since", options.IfModifiedSince.UTC().Format(http.TimeFormat))
+	}
 	req.Header.Set("User-Agent", options.UserAgent)
 	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
 	req.Header.Set("Accept-Language", "en-US,en;q=0.5")
+	options.addRequestHeaders(req)
 
 	// Make request
 	resp, err := client.Do(req)
 	if err != nil {
+		if isTimeout(err) {
+			return nil, fmt.Errorf("failed to fetch URL: %w: %w", ErrTimeout, err)
+		}
 		return nil, fmt.Errorf("failed to fetch URL: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("received non-OK status code: %d", resp.StatusCode)
+	if resp.StatusCode == http.StatusNotModified && !options.IfModifiedSince.IsZero() {
+		return nil, ErrNotModified
+	}
+	capturedError := options.CaptureErrorPages && resp.StatusCode >= 400
+	if resp.StatusCode != http.StatusOK && !capturedError {
+		statusErr := &StatusError{StatusCode: resp.StatusCode}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			statusErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		}
+		return nil, statusErr
+	}
+
+	var reader io.Reader = resp.Body
+	if options.TruncateBody && options.MaxBodySize > 0 {
+		reader = io.LimitReader(reader, options.MaxBodySize)
+	}
+	body, err := readBody(reader, options.MaxBodySize)
+	if err != nil {
+		return nil, err
 	}
 
+	return &FetchedPage{
+		URL:           targetURL,
+		FinalURL:      resp.Request.URL.String(),
+		StatusCode:    resp.StatusCode,
+		Header:        resp.Header,
+		Body:          body,
+		RedirectChain: redirectChain,
+	}, nil
+}
+
+// ExtractPage parses a page fetched by FetchPage into a CrawlResult, the
+// processing half of CrawlWebsite. options should be the ones it was fetched
+// with.
+func ExtractPage(page *FetchedPage, options *CrawlOptions) (*CrawlResult, error) {
+	return ExtractPageContext(context.Background(), page, options)
+}
+
+// ExtractPageContext is ExtractPage with a context for the image requests
+// OCRImages makes.
+func ExtractPageContext(ctx context.Context, page *FetchedPage, options *CrawlOptions) (*CrawlResult, error) {
+	if options == nil {
+		options = DefaultCrawlOptions()
+	}
+
+	// Relative links on the page are relative to where we ended up, not to
+	// the URL that was requested
+	finalURL := page.FinalURL
+	targetURL := page.URL
+	body := page.Body
+	redirectChain := page.RedirectChain
+
 	// Parse HTML with goquery
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse HTML: %w", err)
+		return nil, fmt.Errorf("%w: %w", ErrParse, err)
+	}
+
+	// Detect <meta http-equiv="refresh"> redirects before the document is cleaned
+	metaRefreshURL := extractMetaRefresh(doc, finalURL)
+
+	// Pagination and canonical hints often live in <head> or in navigation
+	// that gets removed; servers may also send them in the Link header
+	headerLinks := parseLinkHeader(page.Header.Values("Link"), finalURL)
+	relLink := func(rel string) string {
+		if link := extractRelLink(doc, rel, finalURL); link != "" {
+			return link
+		}
+		return headerLinks[rel]
+	}
+	nextURL := relLink("next")
+	prevURL := relLink("prev")
+	canonicalURL := relLink("canonical")
+	ampURL := relLink("amphtml")
+
+	// Login forms often sit in modals that RemovePopups strips
+	hasLoginForm := doc.Find("input[type='password' i]").Length() > 0
+	hasPaywall := hasPaywallMarker(doc)
+	publishedAt, publishedSource := extractPublishedAt(doc, page.Header)
+	lastModified, _ := http.ParseTime(page.Header.Get("Last-Modified"))
+
+	// Structured data must be read before the removal passes strip its elements
+	microdata := extractMicrodata(doc, finalURL)
+	metadata := extractMetadata(doc, options.TitleSources)
+	breadcrumbs := extractBreadcrumbs(doc)
+
+	favicons := extractFavicons(doc, finalURL)
+	iframes := extractIframes(doc, finalURL)
+
+	var images []ImageData
+	if options.ExtractImages {
+		images = extractImages(doc, finalURL)
+	}
+
+	var mixedContent []string
+	if options.DetectMixedContent {
+		mixedContent = extractMixedContent(doc, finalURL)
+	}
+
+	var regionLinks []LinkData
+	var contentHrefs map[string]bool
+	if options.ClassifyLinks {
+		regionLinks, contentHrefs = extractRegionLinks(doc, finalURL, options)
+	}
+
+	if options.KeepNoscript {
+		unwrapNoscript(doc)
 	}
 
 	// Clean the document
@@ -108,32 +523,204 @@ func CrawlWebsite(targetURL string, options *CrawlOptions) (*CrawlResult, error)
 	// Remove other unwanted elements
 	removeUnwantedElements(doc)
 
+	tables := extractTables(doc)
+
 	// Extract content
 	var content string
+	var headings []Heading
 	var extractedLinks Links
 
+	usedReadability := false
 	if options.ExtractMainOnly {
 		// Use go-readability for main content extraction
-		content, extractedLinks, err = extractMainContentWithReadability(doc, targetURL)
+		content, headings, extractedLinks, err = extractMainContentWithReadability(ctx, doc, finalURL, options)
 		if err != nil {
 			// Fallback to manual extraction if readability fails
-			content, extractedLinks = extractContentManually(doc, targetURL)
+			content, headings, extractedLinks = extractContentManually(ctx, doc, finalURL, options)
+		} else {
+			usedReadability = true
 		}
 	} else {
-		content, extractedLinks = extractContentManually(doc, targetURL)
+		content, headings, extractedLinks = extractContentManually(ctx, doc, finalURL, options)
+	}
+
+	var agreement float64
+	if options.CompareExtractors {
+		agreement = 1
+		// The comparison text is thrown away, so don't OCR images for it
+		comparisonOptions := *options
+		comparisonOptions.OCRImages = false
+		if usedReadability {
+			other, _, _ := extractContentManually(ctx, doc, finalURL, &comparisonOptions)
+			agreement = extractionAgreement(content, other)
+		} else if !options.ExtractMainOnly {
+			if other, _, _, err := extractMainContentWithReadability(ctx, doc, finalURL, &comparisonOptions); err == nil {
+				agreement = extractionAgreement(content, other)
+			}
+		}
+	}
+	if options.ClassifyLinks {
+		extractedLinks = mergeRegionLinks(extractedLinks, regionLinks, contentHrefs, finalURL)
 	}
 
 	result := &CrawlResult{
-		Content:      content,
-		CrawledURLs:  []string{targetURL},
-		PagesCrawled: 1,
-		PageErrors:   make(map[string]string),
-		Links:        extractedLinks,
+		Content:         content,
+		CrawledURLs:     []string{targetURL},
+		PagesCrawled:    1,
+		PageErrors:      make(map[string]string),
+		Links:           extractedLinks,
+		Metadata:        metadata,
+		MetaRefreshURL:  metaRefreshURL,
+		BytesDownloaded: int64(len(body)),
+		Microdata:       microdata,
+		Headings:        headings,
+		StatusCode:      page.StatusCode,
+		NextURL:         nextURL,
+		PrevURL:         prevURL,
+		CanonicalURL:    canonicalURL,
+		AMPURL:          ampURL,
+		HasLoginForm:    hasLoginForm,
+		HasPaywall:      hasPaywall,
+		PublishedAt:     publishedAt,
+		PublishedSource: publishedSource,
+		LastModified:    lastModified,
+		FinalURL:        finalURL,
+		RedirectChain:   redirectChain,
+		Images:          images,
+		Favicons:        favicons,
+		Tables:          tables,
+		MixedContent:    mixedContent,
+		Breadcrumbs:     breadcrumbs,
+		Iframes:         iframes,
+
+		ExtractionAgreement: agreement,
 	}
 
 	return result, nil
 }
 
+func readBody(body io.Reader, maxSize int64) ([]byte, error) {
+	if maxSize > 0 {
+		body = io.LimitReader(body, maxSize+1)
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		if isTimeout(err) {
+			return nil, fmt.Errorf("failed to read response body: %w: %w", ErrTimeout, err)
+		}
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("%w: exceeds %d bytes", ErrBodyTooLarge, maxSize)
+	}
+	return data, nil
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func extractMetaRefresh(doc *goquery.Document, targetURL string) string {
+	var target string
+	doc.Find("meta[http-equiv]").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		equiv, _ := s.Attr("http-equiv")
+		if !strings.EqualFold(strings.TrimSpace(equiv), "refresh") {
+			return true
+		}
+		content, _ := s.Attr("content")
+		target = parseMetaRefresh(content)
+		return target == ""
+	})
+	if target == "" {
+		return ""
+	}
+
+	base, err := url.Parse(targetURL)
+	if err != nil {
+		return ""
+	}
+	refURL, err := url.Parse(target)
+	if err != nil {
+		return ""
+	}
+	return base.ResolveReference(refURL).String()
+}
+
+// extractRelLink returns the resolved href of the first <link> or <a> element
+// carrying the given rel value.
+func extractRelLink(doc *goquery.Document, rel, targetURL string) string {
+	selector := fmt.Sprintf("link[rel~='%s'][href], a[rel~='%s'][href]", rel, rel)
+	href := strings.TrimSpace(doc.Find(selector).First().AttrOr("href", ""))
+	if href == "" {
+		return ""
+	}
+
+	base, err := url.Parse(targetURL)
+	if err != nil {
+		return ""
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	return base.ResolveReference(ref).String()
+}
+
+var notAccessibleForFreeRegex = regexp.MustCompile(`"isAccessibleForFree"\s*:\s*"?(?i:false)"?`)
+
+// hasPaywallMarker reports whether the page marks itself as paywalled through
+// og:type or schema.org isAccessibleForFree in JSON-LD or microdata.
+func hasPaywallMarker(doc *goquery.Document) bool {
+	if strings.EqualFold(doc.Find("meta[property='og:type']").AttrOr("content", ""), "paywall") {
+		return true
+	}
+
+	marked := false
+	doc.Find("script[type='application/ld+json']").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		marked = notAccessibleForFreeRegex.MatchString(s.Text())
+		return !marked
+	})
+	if marked {
+		return true
+	}
+
+	prop := doc.Find("[itemprop='isAccessibleForFree']").First()
+	value := prop.AttrOr("content", prop.Text())
+	return strings.EqualFold(strings.TrimSpace(value), "false")
+}
+
+// parseMetaRefresh extracts the target from a refresh value such as
+// "0;url=/next" or "5; URL='https://example.com/'". It returns an empty
+// string when the page only refreshes itself.
+func parseMetaRefresh(content string) string {
+	idx := strings.IndexAny(content, ";,")
+	if idx < 0 {
+		return ""
+	}
+
+	target := strings.TrimSpace(content[idx+1:])
+	if len(target) >= 3 && strings.EqualFold(target[:3], "url") {
+		rest := strings.TrimSpace(target[3:])
+		if strings.HasPrefix(rest, "=") {
+			target = strings.TrimSpace(rest[1:])
+		}
+	}
+	return strings.Trim(target, `"'`)
+}
+
+func unwrapNoscript(doc *goquery.Document) {
+	doc.Find("noscript").Each(func(i int, s *goquery.Selection) {
+		// The parser runs with scripting enabled, so noscript content is kept
+		// as raw text; parse it as markup so it survives removeUnwantedElements.
+		s.ReplaceWithHtml(s.Text())
+	})
+}
+
 func removePopupsAndOverlays(doc *goquery.Document) {
 	// Common popup and overlay selectors
 	popupSelectors := []string{
@@ -186,41 +773,41 @@ func removePopupsAndOverlays(doc *goquery.Document) {
 	}
 }
 
-func removeNavigationElements(doc *goquery.Document) {
-	navSelectors := []string{
-		"nav", "navigation", ".nav", ".navigation",
-		"[role='navigation']", "[class*='nav']", "[id*='nav']",
-		".menu", "[class*='menu']", "[id*='menu']",
-		".sidebar", "[class*='sidebar']", "[id*='sidebar']",
-		".breadcrumb", "[class*='breadcrumb']", "[id*='breadcrumb']",
-	}
+var navSelectors = []string{
+	"nav", "navigation", ".nav", ".navigation",
+	"[role='navigation']", "[class*='nav']", "[id*='nav']",
+	".menu", "[class*='menu']", "[id*='menu']",
+	".sidebar", "[class*='sidebar']", "[id*='sidebar']",
+	".breadcrumb", "[class*='breadcrumb']", "[id*='breadcrumb']",
+}
 
+func removeNavigationElements(doc *goquery.Document) {
 	for _, selector := range navSelectors {
 		doc.Find(selector).Remove()
 	}
 }
 
-func removeHeaderElements(doc *goquery.Document) {
-	headerSelectors := []string{
-		"header", ".header", "#header",
-		"[class*='header']", "[id*='header']",
-		".top-bar", ".topbar", "[class*='top-bar']",
-		".site-header", "[class*='site-header']",
-	}
+var headerSelectors = []string{
+	"header", ".header", "#header",
+	"[class*='header']", "[id*='header']",
+	".top-bar", ".topbar", "[class*='top-bar']",
+	".site-header", "[class*='site-header']",
+}
 
+func removeHeaderElements(doc *goquery.Document) {
 	for _, selector := range headerSelectors {
 		doc.Find(selector).Remove()
 	}
 }
 
-func removeFooterElements(doc *goquery.Document) {
-	footerSelectors := []string{
-		"footer", ".footer", "#footer",
-		"[class*='footer']", "[id*='footer']",
-		".site-footer", "[class*='site-footer']",
-		".bottom", "[class*='bottom']",
-	}
+var footerSelectors = []string{
+	"footer", ".footer", "#footer",
+	"[class*='footer']", "[id*='footer']",
+	".site-footer", "[class*='site-footer']",
+	".bottom", "[class*='bottom']",
+}
 
+func removeFooterElements(doc *goquery.Document) {
 	for _, selector := range footerSelectors {
 		doc.Find(selector).Remove()
 	}
@@ -270,34 +857,34 @@ func isPopupElement(class, id, text string) bool {
 	return false
 }
 
-func extractMainContentWithReadability(doc *goquery.Document, targetURL string) (string, Links, error) {
+func extractMainContentWithReadability(ctx context.Context, doc *goquery.Document, targetURL string, options *CrawlOptions) (string, []Heading, Links, error) {
 	// Convert goquery document back to HTML string for readability
 	html, err := doc.Html()
 	if err != nil {
-		return "", Links{}, err
+		return "", nil, Links{}, err
 	}
 
 	// Use go-readability to extract main content
 	article, err := readability.FromReader(strings.NewReader(html), &url.URL{})
 	if err != nil {
-		return "", Links{}, err
+		return "", nil, Links{}, err
 	}
 
 	// Parse the extracted content to get links
 	contentDoc, err := goquery.NewDocumentFromReader(strings.NewReader(article.Content))
 	if err != nil {
-		return "", Links{}, err
+		return "", nil, Links{}, err
 	}
 
-	links := extractLinks(contentDoc.Selection, targetURL)
+	links := extractLinks(contentDoc.Selection, targetURL, options.LinkAttributes, options.FollowOnclick)
 
 	// Convert HTML to clean text/markdown-like format
-	cleanContent := htmlToCleanText(contentDoc.Selection)
+	cleanContent, headings := htmlToCleanText(ctx, contentDoc.Selection, options, targetURL)
 
-	return cleanContent, links, nil
+	return cleanContent, headings, links, nil
 }
 
-func extractContentManually(doc *goquery.Document, targetURL string) (string, Links) {
+func extractContentManually(ctx context.Context, doc *goquery.Document, targetURL string, options *CrawlOptions) (string, []Heading, Links) {
 	// Try to find main content area
 	mainSelectors := []string{
 		"main", "[role='main']", ".main", "#main",
@@ -320,23 +907,51 @@ func extractContentManually(doc *goquery.Document, targetURL string) (string, Li
 		contentSelection = doc.Find("body")
 	}
 
-	links := extractLinks(contentSelection, targetURL)
-	content := htmlToCleanText(contentSelection)
+	links := extractLinks(contentSelection, targetURL, options.LinkAttributes, options.FollowOnclick)
+	content, headings := htmlToCleanText(ctx, contentSelection, options, targetURL)
 
-	return content, links
+	return content, headings, links
 }
 
-func extractLinks(selection *goquery.Selection, baseURL string) Links {
+func extractLinks(selection *goquery.Selection, baseURL string, attributes []string, followOnclick bool) Links {
 	var internal, external []LinkData
+	anchors := 0
 
 	baseURLParsed, err := url.Parse(baseURL)
 	if err != nil {
 		return Links{Internal: internal, External: external}
 	}
 
-	selection.Find("a[href]").Each(func(i int, s *goquery.Selection) {
-		href, exists := s.Attr("href")
-		if !exists || href == "" {
+	if attributes == nil {
+		attributes = DefaultLinkAttributes
+	}
+	selectors := make([]string, len(attributes))
+	for i, attr := range attributes {
+		if attr == "href" {
+			selectors[i] = "a[href]"
+		} else {
+			selectors[i] = "[" + attr + "]"
+		}
+	}
+	if followOnclick {
+		selectors = append(selectors, "[onclick]")
+	}
+
+	selection.Find(strings.Join(selectors, ", ")).Each(func(i int, s *goquery.Selection) {
+		href := linkAttribute(s, attributes)
+		if followOnclick && (href == "" || href == "#" || strings.HasPrefix(strings.ToLower(href), "javascript:")) {
+			if target := onclickTarget(s.AttrOr("onclick", "")); target != "" {
+				href = target
+			}
+		}
+		if href == "" {
+			return
+		}
+
+		// Links to a section of the same page aren't navigation. Route-like
+		// fragments ("#/path", "#!/path") are kept for hash-routed apps.
+		if strings.HasPrefix(href, "#") && !strings.HasPrefix(href, "#/") && !strings.HasPrefix(href, "#!") {
+			anchors++
 			return
 		}
 
@@ -357,6 +972,7 @@ func extractLinks(selection *goquery.Selection, baseURL string) Links {
 			Href:       resolvedURL.String(),
 			Text:       text,
 			BaseDomain: resolvedURL.Host,
+			Rel:        strings.TrimSpace(s.AttrOr("rel", "")),
 		}
 
 		// Determine if internal or external
@@ -367,63 +983,365 @@ func extractLinks(selection *goquery.Selection, baseURL string) Links {
 		}
 	})
 
-	return Links{Internal: internal, External: external}
+	return Links{Internal: internal, External: external, Anchors: anchors}
+}
+
+// extractRegionLinks returns the links in the navigation, header and footer
+// regions, tagged with the region they came from, and the hrefs of the links
+// found anywhere else on the page.
+func extractRegionLinks(doc *goquery.Document, pageURL string, options *CrawlOptions) ([]LinkData, map[string]bool) {
+	regions := []struct {
+		selectors []string
+		source    string
+	}{
+		{navSelectors, LinkSourceNav},
+		{headerSelectors, LinkSourceNav},
+		{footerSelectors, LinkSourceFooter},
+	}
+
+	var regionLinks []LinkData
+	outside := goquery.CloneDocument(doc)
+	for _, region := range regions {
+		selector := strings.Join(region.selectors, ", ")
+		links := extractLinks(doc.Find(selector), pageURL, options.LinkAttributes, options.FollowOnclick)
+		for _, link := range append(links.Internal, links.External...) {
+			link.Source = region.source
+			regionLinks = append(regionLinks, link)
+		}
+		outside.Find(selector).Remove()
+	}
+
+	contentHrefs := make(map[string]bool)
+	links := extractLinks(outside.Selection, pageURL, options.LinkAttributes, options.FollowOnclick)
+	for _, link := range append(links.Internal, links.External...) {
+		contentHrefs[link.Href] = true
+	}
+	return regionLinks, contentHrefs
+}
+
+// mergeRegionLinks tags the extracted links with their region, which is
+// the content unless they only appear in navigation, headers or footers, and
+// adds the region links that were not extracted.
+func mergeRegionLinks(links Links, regionLinks []LinkData, contentHrefs map[string]bool, pageURL string) Links {
+	regionSources := make(map[string]string)
+	for _, link := range regionLinks {
+		if _, ok := regionSources[link.Href]; !ok {
+			regionSources[link.Href] = link.Source
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, list := range [][]LinkData{links.Internal, links.External} {
+		for i := range list {
+			list[i].Source = LinkSourceContent
+			if source, ok := regionSources[list[i].Href]; ok && !contentHrefs[list[i].Href] {
+				list[i].Source = source
+			}
+			seen[list[i].Href] = true
+		}
+	}
+
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return links
+	}
+	for _, link := range regionLinks {
+		if seen[link.Href] {
+			continue
+		}
+		seen[link.Href] = true
+		if link.BaseDomain == base.Host {
+			links.Internal = append(links.Internal, link)
+		} else {
+			links.External = append(links.External, link)
+		}
+	}
+	return links
+}
+
+// onclickPattern matches location assignments, location.assign/replace and
+// window.open calls with a quoted URL
+var onclickPattern = regexp.MustCompile(`(?:^|[^\w.$])(?:(?:(?:window|document|self|top)\.)?location(?:\.href)?\s*=\s*|(?:(?:window|document|self|top)\.)?location\.(?:assign|replace)\(\s*|window\.open\(\s*)(?:'([^']+)'|"([^"]+)")`)
+
+// onclickTarget returns the URL an onclick handler navigates to, or "" if
+// it is not one of the simple forms onclickPattern recognizes.
+func onclickTarget(handler string) string {
+	match := onclickPattern.FindStringSubmatch(handler)
+	if match == nil {
+		return ""
+	}
+	return strings.TrimSpace(match[1] + match[2])
+}
+
+// linkAttribute returns the first non-empty link attribute of s.
+func linkAttribute(s *goquery.Selection, attributes []string) string {
+	for _, attr := range attributes {
+		if attr == "href" && goquery.NodeName(s) != "a" {
+			continue
+		}
+		if value := strings.TrimSpace(s.AttrOr(attr, "")); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// textConverter renders a content selection as markdown-like text.
+type textConverter struct {
+	ctx       context.Context // For the image requests of OCRImages
+	options   *CrawlOptions
+	base      *url.URL
+	headings  []Heading
+	preBlocks []string
+	ocrText   map[string]string // Image URL -> recognized text, so repeats are fetched once
+	// Compiled BoilerplatePhrases; nil when there are none
+	boilerplate *regexp.Regexp
+	// Open emphasis elements, so nested ones don't repeat the markers
+	bold, italic int
+}
+
+func htmlToCleanText(ctx context.Context, selection *goquery.Selection, options *CrawlOptions, baseURL string) (string, []Heading) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		base = &url.URL{}
+	}
+
+	c := &textConverter{ctx: ctx, options: options, base: base, boilerplate: boilerplatePattern(options.BoilerplatePhrases)}
+	return c.convert(selection), c.headings
 }
 
-func htmlToCleanText(selection *goquery.Selection) string {
+func (c *textConverter) convert(selection *goquery.Selection) string {
 	var result strings.Builder
+	separator := c.options.TextSeparator
+	if separator == "" {
+		separator = " "
+	}
 
 	selection.Contents().Each(func(i int, s *goquery.Selection) {
 		if goquery.NodeName(s) == "#text" {
 			text := strings.TrimSpace(s.Text())
 			if text != "" {
 				result.WriteString(text)
-				result.WriteString(" ")
+				result.WriteString(separator)
 			}
 		} else {
 			// Handle different HTML elements
 			tagName := goquery.NodeName(s)
 			switch tagName {
 			case "h1", "h2", "h3", "h4", "h5", "h6":
-				level := tagName[1:] // Extract number
-				prefix := strings.Repeat("#", parseInt(level))
-				result.WriteString(fmt.Sprintf("\n\n%s %s\n\n", prefix, strings.TrimSpace(s.Text())))
+				level := parseInt(tagName[1:]) // Extract number
+				text := c.text(s)
+				prefix := strings.Repeat("#", level)
+				result.WriteString(fmt.Sprintf("\n\n%s %s\n\n", prefix, text))
+				c.headings = append(c.headings, Heading{Level: level, Text: strings.TrimSpace(s.Text()), ID: s.AttrOr("id", "")})
 			case "p":
-				result.WriteString(fmt.Sprintf("\n%s\n", strings.TrimSpace(s.Text())))
+				result.WriteString(fmt.Sprintf("\n%s\n", c.text(s)))
 			case "br":
 				result.WriteString("\n")
 			case "li":
-				result.WriteString(fmt.Sprintf("- %s\n", strings.TrimSpace(s.Text())))
+				result.WriteString(fmt.Sprintf("- %s\n", c.text(s)))
 			case "blockquote":
-				result.WriteString(fmt.Sprintf("\n> %s\n", strings.TrimSpace(s.Text())))
+				result.WriteString(fmt.Sprintf("\n> %s\n", c.text(s)))
 			case "code":
 				result.WriteString(fmt.Sprintf("`%s`", strings.TrimSpace(s.Text())))
 			case "pre":
-				result.WriteString(fmt.Sprintf("\n```\n%s\n```\n", strings.TrimSpace(s.Text())))
+				result.WriteString(c.preBlock(s))
+			case "dl":
+				if c.options.DefinitionLists {
+					result.WriteString(c.definitionList(s))
+					break
+				}
+				if text := c.text(s); text != "" {
+					result.WriteString(text)
+					result.WriteString(separator)
+				}
 			default:
 				// For other elements, just extract text
-				if text := strings.TrimSpace(s.Text()); text != "" {
+				if text := c.text(s); text != "" {
 					result.WriteString(text)
-					result.WriteString(" ")
+					result.WriteString(separator)
 				}
 			}
 		}
 	})
 
-	// Clean up the result
-	content := result.String()
-
-	// Remove excessive whitespace
-	multipleSpaces := regexp.MustCompile(`\s+`)
-	content = multipleSpaces.ReplaceAllString(content, " ")
+	content := normalizeWhitespace(result.String(), c.options.Whitespace)
+	if c.boilerplate != nil {
+		content = normalizeWhitespace(removeBoilerplate(content, c.boilerplate), c.options.Whitespace)
+	}
 
-	// Remove excessive newlines
-	multipleNewlines := regexp.MustCompile(`\n\s*\n\s*\n`)
-	content = multipleNewlines.ReplaceAllString(content, "\n\n")
+	// Put the preformatted blocks back now that the rest is normalized
+	for i, block := range c.preBlocks {
+		content = strings.Replace(content, prePlaceholder(i), block, 1)
+	}
 
 	return strings.TrimSpace(content)
 }
 
+var (
+	multipleSpaces   = regexp.MustCompile(`\s+`)
+	horizontalSpaces = regexp.MustCompile(`[^\S\n]+`)
+	spacesAroundLine = regexp.MustCompile(` ?\n ?`)
+	multipleNewlines = regexp.MustCompile(`\n{3,}`)
+)
+
+func normalizeWhitespace(content string, policy WhitespacePolicy) string {
+	if policy == WhitespacePreserveNewlines {
+		content = horizontalSpaces.ReplaceAllString(content, " ")
+		content = spacesAroundLine.ReplaceAllString(content, "\n")
+		return multipleNewlines.ReplaceAllString(content, "\n\n")
+	}
+	return multipleSpaces.ReplaceAllString(content, " ")
+}
+
+// preBlock fences the verbatim text of a <pre> element. The converter output
+// gets a placeholder instead, so whitespace normalization cannot reach it.
+func (c *textConverter) preBlock(s *goquery.Selection) string {
+	text := strings.TrimRight(strings.TrimLeft(s.Text(), "\r\n"), " \t\r\n")
+	c.preBlocks = append(c.preBlocks, fmt.Sprintf("\n```\n%s\n```\n", text))
+	return prePlaceholder(len(c.preBlocks) - 1)
+}
+
+// definitionList renders a <dl> in the markdown extra style, one bold term
+// per line with each of its definitions on a ": " line below.
+func (c *textConverter) definitionList(s *goquery.Selection) string {
+	var b strings.Builder
+	b.WriteString("\n")
+	s.Find("dt, dd").Each(func(i int, item *goquery.Selection) {
+		text := c.text(item)
+		if text == "" {
+			return
+		}
+		if goquery.NodeName(item) == "dt" {
+			fmt.Fprintf(&b, "\n**%s**\n", text)
+		} else {
+			fmt.Fprintf(&b, ": %s\n", text)
+		}
+	})
+	return b.String()
+}
+
+func prePlaceholder(i int) string {
+	return fmt.Sprintf("\x00pre%d\x00", i)
+}
+
+// text returns the trimmed text of s like Selection.Text, applying the
+// inline formatting options (markdown links).
+func (c *textConverter) text(s *goquery.Selection) string {
+	var b strings.Builder
+	c.writeInline(&b, s)
+	return strings.TrimSpace(b.String())
+}
+
+func (c *textConverter) writeInline(b *strings.Builder, s *goquery.Selection) {
+	switch goquery.NodeName(s) {
+	case "#text":
+		b.WriteString(s.Text())
+		return
+	case "pre":
+		b.WriteString(c.preBlock(s))
+		return
+	case "img":
+		b.WriteString(c.imageText(s))
+		return
+	case "strong", "b":
+		if c.options.PreserveFormatting {
+			c.writeEmphasis(b, s, "**", &c.bold)
+			return
+		}
+	case "em", "i":
+		if c.options.PreserveFormatting {
+			c.writeEmphasis(b, s, "*", &c.italic)
+			return
+		}
+	case "dl":
+		if c.options.DefinitionLists {
+			b.WriteString(c.definitionList(s))
+			return
+		}
+	case "a":
+		if c.options.PreserveLinks {
+			b.WriteString(c.markdownLink(s))
+			return
+		}
+	}
+
+	s.Contents().Each(func(i int, child *goquery.Selection) {
+		c.writeInline(b, child)
+	})
+}
+
+// writeEmphasis wraps the inline content of s in marker. Surrounding
+// whitespace is moved outside the markers, as markdown requires.
+func (c *textConverter) writeEmphasis(b *strings.Builder, s *goquery.Selection, marker string, open *int) {
+	var inner strings.Builder
+	*open++
+	s.Contents().Each(func(i int, child *goquery.Selection) {
+		c.writeInline(&inner, child)
+	})
+	*open--
+
+	text := inner.String()
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" || *open > 0 {
+		b.WriteString(text)
+		return
+	}
+	if strings.TrimLeftFunc(text, unicode.IsSpace) != text {
+		b.WriteString(" ")
+	}
+	b.WriteString(marker + trimmed + marker)
+	if strings.TrimRightFunc(text, unicode.IsSpace) != text {
+		b.WriteString(" ")
+	}
+}
+
+func (c *textConverter) markdownLink(s *goquery.Selection) string {
+	var inner strings.Builder
+	s.Contents().Each(func(i int, child *goquery.Selection) {
+		c.writeInline(&inner, child)
+	})
+	text := strings.TrimSpace(inner.String())
+
+	href := strings.TrimSpace(s.AttrOr("href", ""))
+	if href == "" || text == "" || strings.HasPrefix(href, "javascript:") {
+		return text
+	}
+	if ref, err := url.Parse(href); err == nil {
+		href = c.base.ResolveReference(ref).String()
+	}
+	return fmt.Sprintf("[%s](%s)", text, href)
+}
+
+// TableOfContents renders the page headings as a nested markdown list.
+func (r *CrawlResult) TableOfContents() string {
+	return TableOfContents(r.Headings)
+}
+
+// TableOfContents renders headings as a nested markdown list, linking to each
+// heading's anchor when it has an id.
+func TableOfContents(headings []Heading) string {
+	if len(headings) == 0 {
+		return ""
+	}
+
+	minLevel := headings[0].Level
+	for _, h := range headings {
+		minLevel = min(minLevel, h.Level)
+	}
+
+	var toc strings.Builder
+	for _, h := range headings {
+		toc.WriteString(strings.Repeat("  ", h.Level-minLevel))
+		if h.ID != "" {
+			fmt.Fprintf(&toc, "- [%s](#%s)\n", h.Text, h.ID)
+		} else {
+			fmt.Fprintf(&toc, "- %s\n", h.Text)
+		}
+	}
+	return toc.String()
+}
+
 func parseInt(s string) int {
 	switch s {
 	case "1":
@@ -0,0 +1,1321 @@
+package webcrawl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const articlePage = `<html>
+<head><title>Article</title></head>
+<body>
+	<nav><a href="/nav-link">Navigation</a></nav>
+	<main>
+		<h1>Article heading</h1>
+		<p>The first paragraph of the article.</p>
+		<p>Read <a href="/next">the next article</a> or <a href="https://external.example/page">an external page</a>.</p>
+	</main>
+	<footer>Footer text</footer>
+</body>
+</html>`
+
+func newFixtureServer(t *testing.T, pages map[string]string) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, page)
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/article", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func manualOptions() *CrawlOptions {
+	options := DefaultCrawlOptions()
+	options.Timeout = 5 * time.Second
+	options.ExtractMainOnly = false
+	return options
+}
+
+func TestCrawlWebsiteExtractsContentAndLinks(t *testing.T) {
+	srv := newFixtureServer(t, map[string]string{"/article": articlePage})
+
+	result, err := CrawlWebsite(srv.URL+"/article", manualOptions())
+	if err != nil {
+		t.Fatalf("CrawlWebsite returned error: %v", err)
+	}
+
+	if !strings.Contains(result.Content, "The first paragraph of the article.") {
+		t.Errorf("content is missing the article text: %q", result.Content)
+	}
+	for _, unwanted := range []string{"Navigation", "Footer text"} {
+		if strings.Contains(result.Content, unwanted) {
+			t.Errorf("content should not contain %q: %q", unwanted, result.Content)
+		}
+	}
+
+	if len(result.Links.Internal) != 1 || result.Links.Internal[0].Href != srv.URL+"/next" {
+		t.Errorf("unexpected internal links: %+v", result.Links.Internal)
+	}
+	if len(result.Links.External) != 1 || result.Links.External[0].Href != "https://external.example/page" {
+		t.Errorf("unexpected external links: %+v", result.Links.External)
+	}
+	if result.BytesDownloaded != int64(len(articlePage)) {
+		t.Errorf("BytesDownloaded = %d, want %d", result.BytesDownloaded, len(articlePage))
+	}
+}
+
+func TestExtractionAgreement(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"The quick brown fox", "the QUICK brown fox!", 1},
+		{"one two three four", "one two", 0.5},
+		{"one two", "three four", 0},
+		{"", "", 1},
+		{"words", "", 0},
+	}
+	for _, tt := range tests {
+		if got := extractionAgreement(tt.a, tt.b); got != tt.want {
+			t.Errorf("extractionAgreement(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCrawlWebsiteCompareExtractors(t *testing.T) {
+	var article strings.Builder
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&article, "<p>Paragraph %d of the long article explains the topic in considerable detail, with examples.</p>", i)
+	}
+	srv := newFixtureServer(t, map[string]string{
+		"/agree":    "<html><body><main><article>" + article.String() + "</article></main></body></html>",
+		"/disagree": "<html><body><main><p>Subscribe to read more.</p></main><div class=\"story\">" + article.String() + "</div></body></html>",
+	})
+
+	for _, extractMainOnly := range []bool{true, false} {
+		options := manualOptions()
+		options.ExtractMainOnly = extractMainOnly
+		options.CompareExtractors = true
+
+		result, err := CrawlWebsite(srv.URL+"/agree", options)
+		if err != nil {
+			t.Fatalf("CrawlWebsite returned error: %v", err)
+		}
+		if result.ExtractionAgreement < 0.9 {
+			t.Errorf("ExtractMainOnly=%v: agreement on /agree = %v, want close to 1", extractMainOnly, result.ExtractionAgreement)
+		}
+
+		result, err = CrawlWebsite(srv.URL+"/disagree", options)
+		if err != nil {
+			t.Fatalf("CrawlWebsite returned error: %v", err)
+		}
+		if result.ExtractionAgreement > 0.1 {
+			t.Errorf("ExtractMainOnly=%v: agreement on /disagree = %v, want close to 0", extractMainOnly, result.ExtractionAgreement)
+		}
+	}
+}
+
+func TestCrawlWebsiteClassifyLinks(t *testing.T) {
+	page := strings.Replace(articlePage, "<footer>Footer text</footer>",
+		`<footer><a href="/next">Next</a><a href="/about">About</a></footer>`, 1)
+	srv := newFixtureServer(t, map[string]string{"/article": page})
+
+	options := manualOptions()
+	options.ClassifyLinks = true
+	result, err := CrawlWebsite(srv.URL+"/article", options)
+	if err != nil {
+		t.Fatalf("CrawlWebsite returned error: %v", err)
+	}
+
+	sources := make(map[string]string)
+	for _, link := range append(result.Links.Internal, result.Links.External...) {
+		sources[strings.TrimPrefix(link.Href, srv.URL)] = link.Source
+	}
+	want := map[string]string{
+		"/next":                         LinkSourceContent,
+		"https://external.example/page": LinkSourceContent,
+		"/nav-link":                     LinkSourceNav,
+		"/about":                        LinkSourceFooter,
+	}
+	if fmt.Sprint(sources) != fmt.Sprint(want) {
+		t.Errorf("link sources = %v, want %v", sources, want)
+	}
+	if strings.Contains(result.Content, "Navigation") {
+		t.Errorf("navigation should still be removed from the content: %q", result.Content)
+	}
+
+	// Regions that are kept in the page are tagged the same way
+	options.RemoveNavigation = false
+	options.RemoveHeader = false
+	options.RemoveFooter = false
+	result, err = CrawlWebsite(srv.URL+"/article", options)
+	if err != nil {
+		t.Fatalf("CrawlWebsite returned error: %v", err)
+	}
+	sources = make(map[string]string)
+	for _, link := range append(result.Links.Internal, result.Links.External...) {
+		sources[strings.TrimPrefix(link.Href, srv.URL)] = link.Source
+	}
+	if fmt.Sprint(sources) != fmt.Sprint(want) {
+		t.Errorf("link sources without removal = %v, want %v", sources, want)
+	}
+}
+
+func TestHeadingsAndTableOfContents(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(
+		`<main><h1 id="intro">Intro</h1><p>Text</p><h2 id="setup">Setup</h2><h3>Details</h3><h2 id="usage">Usage</h2></main>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, headings := htmlToCleanText(context.Background(), doc.Find("main"), DefaultCrawlOptions(), "")
+	if len(headings) != 4 || headings[2].Level != 3 || headings[2].ID != "" {
+		t.Fatalf("unexpected headings: %+v", headings)
+	}
+
+	want := "- [Intro](#intro)\n  - [Setup](#setup)\n    - Details\n  - [Usage](#usage)\n"
+	if got := TableOfContents(headings); got != want {
+		t.Errorf("TableOfContents() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHTMLToCleanTextPreserveLinks(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(
+		`<main><p>Read <a href="/docs">the <b>docs</b></a> first.</p><a href="https://other.example/">Other</a></main>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := DefaultCrawlOptions()
+	text, _ := htmlToCleanText(context.Background(), doc.Find("main"), options, "https://example.com/guide/")
+	if want := "Read the docs first. Other"; text != want {
+		t.Errorf("plain text = %q, want %q", text, want)
+	}
+
+	options.PreserveLinks = true
+	text, _ = htmlToCleanText(context.Background(), doc.Find("main"), options, "https://example.com/guide/")
+	if want := "Read [the docs](https://example.com/docs) first. [Other](https://other.example/)"; text != want {
+		t.Errorf("text with links = %q, want %q", text, want)
+	}
+}
+
+func TestHTMLToCleanTextWhitespace(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(
+		"<main><p>First   line</p><p>Second</p><pre>\n  if x {\n      y()\n  }\n</pre><div><pre>a  b</pre></div></main>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := DefaultCrawlOptions()
+	text, _ := htmlToCleanText(context.Background(), doc.Find("main"), options, "")
+	if want := "First line Second \n```\n  if x {\n      y()\n  }\n```\n\n```\na  b\n```"; text != want {
+		t.Errorf("collapsed text = %q, want %q", text, want)
+	}
+
+	options.Whitespace = WhitespacePreserveNewlines
+	text, _ = htmlToCleanText(context.Background(), doc.Find("main"), options, "")
+	if want := "First line\n\nSecond\n\n```\n  if x {\n      y()\n  }\n```\n\n```\na  b\n```"; text != want {
+		t.Errorf("text with newlines = %q, want %q", text, want)
+	}
+}
+
+func TestHTMLToCleanTextDefinitionLists(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(
+		`<main><h2>Glossary</h2><dl><dt>Crawler</dt><dd>Fetches pages.</dd><dt>Frontier</dt><dd>Queued URLs.</dd><dd>Also a border.</dd></dl></main>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := DefaultCrawlOptions()
+	text, _ := htmlToCleanText(context.Background(), doc.Find("main"), options, "")
+	if want := "## Glossary CrawlerFetches pages.FrontierQueued URLs.Also a border."; text != want {
+		t.Errorf("plain text = %q, want %q", text, want)
+	}
+
+	options.DefinitionLists = true
+	options.Whitespace = WhitespacePreserveNewlines
+	text, _ = htmlToCleanText(context.Background(), doc.Find("main"), options, "")
+	want := "## Glossary\n\n**Crawler**\n: Fetches pages.\n\n**Frontier**\n: Queued URLs.\n: Also a border."
+	if text != want {
+		t.Errorf("text with definition lists = %q, want %q", text, want)
+	}
+}
+
+func TestHTMLToCleanTextPreserveFormatting(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(
+		`<main><p><strong>Warning:</strong> do <em>not</em> run<b> this <i>as root</i></b>.</p><p><b><strong>Once</strong></b> <i> </i>only</p></main>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := DefaultCrawlOptions()
+	text, _ := htmlToCleanText(context.Background(), doc.Find("main"), options, "")
+	if want := "Warning: do not run this as root. Once only"; text != want {
+		t.Errorf("plain text = %q, want %q", text, want)
+	}
+
+	options.PreserveFormatting = true
+	text, _ = htmlToCleanText(context.Background(), doc.Find("main"), options, "")
+	if want := "**Warning:** do *not* run **this *as root***. **Once** only"; text != want {
+		t.Errorf("formatted text = %q, want %q", text, want)
+	}
+}
+
+func TestHTMLToCleanTextBoilerplatePhrases(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(
+		`<main><p>ACCEPT ALL   cookies!</p><p>Our design update ships today.</p><p>Sign up for our newsletter. Sign up now</p><pre>Sign up</pre></main>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := DefaultCrawlOptions()
+	options.BoilerplatePhrases = []string{"Accept all cookies", "sign up", "Sign up for our newsletter", "  "}
+	options.Whitespace = WhitespacePreserveNewlines
+	text, _ := htmlToCleanText(context.Background(), doc.Find("main"), options, "")
+	if want := "Our design update ships today.\n\nnow\n\n```\nSign up\n```"; text != want {
+		t.Errorf("text = %q, want %q", text, want)
+	}
+}
+
+type fakeOCR struct {
+	calls int
+}
+
+func (o *fakeOCR) Recognize(image []byte, contentType string) (string, error) {
+	o.calls++
+	return "text in " + string(image) + "\n(" + contentType + ")", nil
+}
+
+func TestHTMLToCleanTextOCRImages(t *testing.T) {
+	var requests atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chart.png", func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "image/png")
+		fmt.Fprint(w, "chart")
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(
+		`<main><p>Sales <img src="chart.png"> rose.</p><p><img src="/chart.png"><img src="logo.png" alt="Logo"></p></main>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := manualOptions()
+	text, _ := htmlToCleanText(context.Background(), doc.Find("main"), options, srv.URL+"/")
+	if want := "Sales rose."; text != want {
+		t.Errorf("text without OCRImages = %q, want %q", text, want)
+	}
+
+	// Without an OCR there is nothing to download images for
+	options.OCRImages = true
+	if text, _ = htmlToCleanText(context.Background(), doc.Find("main"), options, srv.URL+"/"); text != "Sales rose." || requests.Load() != 0 {
+		t.Errorf("text without OCR = %q after %d image requests, want no requests", text, requests.Load())
+	}
+
+	ocr := &fakeOCR{}
+	options.OCR = ocr
+	text, _ = htmlToCleanText(context.Background(), doc.Find("main"), options, srv.URL+"/")
+	if want := "Sales [Image text: text in chart (image/png)] rose. [Image text: text in chart (image/png)]"; text != want {
+		t.Errorf("text with OCRImages = %q, want %q", text, want)
+	}
+	if ocr.calls != 1 {
+		t.Errorf("OCR ran %d times, want once for the repeated image", ocr.calls)
+	}
+
+	// FetchImage replaces the request
+	var fetched []string
+	options.FetchImage = func(ctx context.Context, imageURL string, options *CrawlOptions) (*FetchedPage, error) {
+		fetched = append(fetched, imageURL)
+		return &FetchedPage{URL: imageURL, Header: http.Header{"Content-Type": {"image/gif"}}, Body: []byte("hook")}, nil
+	}
+	text, _ = htmlToCleanText(context.Background(), doc.Find("main"), options, srv.URL+"/")
+	if want := "Sales [Image text: text in hook (image/gif)] rose. [Image text: text in hook (image/gif)]"; text != want || len(fetched) != 1 || requests.Load() != 1 {
+		t.Errorf("text with FetchImage = %q after fetching %v, want %q", text, fetched, want)
+	}
+}
+
+func TestCrawlWebsiteWithReadability(t *testing.T) {
+	srv := newFixtureServer(t, map[string]string{"/article": articlePage})
+
+	result, err := CrawlWebsite(srv.URL+"/article", nil)
+	if err != nil {
+		t.Fatalf("CrawlWebsite returned error: %v", err)
+	}
+	if !strings.Contains(result.Content, "The first paragraph of the article.") {
+		t.Errorf("content is missing the article text: %q", result.Content)
+	}
+}
+
+func TestCrawlWebsiteFollowsRedirects(t *testing.T) {
+	srv := newFixtureServer(t, map[string]string{"/article": articlePage})
+
+	result, err := CrawlWebsite(srv.URL+"/moved", manualOptions())
+	if err != nil {
+		t.Fatalf("CrawlWebsite returned error: %v", err)
+	}
+	if !strings.Contains(result.Content, "Article heading") {
+		t.Errorf("redirect target was not crawled: %q", result.Content)
+	}
+}
+
+func TestCrawlWebsiteRelativeRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/docs/start", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "intro/") // relative to /docs/
+		w.WriteHeader(http.StatusFound)
+	})
+	mux.HandleFunc("/docs/intro/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "../guide?from=intro")
+		w.WriteHeader(http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/docs/guide", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><main><p>Guide</p><a href="chapter-1">Chapter 1</a></main></body></html>`)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	result, err := CrawlWebsite(srv.URL+"/docs/start", manualOptions())
+	if err != nil {
+		t.Fatalf("CrawlWebsite returned error: %v", err)
+	}
+
+	if want := srv.URL + "/docs/guide?from=intro"; result.FinalURL != want {
+		t.Errorf("FinalURL = %q, want %q", result.FinalURL, want)
+	}
+	wantChain := []string{srv.URL + "/docs/start", srv.URL + "/docs/intro/"}
+	if fmt.Sprint(result.RedirectChain) != fmt.Sprint(wantChain) {
+		t.Errorf("RedirectChain = %v, want %v", result.RedirectChain, wantChain)
+	}
+	if len(result.Links.Internal) != 1 || result.Links.Internal[0].Href != srv.URL+"/docs/chapter-1" {
+		t.Errorf("links should resolve against the final URL: %+v", result.Links.Internal)
+	}
+}
+
+func TestCrawlWebsiteWithoutFollowingRedirects(t *testing.T) {
+	srv := newFixtureServer(t, map[string]string{"/article": articlePage})
+
+	options := manualOptions()
+	options.FollowRedirects = false
+	_, err := CrawlWebsite(srv.URL+"/moved", options)
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusMovedPermanently {
+		t.Fatalf("error = %v, want a 301 StatusError", err)
+	}
+}
+
+func TestCrawlWebsiteErrors(t *testing.T) {
+	srv := newFixtureServer(t, map[string]string{"/article": articlePage})
+
+	tests := []struct {
+		name    string
+		path    string
+		options func(*CrawlOptions)
+		want    error
+	}{
+		{
+			name: "not found",
+			path: "/missing",
+			want: ErrNonOK,
+		},
+		{
+			name:    "timeout",
+			path:    "/slow",
+			options: func(o *CrawlOptions) { o.Timeout = 50 * time.Millisecond },
+			want:    ErrTimeout,
+		},
+		{
+			name:    "response header timeout",
+			path:    "/slow",
+			options: func(o *CrawlOptions) { o.ResponseHeaderTimeout = 50 * time.Millisecond },
+			want:    ErrTimeout,
+		},
+		{
+			name:    "body too large",
+			path:    "/article",
+			options: func(o *CrawlOptions) { o.MaxBodySize = 16 },
+			want:    ErrBodyTooLarge,
+		},
+		{
+			name:    "redirect loop",
+			path:    "/loop",
+			options: func(o *CrawlOptions) { o.MaxRedirects = 3 },
+			want:    ErrTooManyRedirects,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := manualOptions()
+			if tt.options != nil {
+				tt.options(options)
+			}
+
+			_, err := CrawlWebsite(srv.URL+tt.path, options)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCrawlWebsiteContext(t *testing.T) {
+	srv := newFixtureServer(t, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := CrawlWebsiteContext(ctx, srv.URL+"/slow", manualOptions())
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancelled request took %v", elapsed)
+	}
+
+	// A crawl-wide deadline earlier than Timeout still applies
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = CrawlWebsiteContext(ctx, srv.URL+"/slow", manualOptions())
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("error = %v, want ErrTimeout", err)
+	}
+}
+
+func TestCrawlWebsiteRetries(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := attempts.Add(1)
+		switch r.URL.Path {
+		case "/flaky":
+			if n == 1 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			if n == 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, articlePage)
+		case "/down":
+			w.WriteHeader(http.StatusBadGateway)
+		case "/later":
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	options := manualOptions()
+	options.MaxRetries = 2
+	options.RetryBackoff = 10 * time.Millisecond
+	result, err := CrawlWebsite(srv.URL+"/flaky", options)
+	if err != nil {
+		t.Fatalf("CrawlWebsite() error = %v", err)
+	}
+	if !strings.Contains(result.Content, "first paragraph") || attempts.Load() != 3 {
+		t.Errorf("got %d attempts and content %q, want the third attempt's page", attempts.Load(), result.Content)
+	}
+
+	attempts.Store(0)
+	_, err = CrawlWebsite(srv.URL+"/down", options)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadGateway || attempts.Load() != 3 {
+		t.Fatalf("error = %v after %d attempts, want a 502 after 3", err, attempts.Load())
+	}
+	if !strings.Contains(err.Error(), "502") {
+		t.Errorf("error %q does not include the status code", err)
+	}
+
+	// Not worth retrying
+	attempts.Store(0)
+	if _, err := CrawlWebsite(srv.URL+"/missing", options); !errors.Is(err, ErrNonOK) || attempts.Load() != 1 {
+		t.Errorf("error = %v after %d attempts, want one 404", err, attempts.Load())
+	}
+
+	// Retry-After asks for too long a wait
+	attempts.Store(0)
+	if _, err := CrawlWebsite(srv.URL+"/later", options); !errors.As(err, &statusErr) || attempts.Load() != 1 {
+		t.Errorf("error = %v after %d attempts, want one 503", err, attempts.Load())
+	}
+}
+
+func TestCrawlWebsiteHeadersAndCookies(t *testing.T) {
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		fmt.Fprint(w, articlePage)
+	}))
+	t.Cleanup(srv.Close)
+
+	options := manualOptions()
+	options.Headers = map[string]string{"X-Api-Key": "secret", "Accept": "text/html"}
+	options.Cookies = []*http.Cookie{{Name: "session", Value: "abc123"}}
+	if _, err := CrawlWebsite(srv.URL+"/", options); err != nil {
+		t.Fatalf("CrawlWebsite() error = %v", err)
+	}
+
+	if key := got.Header.Get("X-Api-Key"); key != "secret" {
+		t.Errorf("X-Api-Key = %q, want secret", key)
+	}
+	if accept := got.Header.Values("Accept"); len(accept) != 1 || accept[0] != "text/html" {
+		t.Errorf("Accept = %q, want the default replaced", accept)
+	}
+	if cookie, err := got.Cookie("session"); err != nil || cookie.Value != "abc123" {
+		t.Errorf("session cookie = %v, %v", cookie, err)
+	}
+	if got.Header.Get("User-Agent") != options.UserAgent {
+		t.Errorf("User-Agent = %q, want the default kept", got.Header.Get("User-Agent"))
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := map[string]time.Duration{
+		"":                              0,
+		"120":                           2 * time.Minute,
+		"-5":                            0,
+		"soon":                          0,
+		"Mon, 01 Jan 2024 12:00:30 GMT": 30 * time.Second,
+		"Mon, 01 Jan 2024 11:00:00 GMT": 0,
+	}
+	for value, want := range tests {
+		if got := parseRetryAfter(value, now); got != want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestCrawlWebsiteLocalFiles(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret.html")
+	if err := os.WriteFile(secret, []byte(articlePage), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mirror := filepath.Join(dir, "mirror")
+	if err := os.Mkdir(mirror, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	index := filepath.Join(mirror, "index.html")
+	if err := os.WriteFile(index, []byte(articlePage), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fileURL := func(p string) string {
+		return (&url.URL{Scheme: "file", Path: filepath.ToSlash(p)}).String()
+	}
+
+	result, err := CrawlWebsite(fileURL(index), manualOptions())
+	if err != nil || !strings.Contains(result.Content, "first paragraph") {
+		t.Fatalf("CrawlWebsite(file) = %v, %v", result, err)
+	}
+
+	// A web server can't redirect the crawler into the local filesystem
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, fileURL(secret), http.StatusFound)
+	}))
+	t.Cleanup(srv.Close)
+	if result, err := CrawlWebsite(srv.URL+"/", manualOptions()); err == nil {
+		t.Errorf("redirect to a local file was followed: %q", result.Content)
+	}
+
+	transport := NewFileTransport(filepath.ToSlash(mirror))
+	for _, target := range []string{fileURL(secret), fileURL(mirror) + "/../secret.html", srv.URL + "/"} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if resp, err := transport.RoundTrip(req); err == nil {
+			resp.Body.Close()
+			t.Errorf("file transport served %s outside the mirror", target)
+		}
+	}
+}
+
+func TestCrawlWebsiteMetaRefresh(t *testing.T) {
+	srv := newFixtureServer(t, map[string]string{
+		"/old": `<html><head><meta http-equiv="Refresh" content="0; URL='/article'"></head><body></body></html>`,
+	})
+
+	result, err := CrawlWebsite(srv.URL+"/old", manualOptions())
+	if err != nil {
+		t.Fatalf("CrawlWebsite returned error: %v", err)
+	}
+	if want := srv.URL + "/article"; result.MetaRefreshURL != want {
+		t.Errorf("MetaRefreshURL = %q, want %q", result.MetaRefreshURL, want)
+	}
+}
+
+func TestCrawlWebsiteKeepNoscript(t *testing.T) {
+	srv := newFixtureServer(t, map[string]string{
+		"/page": `<html><body><main><p>Visible text</p><noscript><p>Fallback text</p></noscript></main></body></html>`,
+	})
+
+	options := manualOptions()
+	result, err := CrawlWebsite(srv.URL+"/page", options)
+	if err != nil {
+		t.Fatalf("CrawlWebsite returned error: %v", err)
+	}
+	if strings.Contains(result.Content, "Fallback text") {
+		t.Errorf("noscript content should be removed by default: %q", result.Content)
+	}
+
+	options.KeepNoscript = true
+	result, err = CrawlWebsite(srv.URL+"/page", options)
+	if err != nil {
+		t.Fatalf("CrawlWebsite returned error: %v", err)
+	}
+	if !strings.Contains(result.Content, "Fallback text") || strings.Contains(result.Content, "<p>") {
+		t.Errorf("noscript content should be kept as text: %q", result.Content)
+	}
+}
+
+func TestParseMetaRefresh(t *testing.T) {
+	tests := map[string]string{
+		"0;url=/next":               "/next",
+		"5; URL='https://e.com/'":   "https://e.com/",
+		`3, url="page.html"`:        "page.html",
+		"0; url = relative/target":  "relative/target",
+		"30":                        "",
+		"0;":                        "",
+		"0; https://example.com/ok": "https://example.com/ok",
+	}
+
+	for content, want := range tests {
+		if got := parseMetaRefresh(content); got != want {
+			t.Errorf("parseMetaRefresh(%q) = %q, want %q", content, got, want)
+		}
+	}
+}
+
+func TestExtractMicrodata(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`
+		<div itemscope itemtype="https://schema.org/Product">
+			<span itemprop="name">Widget</span>
+			<img itemprop="image" src="/widget.png">
+			<div itemprop="offers" itemscope itemtype="https://schema.org/Offer">
+				<meta itemprop="price" content="9.99">
+			</div>
+		</div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := extractMicrodata(doc, "https://shop.example/products/")
+	if len(items) != 1 {
+		t.Fatalf("got %d items, want 1", len(items))
+	}
+
+	product := items[0]
+	if got := product.Properties["name"]; len(got) != 1 || got[0] != "Widget" {
+		t.Errorf("name = %v", got)
+	}
+	if got := product.Properties["image"]; len(got) != 1 || got[0] != "https://shop.example/widget.png" {
+		t.Errorf("image = %v", got)
+	}
+
+	offers := product.Properties["offers"]
+	if len(offers) != 1 {
+		t.Fatalf("offers = %v", offers)
+	}
+	offer, ok := offers[0].(*MicrodataItem)
+	if !ok {
+		t.Fatalf("offer has type %T, want *MicrodataItem", offers[0])
+	}
+	if got := offer.Properties["price"]; len(got) != 1 || got[0] != "9.99" {
+		t.Errorf("price = %v", got)
+	}
+	if _, leaked := product.Properties["price"]; leaked {
+		t.Error("nested item property leaked into parent item")
+	}
+}
+
+func TestParseSrcset(t *testing.T) {
+	tests := map[string]string{
+		"a.jpg 1x, b.jpg 2x":                       "[{a.jpg 1x} {b.jpg 2x}]",
+		"small.png 480w,large.png 1080w":           "[{small.png 480w} {large.png 1080w}]",
+		"/img?w=100,200 100w, /img?w=400,800 400w": "[{/img?w=100,200 100w} {/img?w=400,800 400w}]",
+		"only.jpg":                 "[{only.jpg }]",
+		"first.jpg, second.jpg 2x": "[{first.jpg } {second.jpg 2x}]",
+		"  ":                       "[]",
+	}
+
+	for srcset, want := range tests {
+		if got := fmt.Sprint(parseSrcset(srcset)); got != want {
+			t.Errorf("parseSrcset(%q) = %s, want %s", srcset, got, want)
+		}
+	}
+}
+
+func TestExtractImages(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`
+		<picture>
+			<source srcset="/hero-large.webp 1600w, /hero-medium.webp 800w" type="image/webp">
+			<img src="/hero.jpg" alt="Hero" srcset="/hero@2x.jpg 2x">
+		</picture>
+		<img src="data:image/gif;base64,R0lGOD">
+		<img src="https://cdn.example/hero.jpg">`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	images := extractImages(doc, "https://example.com/page")
+	var got []string
+	for _, image := range images {
+		got = append(got, fmt.Sprintf("%s|%s|%s", image.URL, image.Alt, image.Descriptor))
+	}
+	want := []string{
+		"https://example.com/hero-large.webp|Hero|1600w",
+		"https://example.com/hero-medium.webp|Hero|800w",
+		"https://example.com/hero.jpg|Hero|",
+		"https://example.com/hero@2x.jpg|Hero|2x",
+		"https://cdn.example/hero.jpg||",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("images =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestExtractTables(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`
+		<table>
+			<caption> Quarterly results </caption>
+			<thead><tr><th>Quarter</th><th colspan="2">Revenue, USD</th></tr></thead>
+			<tbody>
+				<tr><td>Q1</td><td>1,200</td><td>"est."</td></tr>
+				<tr><td>Q2</td><td><table><tr><td>nested</td></tr></table></td><td></td></tr>
+			</tbody>
+		</table>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tables := extractTables(doc)
+	if len(tables) != 2 {
+		t.Fatalf("got %d tables, want 2", len(tables))
+	}
+	if tables[0].Caption != "Quarterly results" {
+		t.Errorf("Caption = %q", tables[0].Caption)
+	}
+
+	var b strings.Builder
+	if err := tables[0].WriteCSV(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := "Quarter,\"Revenue, USD\",\nQ1,\"1,200\",\"\"\"est.\"\"\"\nQ2,nested,\n"
+	if b.String() != want {
+		t.Errorf("WriteCSV() =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestHasPaywallMarker(t *testing.T) {
+	tests := map[string]bool{
+		`<meta property="og:type" content="Paywall">`:                                                        true,
+		`<script type="application/ld+json">{"@type":"NewsArticle","isAccessibleForFree": "False"}</script>`: true,
+		`<div itemscope><meta itemprop="isAccessibleForFree" content="false"></div>`:                         true,
+		`<script type="application/ld+json">{"isAccessibleForFree": true}</script>`:                          false,
+		`<meta property="og:type" content="article">`:                                                        false,
+	}
+
+	for html, want := range tests {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hasPaywallMarker(doc); got != want {
+			t.Errorf("hasPaywallMarker(%s) = %v, want %v", html, got, want)
+		}
+	}
+}
+
+func TestExtractPublishedAt(t *testing.T) {
+	header := http.Header{"Last-Modified": {"Tue, 03 Jun 2025 10:00:00 GMT"}}
+
+	tests := []struct {
+		html       string
+		wantDate   string
+		wantSource DateSource
+	}{
+		{`<meta property="article:published_time" content="2024-05-01T08:30:00+02:00"><time datetime="2020-01-01">`, "2024-05-01T08:30:00+02:00", DateSourceMeta},
+		{`<script type="application/ld+json">{"@graph":[{"@type":"WebPage"},{"@type":"Article","datePublished":"2023-11-20"}]}</script>`, "2023-11-20T00:00:00Z", DateSourceJSONLD},
+		{`<p>Posted <time datetime="2022-02-14T09:00">Feb 14</time></p>`, "2022-02-14T09:00:00Z", DateSourceTimeElement},
+		{`<time datetime="soon">later</time>`, "2025-06-03T10:00:00Z", DateSourceLastModified},
+	}
+	for _, tt := range tests {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(tt.html))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, source := extractPublishedAt(doc, header)
+		if got.Format(time.RFC3339) != tt.wantDate || source != tt.wantSource {
+			t.Errorf("extractPublishedAt(%s) = %s (%s), want %s (%s)", tt.html, got.Format(time.RFC3339), source, tt.wantDate, tt.wantSource)
+		}
+	}
+
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<p>undated</p>`))
+	if got, source := extractPublishedAt(doc, http.Header{}); !got.IsZero() || source != "" {
+		t.Errorf("extractPublishedAt(undated) = %v (%s), want zero", got, source)
+	}
+}
+
+func TestFindJSONStringOrder(t *testing.T) {
+	var data interface{}
+	doc := `{"@type":"WebPage","publisher":{"datePublished":"2001-01-01"},"about":{"datePublished":"2002-02-02"},"mainEntity":{"@type":"Article","datePublished":"2023-11-20"}}`
+	if err := json.Unmarshal([]byte(doc), &data); err != nil {
+		t.Fatal(err)
+	}
+	// Map iteration order varies, so a random pick would show up across runs
+	for range 20 {
+		if got := findJSONString(data, "datePublished"); got != "2023-11-20" {
+			t.Fatalf("findJSONString() = %q, want the mainEntity date", got)
+		}
+	}
+	delete(data.(map[string]interface{}), "mainEntity")
+	if got := findJSONString(data, "datePublished"); got != "2002-02-02" {
+		t.Errorf("findJSONString() without mainEntity = %q, want the first key in sorted order", got)
+	}
+}
+
+func TestExtractMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		html    string
+		sources []TitleSource
+		want    string
+	}{
+		{
+			name: "og:title preferred",
+			html: `<head><title>Release notes | Example</title><meta property="og:title" content="Release notes"></head>`,
+			want: "Release notes",
+		},
+		{
+			name: "generic og:title",
+			html: `<head><title>Release notes | Example</title><meta property="og:title" content="Example"><meta property="og:site_name" content="Example"></head>`,
+			want: "Release notes | Example",
+		},
+		{
+			name: "heading fallback",
+			html: `<body><h1> Getting
+				started </h1></body>`,
+			want: "Getting started",
+		},
+		{
+			name:    "configured order",
+			html:    `<head><title>Release notes | Example</title><meta property="og:title" content="Release notes"></head>`,
+			sources: []TitleSource{TitleHTML, TitleOpenGraph},
+			want:    "Release notes | Example",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := goquery.NewDocumentFromReader(strings.NewReader(tt.html))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := extractMetadata(doc, tt.sources).Title; got != tt.want {
+				t.Errorf("Title = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLinkHeader(t *testing.T) {
+	links := parseLinkHeader([]string{
+		`</articles?page=2>; rel="next"; title="Page 2, of 9", <https://example.com/articles>; rel=canonical`,
+		`</articles/amp>; rel="amphtml alternate", </other>; rel="next"`,
+	}, "https://example.com/articles?page=1")
+
+	want := map[string]string{
+		"next":      "https://example.com/articles?page=2",
+		"canonical": "https://example.com/articles",
+		"amphtml":   "https://example.com/articles/amp",
+		"alternate": "https://example.com/articles/amp",
+	}
+	if fmt.Sprint(links) != fmt.Sprint(want) {
+		t.Errorf("parseLinkHeader() = %v, want %v", links, want)
+	}
+}
+
+func TestCrawlWebsiteLinkHeader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", `</page/2>; rel="next", </canonical>; rel="canonical"`)
+		fmt.Fprint(w, `<html><head><link rel="canonical" href="/from-html"></head><body><p>Page one</p></body></html>`)
+	}))
+	t.Cleanup(srv.Close)
+
+	result, err := CrawlWebsite(srv.URL+"/page/1", manualOptions())
+	if err != nil {
+		t.Fatalf("CrawlWebsite returned error: %v", err)
+	}
+	if want := srv.URL + "/page/2"; result.NextURL != want {
+		t.Errorf("NextURL = %q, want %q", result.NextURL, want)
+	}
+	// The markup takes precedence over the header
+	if want := srv.URL + "/from-html"; result.CanonicalURL != want {
+		t.Errorf("CanonicalURL = %q, want %q", result.CanonicalURL, want)
+	}
+}
+
+func TestNewTransportHostIPOverride(t *testing.T) {
+	srv := newFixtureServer(t, map[string]string{"/article": articlePage})
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := manualOptions()
+	options.Transport = NewTransport(nil, map[string]string{"staging.invalid": u.Hostname()})
+
+	result, err := CrawlWebsite("http://staging.invalid:"+u.Port()+"/article", options)
+	if err != nil {
+		t.Fatalf("CrawlWebsite returned error: %v", err)
+	}
+	if !strings.Contains(result.Content, "Article heading") {
+		t.Errorf("unexpected content: %q", result.Content)
+	}
+}
+
+func TestDNSCacheDialsBothFamilies(t *testing.T) {
+	srv := newFixtureServer(t, map[string]string{"/article": articlePage})
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The IPv6 documentation address never answers; the IPv4 one must be
+	// tried without waiting out the dial timeout
+	cache := &dnsCache{
+		dialer:   &net.Dialer{Timeout: 30 * time.Second},
+		entries:  map[string]dnsEntry{"dual.test": {addrs: []string{"2001:db8::1", u.Hostname()}, expires: time.Now().Add(time.Minute)}},
+		inflight: make(map[string]*dnsLookup),
+	}
+	start := time.Now()
+	conn, err := cache.dialContext(context.Background(), "tcp", "dual.test:"+u.Port())
+	if err != nil {
+		t.Fatalf("dialContext() error = %v", err)
+	}
+	conn.Close()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("dial took %v, want the IPv4 address raced", elapsed)
+	}
+}
+
+func TestDNSCacheJoinsLookupInFlight(t *testing.T) {
+	// No resolver: a second lookup would panic
+	call := &dnsLookup{done: make(chan struct{})}
+	cache := &dnsCache{
+		entries:  make(map[string]dnsEntry),
+		inflight: map[string]*dnsLookup{"slow.test": call},
+	}
+
+	var wg sync.WaitGroup
+	results := make([][]string, 3)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = cache.lookup(context.Background(), "slow.test")
+		}()
+	}
+	call.addrs = []string{"192.0.2.1"}
+	close(call.done)
+	wg.Wait()
+	for i, addrs := range results {
+		if fmt.Sprint(addrs) != "[192.0.2.1]" {
+			t.Errorf("lookup %d = %v, want the in-flight result", i, addrs)
+		}
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestCrawlWebsiteCustomTransport(t *testing.T) {
+	var requested []string
+	options := manualOptions()
+	options.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requested = append(requested, req.URL.String())
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"text/html"}},
+			Body:       io.NopCloser(strings.NewReader(articlePage)),
+			Request:    req,
+		}, nil
+	})
+
+	result, err := CrawlWebsite("https://replayed.invalid/article", options)
+	if err != nil {
+		t.Fatalf("CrawlWebsite returned error: %v", err)
+	}
+	if len(requested) != 1 || requested[0] != "https://replayed.invalid/article" {
+		t.Errorf("requests = %v", requested)
+	}
+	if !strings.Contains(result.Content, "Article heading") {
+		t.Errorf("unexpected content: %q", result.Content)
+	}
+}
+
+func TestNewTokenTransport(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r.Header.Get("Authorization")+" "+string(body))
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `<html><body><main><p>Members only</p></main></body></html>`)
+	}))
+	t.Cleanup(srv.Close)
+
+	tokens := []string{"expired", "fresh"}
+	provider := func(ctx context.Context) (string, error) {
+		token := tokens[0]
+		tokens = tokens[1:]
+		return token, nil
+	}
+
+	options := manualOptions()
+	options.Transport = NewTokenTransport(nil, provider)
+	options.Method = http.MethodPost
+	options.Body = []byte("q=docs")
+	for i := 0; i < 2; i++ {
+		result, err := CrawlWebsite(srv.URL+"/", options)
+		if err != nil {
+			t.Fatalf("CrawlWebsite returned error: %v", err)
+		}
+		if !strings.Contains(result.Content, "Members only") {
+			t.Errorf("unexpected content: %q", result.Content)
+		}
+	}
+
+	want := "[Bearer expired q=docs Bearer fresh q=docs Bearer fresh q=docs]"
+	if fmt.Sprint(requests) != want {
+		t.Errorf("requests = %v, want %s", requests, want)
+	}
+}
+
+func TestExtractLinksSkipsInPageAnchors(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`
+		<a href="#intro">Intro</a><a href="#">Top</a>
+		<a href="#/users">Users</a><a href="/guide#install">Install</a>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	links := extractLinks(doc.Selection, "https://example.com/docs", nil, false)
+	var hrefs []string
+	for _, link := range links.Internal {
+		hrefs = append(hrefs, link.Href)
+	}
+	want := "[https://example.com/docs#/users https://example.com/guide#install]"
+	if fmt.Sprint(hrefs) != want || links.Anchors != 2 {
+		t.Errorf("internal = %v, anchors = %d; want %s, 2", hrefs, links.Anchors, want)
+	}
+}
+
+func TestExtractLinksAttributes(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`
+		<a href="/a">A</a><div data-href="/b">B</div><span data-url="/c" href="/ignored">C</span>
+		<button data-href="" data-url="/d">D</button>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hrefs := func(links Links) string {
+		var got []string
+		for _, link := range links.Internal {
+			got = append(got, strings.TrimPrefix(link.Href, "https://example.com"))
+		}
+		return fmt.Sprint(got)
+	}
+
+	if got := hrefs(extractLinks(doc.Selection, "https://example.com/", nil, false)); got != "[/a]" {
+		t.Errorf("default attributes found %s, want [/a]", got)
+	}
+	attributes := []string{"href", "data-href", "data-url"}
+	if got := hrefs(extractLinks(doc.Selection, "https://example.com/", attributes, false)); got != "[/a /b /c /d]" {
+		t.Errorf("data attributes found %s, want [/a /b /c /d]", got)
+	}
+}
+
+func TestExtractLinksOnclick(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`
+		<a href="#" onclick="location='/a'">A</a>
+		<a href="javascript:void(0)" onclick="window.location.href = &quot;/b&quot;; return false">B</a>
+		<button onclick="window.open('/c', '_blank')">C</button>
+		<a href="/real" onclick="location='/ignored'">Real</a>
+		<a href="#" onclick="if (location == '/x') go()">Comparison</a>
+		<a href="#" onclick="mylocation='/y'">Other variable</a>
+		<div onclick="toggle()">Toggle</div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hrefs := func(links Links) string {
+		var got []string
+		for _, link := range links.Internal {
+			got = append(got, strings.TrimPrefix(link.Href, "https://example.com"))
+		}
+		return fmt.Sprint(got)
+	}
+
+	if got := hrefs(extractLinks(doc.Selection, "https://example.com/", nil, true)); got != "[/a /b /c /real]" {
+		t.Errorf("onclick links = %s, want [/a /b /c /real]", got)
+	}
+	if got := hrefs(extractLinks(doc.Selection, "https://example.com/", nil, false)); got != "[/real]" {
+		t.Errorf("links without FollowOnclick = %s, want [/real]", got)
+	}
+}
+
+func TestExtractMixedContent(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<head>
+		<link rel="stylesheet" href="http://cdn.example/site.css">
+		<link rel="canonical" href="http://example.com/page">
+		<script src="//cdn.example/app.js"></script>
+	</head><body>
+		<img src="http://img.example/a.png" srcset="https://img.example/a-2x.png 2x, http://img.example/a-3x.png 3x">
+		<img src="/local.png"><a href="http://other.example/">Insecure link</a>
+		<iframe src="http://embed.example/"></iframe><img src="http://img.example/a.png">
+	</body>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := extractMixedContent(doc, "https://example.com/page")
+	want := []string{"http://img.example/a.png", "http://embed.example/", "http://cdn.example/site.css", "http://img.example/a-3x.png"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("extractMixedContent() = %v, want %v", got, want)
+	}
+	if got := extractMixedContent(doc, "http://example.com/page"); got != nil {
+		t.Errorf("http:// page reported mixed content: %v", got)
+	}
+}
+
+func TestExtractBreadcrumbs(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want []string
+	}{
+		{
+			name: "json-ld in graph",
+			html: `<script type="application/ld+json">{"@graph": [{"@type": "WebPage"}, {"@type": "BreadcrumbList", "itemListElement": [
+				{"@type": "ListItem", "position": 2, "item": {"@id": "/docs", "name": "Docs"}},
+				{"@type": "ListItem", "position": 1, "name": "Home", "item": "/"},
+				{"@type": "ListItem", "position": 3, "name": " Install "}
+			]}]}</script><nav aria-label="breadcrumb"><a href="/">Ignored</a></nav>`,
+			want: []string{"Home", "Docs", "Install"},
+		},
+		{
+			name: "nav list",
+			html: `<nav aria-label="Breadcrumb"><ol><li><a href="/">Home</a></li><li><a href="/blog">Blog</a></li><li>Post</li></ol></nav>`,
+			want: []string{"Home", "Blog", "Post"},
+		},
+		{
+			name: "none",
+			html: `<nav><ul><li><a href="/">Home</a></li></ul></nav>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := goquery.NewDocumentFromReader(strings.NewReader(tt.html))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := extractBreadcrumbs(doc); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("extractBreadcrumbs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractFavicons(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<head>
+		<link rel="Shortcut Icon" href="/static/favicon.png">
+		<link rel="apple-touch-icon" sizes="180x180" href="https://cdn.example/touch.png">
+		<link rel="stylesheet" href="/site.css">
+		<link rel="icon" href="/favicon.ico">
+	</head>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := extractFavicons(doc, "https://example.com/blog/post")
+	want := []string{
+		"https://example.com/static/favicon.png",
+		"https://cdn.example/touch.png",
+		"https://example.com/favicon.ico",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("extractFavicons() = %v, want %v", got, want)
+	}
+}
+
+func TestExtractIframes(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<body>
+		<iframe src="https://www.youtube.com/embed/abc"></iframe>
+		<iframe src="/maps/embed?q=1"></iframe>
+		<iframe src="about:blank" data-src="/docs/viewer"></iframe>
+		<iframe src="javascript:false"></iframe>
+		<iframe src="https://www.youtube.com/embed/abc"></iframe>
+		<iframe src="https://ads.doubleclick.net/ad"></iframe>
+	</body>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := extractIframes(doc, "https://example.com/blog/post")
+	want := []string{
+		"https://www.youtube.com/embed/abc",
+		"https://example.com/maps/embed?q=1",
+		"https://example.com/docs/viewer",
+		"https://ads.doubleclick.net/ad",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("extractIframes() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,83 @@
+package webspider
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+)
+
+// ResultDiff classifies the pages of two crawls of the same site.
+type ResultDiff struct {
+	New       []string `json:"new"`       // Crawled now but not before
+	Changed   []string `json:"changed"`   // Crawled both times with different content
+	Unchanged []string `json:"unchanged"` // Crawled both times with identical content
+	Removed   []string `json:"removed"`   // Crawled before but not now
+	// NewlyBroken lists the pages that failed now but not before, and Fixed
+	// those that failed before but no longer do.
+	NewlyBroken []string `json:"newly_broken"`
+	Fixed       []string `json:"fixed"`
+}
+
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// DiffResults compares the pages of a previous crawl with the current one
+// using their content hashes. A nil previous result reports every page as new.
+func DiffResults(previous, current *SpiderResult) *ResultDiff {
+	before := make(map[string]string)
+	if previous != nil {
+		for _, page := range previous.Pages {
+			before[page.URL] = page.ContentHash
+		}
+	}
+
+	diff := &ResultDiff{}
+	seen := make(map[string]bool)
+	if current != nil {
+		for _, page := range current.Pages {
+			seen[page.URL] = true
+			hash, existed := before[page.URL]
+			switch {
+			case !existed:
+				diff.New = append(diff.New, page.URL)
+			case hash != page.ContentHash:
+				diff.Changed = append(diff.Changed, page.URL)
+			default:
+				diff.Unchanged = append(diff.Unchanged, page.URL)
+			}
+		}
+	}
+	for pageURL := range before {
+		if !seen[pageURL] {
+			diff.Removed = append(diff.Removed, pageURL)
+		}
+	}
+
+	var brokenBefore, brokenNow map[string]string
+	if previous != nil {
+		brokenBefore = previous.FailedPages
+	}
+	if current != nil {
+		brokenNow = current.FailedPages
+	}
+	for pageURL := range brokenNow {
+		if _, ok := brokenBefore[pageURL]; !ok {
+			diff.NewlyBroken = append(diff.NewlyBroken, pageURL)
+		}
+	}
+	for pageURL := range brokenBefore {
+		if _, ok := brokenNow[pageURL]; !ok {
+			diff.Fixed = append(diff.Fixed, pageURL)
+		}
+	}
+
+	sort.Strings(diff.New)
+	sort.Strings(diff.Changed)
+	sort.Strings(diff.Unchanged)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.NewlyBroken)
+	sort.Strings(diff.Fixed)
+	return diff
+}
@@ -0,0 +1,218 @@
+package webspider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amal5haji/go-webspider/webcrawl"
+	"go.uber.org/zap"
+)
+
+// DownloadedFile is the manifest entry for one detected file.
+type DownloadedFile struct {
+	URL         string `json:"url"`
+	Path        string `json:"path,omitempty"` // Where the file was saved; empty if it failed
+	Size        int64  `json:"size,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Attempts    int    `json:"attempts"`
+	Error       string `json:"error,omitempty"`
+	// Set instead of downloading when robots.txt disallows the file
+	SkippedByRobots bool `json:"skipped_by_robots,omitempty"`
+}
+
+type DownloadManifest struct {
+	Files      []DownloadedFile `json:"files"` // In DetectedFiles order
+	Downloaded int              `json:"downloaded"`
+	Failed     int              `json:"failed"`
+	Skipped    int              `json:"skipped"` // Disallowed by robots.txt
+}
+
+// DownloadFiles fetches every file in DetectedFiles into dir and writes the
+// manifest it returns to dir/manifest.json. Requests use the client settings
+// and politeness of EffectiveOptions (Transport, timeouts, UserAgent, headers,
+// Concurrency, DelayJitter, and DelayBetween, HostDelays or the robots.txt
+// Crawl-delay spacing the requests to each host), or the defaults when there
+// are none. With RespectRobotsTxt, files robots.txt disallows are skipped and
+// marked SkippedByRobots. A failed download is tried up to retries more
+// times like webcrawl.CrawlOptions.MaxRetries describes, waiting
+// EffectiveOptions.RetryBackoff times the attempt number or as long as a
+// Retry-After header asks; responses that a retry won't change, such as 404,
+// are not retried. Every file is tried at least once, even with negative
+// retries. Failures are recorded in the manifest rather than returned.
+func (r *SpiderResult) DownloadFiles(dir string, retries int) (*DownloadManifest, error) {
+	return r.DownloadFilesContext(context.Background(), dir, retries)
+}
+
+// DownloadFilesContext is DownloadFiles with a context. Once ctx is done no
+// more downloads are started and those in flight are aborted; they are
+// recorded as failed in the manifest.
+func (r *SpiderResult) DownloadFilesContext(ctx context.Context, dir string, retries int) (*DownloadManifest, error) {
+	logger, _ := zap.NewDevelopment()
+	defer logger.Sync()
+
+	options := r.EffectiveOptions
+	if options == nil {
+		options = DefaultSpiderOptions()
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create download directory: %w", err)
+	}
+
+	transport, closeTransport := crawlTransport(options)
+	defer closeTransport()
+	crawlOptions := webcrawl.DefaultCrawlOptions()
+	crawlOptions.Timeout = options.Timeout
+	crawlOptions.MaxBodySize = options.MaxBodySize
+	crawlOptions.UserAgent = options.UserAgent
+	crawlOptions.Headers = options.Headers
+	crawlOptions.Cookies = options.Cookies
+	crawlOptions.Transport = transport
+	crawlOptions.MaxRetries = max(retries, 0)
+	crawlOptions.RetryBackoff = options.RetryBackoff
+
+	var robots *robotsCache
+	if options.RespectRobotsTxt {
+		robots = newRobotsCache(ctx, options, transport, logger)
+	}
+	pacer := newHostPacer()
+
+	manifest := &DownloadManifest{Files: make([]DownloadedFile, len(r.DetectedFiles))}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(options.Concurrency, 1))
+	for i, detected := range r.DetectedFiles {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, fileURL string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			file := &manifest.Files[i]
+			file.URL = fileURL
+			if err := ctx.Err(); err != nil {
+				file.Error = err.Error()
+				return
+			}
+			if robots != nil && !robots.allowed(fileURL) {
+				file.SkippedByRobots = true
+				logger.Debug("Skipping file disallowed by robots.txt", zap.String("url", fileURL))
+				return
+			}
+			host, delay := downloadDelay(options, robots, fileURL)
+			for {
+				pacer.wait(ctx, host, jitteredDelay(delay, options.DelayJitter))
+				if err := ctx.Err(); err != nil {
+					file.Error = err.Error()
+					break
+				}
+				file.Attempts++
+
+				err := downloadFile(ctx, file, dir, i, crawlOptions)
+				if err == nil {
+					file.Error = ""
+					break
+				}
+				file.Error = err.Error()
+				logger.Debug("Failed to download file",
+					zap.String("url", fileURL),
+					zap.Int("attempt", file.Attempts),
+					zap.Error(err),
+				)
+				wait, retry := webcrawl.RetryDelay(err, file.Attempts, crawlOptions)
+				if !retry {
+					break
+				}
+				sleepContext(ctx, wait)
+			}
+		}(i, detected.URL)
+	}
+	wg.Wait()
+
+	for _, file := range manifest.Files {
+		switch {
+		case file.SkippedByRobots:
+			manifest.Skipped++
+		case file.Error == "":
+			manifest.Downloaded++
+		default:
+			manifest.Failed++
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "manifest.json"), data, 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write download manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// downloadDelay returns the host whose requests fileURL is spaced with and
+// the delay between them: its HostDelays entry, else DelayBetween or the
+// host's robots.txt Crawl-delay, whichever is longer.
+func downloadDelay(options *SpiderOptions, robots *robotsCache, fileURL string) (string, time.Duration) {
+	if host, delay, ok := hostDelay(options.HostDelays, fileURL); ok {
+		return host, delay
+	}
+	host := ""
+	if u, err := url.Parse(fileURL); err == nil {
+		host = strings.ToLower(u.Host)
+	}
+	delay := options.DelayBetween
+	if robots != nil {
+		if siteHost, crawlDelay := robots.crawlDelay(fileURL); crawlDelay > delay {
+			host, delay = siteHost, crawlDelay
+		}
+	}
+	return host, delay
+}
+
+// downloadFile saves one file as dir/NNNN-filename, numbered by its position
+// in DetectedFiles so files with the same name don't overwrite each other.
+// It downloads to a temporary file first so a failed attempt leaves nothing
+// behind.
+func downloadFile(ctx context.Context, file *DownloadedFile, dir string, index int, options *webcrawl.CrawlOptions) error {
+	tmp, err := os.CreateTemp(dir, ".download-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	info, err := webcrawl.DownloadFileContext(ctx, file.URL, tmp, options)
+	if closeErr := tmp.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write file: %w", closeErr)
+	}
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%04d-%s", index+1, downloadFilename(info.Filename)))
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to save file: %w", err)
+	}
+	file.Path = path
+	file.Size = info.Size
+	file.ContentType = info.ContentType
+	return nil
+}
+
+// downloadFilename makes a server-supplied filename safe to use in the
+// download directory.
+func downloadFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	switch {
+	case name == "." || name == "/":
+		return "file"
+	case strings.HasPrefix(name, "."):
+		return "file" + name
+	}
+	return name
+}
@@ -0,0 +1,68 @@
+package webspider
+
+import (
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventEnqueued       EventType = "enqueued"
+	EventFetchStarted   EventType = "fetch_started"
+	EventFetchCompleted EventType = "fetch_completed"
+	EventParsed         EventType = "parsed"
+	EventLinksExtracted EventType = "links_extracted"
+	EventErrored        EventType = "errored"
+)
+
+// CrawlEvent is one entry in the per-URL timeline of a crawl.
+type CrawlEvent struct {
+	Time       time.Time
+	Type       EventType
+	URL        string
+	Depth      int
+	StatusCode int    // Set on fetch_completed when a response was received
+	Links      int    // Number of crawlable links queued, for links_extracted
+	Error      string // Set on errored
+}
+
+type eventRecorder struct {
+	mu       sync.Mutex
+	record   bool
+	callback func(CrawlEvent)
+	events   []CrawlEvent
+}
+
+func newEventRecorder(options *SpiderOptions) *eventRecorder {
+	return &eventRecorder{
+		record:   options.RecordEvents,
+		callback: options.OnEvent,
+	}
+}
+
+func (r *eventRecorder) enabled() bool {
+	return r.record || r.callback != nil
+}
+
+func (r *eventRecorder) emit(event CrawlEvent) {
+	if !r.enabled() {
+		return
+	}
+	event.Time = time.Now()
+
+	if r.callback != nil {
+		r.callback(event)
+	}
+	if r.record {
+		r.mu.Lock()
+		r.events = append(r.events, event)
+		r.mu.Unlock()
+	}
+}
+
+func (r *eventRecorder) recorded() []CrawlEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.events
+}
@@ -0,0 +1,262 @@
+package webspider
+
+import (
+	"archive/zip"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type manifestEntry struct {
+	URL         string `json:"url"`
+	Filename    string `json:"filename"`
+	Status      string `json:"status"`
+	Depth       int    `json:"depth"`
+	ContentHash string `json:"content_hash"`
+}
+
+type failedEntry struct {
+	URL   string `json:"url"`
+	Error string `json:"error"`
+}
+
+type jsonResult struct {
+	Pages     []PageResult        `json:"pages"`
+	Failed    []failedEntry       `json:"failed"`
+	LinkGraph map[string][]string `json:"linkGraph,omitempty"`
+}
+
+// WriteZip writes the crawl as a ZIP archive: one markdown file per page under
+// pages/, a manifest.json mapping URLs to those files, and failed.json
+// listing the pages that could not be fetched. Each page file starts with
+// the crawl's PageHeaderTemplate, like the page does in Content.
+func (r *SpiderResult) WriteZip(w io.Writer) error {
+	headerText := ""
+	if r.EffectiveOptions != nil {
+		headerText = r.EffectiveOptions.PageHeaderTemplate
+		if headerText == "" && r.EffectiveOptions.MarkdownFrontMatter {
+			headerText = frontMatterPageHeaderTemplate
+		}
+	}
+	headerTemplate, err := parsePageHeaderTemplate(headerText)
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+
+	manifest := make([]manifestEntry, 0, len(r.Pages))
+	for i, page := range r.Pages {
+		filename := fmt.Sprintf("pages/%04d-%s.md", i+1, pageSlug(page.URL))
+		entry, err := zw.Create(filename)
+		if err != nil {
+			return fmt.Errorf("failed to add %s to zip: %w", filename, err)
+		}
+		content, err := page.LoadContent()
+		if err != nil {
+			return err
+		}
+		page.Content = content
+
+		// The separating newlines the template starts with belong between
+		// pages in Content, not at the top of a file
+		text := strings.TrimLeft(formatPage(headerTemplate, page), "\n")
+		if _, err := fmt.Fprintf(entry, "%s\n", text); err != nil {
+			return fmt.Errorf("failed to write %s: %w", filename, err)
+		}
+
+		manifest = append(manifest, manifestEntry{
+			URL:         page.URL,
+			Filename:    filename,
+			Status:      "crawled",
+			Depth:       page.Depth,
+			ContentHash: page.ContentHash,
+		})
+	}
+
+	if err := writeZipJSON(zw, "manifest.json", manifest); err != nil {
+		return err
+	}
+	if err := writeZipJSON(zw, "failed.json", r.failedEntries()); err != nil {
+		return err
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to finish zip: %w", err)
+	}
+	return nil
+}
+
+// failedEntries lists FailedPages sorted by URL.
+func (r *SpiderResult) failedEntries() []failedEntry {
+	failedURLs := make([]string, 0, len(r.FailedPages))
+	for failedURL := range r.FailedPages {
+		failedURLs = append(failedURLs, failedURL)
+	}
+	sort.Strings(failedURLs)
+
+	failed := make([]failedEntry, 0, len(failedURLs))
+	for _, failedURL := range failedURLs {
+		failed = append(failed, failedEntry{URL: failedURL, Error: r.FailedPages[failedURL]})
+	}
+	return failed
+}
+
+// WriteJSON writes the crawled pages and failures as one JSON document. Pages
+// are marshaled as PageResult, with content spilled by SpillToDir read back
+// in. The link graph is included under "linkGraph" when the crawl ran with
+// TrackLinkGraph.
+func (r *SpiderResult) WriteJSON(w io.Writer) error {
+	output := jsonResult{
+		Pages:     make([]PageResult, 0, len(r.Pages)),
+		Failed:    r.failedEntries(),
+		LinkGraph: r.LinkGraph,
+	}
+	for _, page := range r.Pages {
+		content, err := page.LoadContent()
+		if err != nil {
+			return err
+		}
+		page.Content = content
+		page.ContentFile = ""
+		output.Pages = append(output.Pages, page)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(output); err != nil {
+		return fmt.Errorf("failed to write JSON: %w", err)
+	}
+	return nil
+}
+
+// ReadJSON decodes a result written by WriteJSON. Only what WriteJSON
+// records is restored: the pages, their order, the failures and the link
+// graph.
+func ReadJSON(r io.Reader) (*SpiderResult, error) {
+	var input jsonResult
+	if err := json.NewDecoder(r).Decode(&input); err != nil {
+		return nil, fmt.Errorf("failed to decode result: %w", err)
+	}
+
+	result := &SpiderResult{
+		Pages:       make([]PageResult, 0, len(input.Pages)),
+		CrawledURLs: make([]string, 0, len(input.Pages)),
+		FailedPages: make(map[string]string, len(input.Failed)),
+		LinkGraph:   input.LinkGraph,
+	}
+	for i, page := range input.Pages {
+		page.crawlOrder = i + 1
+		result.Pages = append(result.Pages, page)
+		result.CrawledURLs = append(result.CrawledURLs, page.URL)
+	}
+	for _, failed := range input.Failed {
+		result.FailedPages[failed.URL] = failed.Error
+	}
+	result.SuccessfulPages = len(result.Pages)
+	result.TotalPages = len(result.Pages) + len(result.FailedPages)
+	return result, nil
+}
+
+// WriteDetectedFilesJSONL writes one JSON object per detected file, with the
+// page it was linked from and the link text.
+func (r *SpiderResult) WriteDetectedFilesJSONL(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	for _, file := range r.DetectedFiles {
+		if err := encoder.Encode(file); err != nil {
+			return fmt.Errorf("failed to write detected file %s: %w", file.URL, err)
+		}
+	}
+	return nil
+}
+
+// WriteGob encodes the result with encoding/gob, which is faster and more
+// compact than JSON for large crawls. Like with JSON, the hooks, transports
+// and other pluggable parts of EffectiveOptions are left out.
+func (r *SpiderResult) WriteGob(w io.Writer) error {
+	result := *r
+	if r.EffectiveOptions != nil {
+		options := *r.EffectiveOptions
+		options.Frontier = nil
+		options.VisitedSet = nil
+		options.Resolver = nil
+		options.PreviousResult = nil
+		options.Transport = nil
+		options.OCR = nil
+		result.EffectiveOptions = &options
+	}
+	if err := gob.NewEncoder(w).Encode(&result); err != nil {
+		return fmt.Errorf("failed to encode result: %w", err)
+	}
+	return nil
+}
+
+// ReadGob decodes a result written by WriteGob.
+func ReadGob(r io.Reader) (*SpiderResult, error) {
+	var result SpiderResult
+	if err := gob.NewDecoder(r).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode result: %w", err)
+	}
+	return &result, nil
+}
+
+// SaveGob writes the result to path with WriteGob.
+func (r *SpiderResult) SaveGob(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if err := r.WriteGob(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// LoadGob reads a result saved with SaveGob.
+func LoadGob(path string) (*SpiderResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+	return ReadGob(file)
+}
+
+func writeZipJSON(zw *zip.Writer, name string, v interface{}) error {
+	entry, err := zw.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to add %s to zip: %w", name, err)
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+var slugRegex = regexp.MustCompile(`[^a-z0-9]+`)
+
+// pageSlug turns a page URL into a short, filesystem-safe name.
+func pageSlug(pageURL string) string {
+	name := pageURL
+	if u, err := url.Parse(pageURL); err == nil {
+		name = u.Host + u.Path
+	}
+
+	slug := strings.Trim(slugRegex.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(slug) > 80 {
+		slug = strings.TrimRight(slug[:80], "-")
+	}
+	if slug == "" {
+		slug = "page"
+	}
+	return slug
+}
@@ -0,0 +1,65 @@
+package webspider
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Formats accepted by FormatResult
+const (
+	FormatMarkdown = "markdown"
+	FormatText     = "text"
+	FormatJSON     = "json"
+)
+
+var (
+	markdownLinePrefix = regexp.MustCompile(`^(?:#{1,6} |> |- )`)
+	markdownBold       = regexp.MustCompile(`\*\*(\S(?:[^*]*\S)?)\*\*`)
+	markdownItalic     = regexp.MustCompile(`\*(\S(?:[^*]*\S)?)\*`)
+)
+
+// FormatResult renders the crawl as FormatMarkdown (Content, with its
+// "# URL:" page headers), FormatText (Content without markdown syntax) or
+// FormatJSON (the document WriteJSON writes). Markdown and text are empty
+// when the crawl spilled its content with SpillToDir.
+func FormatResult(result *SpiderResult, format string) (string, error) {
+	switch format {
+	case FormatMarkdown:
+		return result.Content, nil
+	case FormatText:
+		return stripMarkdown(result.Content), nil
+	case FormatJSON:
+		var buf bytes.Buffer
+		if err := result.WriteJSON(&buf); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+	return "", fmt.Errorf("unknown output format %q", format)
+}
+
+// stripMarkdown removes the markdown the extractor produces: headings, list
+// and quote markers, code fences and backticks, emphasis and links. Lines
+// inside code blocks are kept as they are.
+func stripMarkdown(content string) string {
+	lines := strings.Split(content, "\n")
+	stripped := lines[:0]
+	inFence := false
+	for _, line := range lines {
+		if strings.HasPrefix(line, "```") {
+			inFence = !inFence
+			continue
+		}
+		if !inFence {
+			line = markdownLinePrefix.ReplaceAllString(line, "")
+			line = removeMarkdownLinks(line)
+			line = markdownBold.ReplaceAllString(line, "$1")
+			line = markdownItalic.ReplaceAllString(line, "$1")
+			line = strings.ReplaceAll(line, "`", "")
+		}
+		stripped = append(stripped, line)
+	}
+	return strings.Join(stripped, "\n")
+}
@@ -0,0 +1,126 @@
+package webspider
+
+import (
+	"sync"
+	"time"
+)
+
+// FrontierJob is a URL waiting to be crawled and the depth it was found at.
+type FrontierJob struct {
+	URL   string
+	Depth int
+	Post  *PostSeed // Set for POST seeds, which skip URL deduplication
+}
+
+// Frontier holds the URLs waiting to be crawled. SpiderWebsite pushes from
+// worker goroutines and pops from its scheduler loop, so implementations must
+// be safe for concurrent use. A shared implementation (e.g. backed by Redis)
+// lets several processes work through one site.
+type Frontier interface {
+	// Push adds a job and reports false if the frontier is full and the job
+	// was dropped.
+	Push(job FrontierJob) bool
+	// Pop waits up to timeout for a job and reports false if none arrived.
+	Pop(timeout time.Duration) (FrontierJob, bool)
+	// Len returns the number of jobs waiting. The crawl ends once it is zero
+	// and no workers are running.
+	Len() int
+}
+
+// VisitedSet records the URLs that have been claimed for crawling. It must be
+// safe for concurrent use. With a shared implementation, each URL is crawled
+// by whichever process adds it first.
+type VisitedSet interface {
+	// Add marks url as visited and reports whether it was not visited before.
+	Add(url string) bool
+	Contains(url string) bool
+}
+
+type memoryFrontier struct {
+	mu    sync.Mutex
+	jobs  []FrontierJob
+	size  int
+	ready chan struct{} // Signalled when a job is pushed
+}
+
+// NewMemoryFrontier returns the default in-process frontier, which drops jobs
+// once size are waiting. A size of 0 or less never drops jobs.
+func NewMemoryFrontier(size int) Frontier {
+	return &memoryFrontier{size: size, ready: make(chan struct{}, 1)}
+}
+
+func (f *memoryFrontier) Push(job FrontierJob) bool {
+	f.mu.Lock()
+	if f.size > 0 && len(f.jobs) >= f.size {
+		f.mu.Unlock()
+		return false
+	}
+	f.jobs = append(f.jobs, job)
+	f.mu.Unlock()
+
+	select {
+	case f.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (f *memoryFrontier) Pop(timeout time.Duration) (FrontierJob, bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		if job, ok := f.next(); ok {
+			return job, true
+		}
+		select {
+		case <-f.ready:
+		case <-timer.C:
+			// A push may have woken another caller instead
+			return f.next()
+		}
+	}
+}
+
+func (f *memoryFrontier) next() (FrontierJob, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.jobs) == 0 {
+		return FrontierJob{}, false
+	}
+	job := f.jobs[0]
+	f.jobs[0] = FrontierJob{}
+	f.jobs = f.jobs[1:]
+	return job, true
+}
+
+func (f *memoryFrontier) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.jobs)
+}
+
+type memoryVisitedSet struct {
+	mu   sync.Mutex
+	urls map[string]bool
+}
+
+// NewMemoryVisitedSet returns the default in-process visited set.
+func NewMemoryVisitedSet() VisitedSet {
+	return &memoryVisitedSet{urls: make(map[string]bool)}
+}
+
+func (s *memoryVisitedSet) Add(url string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.urls[url] {
+		return false
+	}
+	s.urls[url] = true
+	return true
+}
+
+func (s *memoryVisitedSet) Contains(url string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.urls[url]
+}
@@ -0,0 +1,165 @@
+package webspider
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultErrorRateThreshold = 0.2
+	defaultErrorRateWindow    = 10
+)
+
+// concurrencyLimiter bounds the number of in-flight fetches. When adaptive it
+// applies AIMD control: the limit is halved whenever the error rate over the
+// last window of fetches crosses the threshold, and grows by one after each
+// healthy window until it is back at the configured concurrency.
+type concurrencyLimiter struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	limit    int
+	min      int
+	max      int
+	inFlight int
+
+	adaptive  bool
+	threshold float64
+	window    int
+	samples   int
+	failures  int
+}
+
+func newConcurrencyLimiter(options *SpiderOptions) *concurrencyLimiter {
+	maxLimit := options.Concurrency
+	if maxLimit <= 0 {
+		maxLimit = 1
+	}
+	minLimit := options.MinConcurrency
+	if minLimit <= 0 || minLimit > maxLimit {
+		minLimit = 1
+	}
+	threshold := options.ErrorRateThreshold
+	if threshold <= 0 {
+		threshold = defaultErrorRateThreshold
+	}
+	window := options.ErrorRateWindow
+	if window <= 0 {
+		window = defaultErrorRateWindow
+	}
+
+	l := &concurrencyLimiter{
+		limit:     maxLimit,
+		min:       minLimit,
+		max:       maxLimit,
+		adaptive:  options.AdaptiveConcurrency,
+		threshold: threshold,
+		window:    window,
+	}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire blocks until a worker slot is available.
+func (l *concurrencyLimiter) acquire() {
+	l.mu.Lock()
+	for l.inFlight >= l.limit {
+		l.cond.Wait()
+	}
+	l.inFlight++
+	l.mu.Unlock()
+}
+
+// release frees a worker slot and records the fetch outcome. It returns the
+// current limit and whether this call changed it.
+func (l *concurrencyLimiter) release(failed bool) (int, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	changed := false
+	if l.adaptive {
+		changed = l.record(failed)
+	}
+	l.cond.Broadcast()
+	return l.limit, changed
+}
+
+func (l *concurrencyLimiter) record(failed bool) bool {
+	l.samples++
+	if failed {
+		l.failures++
+	}
+	if l.samples < l.window {
+		return false
+	}
+
+	rate := float64(l.failures) / float64(l.samples)
+	l.samples = 0
+	l.failures = 0
+
+	previous := l.limit
+	if rate > l.threshold {
+		l.limit = max(l.min, l.limit/2)
+	} else if l.limit < l.max {
+		l.limit++
+	}
+	return l.limit != previous
+}
+
+// hostPacer spaces the requests to each host, across workers, by reserving
+// the next start time for the host before sleeping until its own.
+type hostPacer struct {
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+func newHostPacer() *hostPacer {
+	return &hostPacer{next: make(map[string]time.Time)}
+}
+
+// wait blocks until delay has passed since the previous request to host
+// started, or ctx is done. The first request to a host is not delayed.
+func (p *hostPacer) wait(ctx context.Context, host string, delay time.Duration) {
+	p.mu.Lock()
+	now := time.Now()
+	start := now
+	if next, ok := p.next[host]; ok && next.After(now) {
+		start = next
+	}
+	p.next[host] = start.Add(delay)
+	p.mu.Unlock()
+
+	sleepContext(ctx, start.Sub(now))
+}
+
+// sleepContext sleeps for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// hostDelay looks up the delay for pageURL's host in delays, first with its
+// port and then without.
+func hostDelay(delays map[string]time.Duration, pageURL string) (string, time.Duration, bool) {
+	if len(delays) == 0 {
+		return "", 0, false
+	}
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return "", 0, false
+	}
+	for _, host := range []string{strings.ToLower(u.Host), strings.ToLower(u.Hostname())} {
+		if delay, ok := delays[host]; ok {
+			return host, delay, true
+		}
+	}
+	return "", 0, false
+}
@@ -0,0 +1,345 @@
+package webspider
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// PDFSection is one crawled page handed to a PDFRenderer.
+type PDFSection struct {
+	Title   string // Falls back to the URL when the page has none
+	URL     string
+	Content string // Cleaned page text, as in PageResult.Content
+}
+
+// PDFRenderer renders the pages of a crawl into a single PDF document. Plug
+// in a full HTML-to-PDF engine by implementing it; TextPDFRenderer lays out
+// the cleaned text without any dependencies.
+type PDFRenderer interface {
+	RenderPDF(w io.Writer, title string, sections []PDFSection) error
+}
+
+// WritePDF renders the pages, in Pages order, into one PDF with renderer, or
+// TextPDFRenderer when nil.
+func (r *SpiderResult) WritePDF(w io.Writer, title string, renderer PDFRenderer) error {
+	if renderer == nil {
+		renderer = TextPDFRenderer{}
+	}
+
+	sections := make([]PDFSection, 0, len(r.Pages))
+	for _, page := range r.Pages {
+		content, err := page.LoadContent()
+		if err != nil {
+			return err
+		}
+		sectionTitle := page.Title
+		if sectionTitle == "" {
+			sectionTitle = page.URL
+		}
+		sections = append(sections, PDFSection{Title: sectionTitle, URL: page.URL, Content: content})
+	}
+	return renderer.RenderPDF(w, title, sections)
+}
+
+// TextPDFRenderer writes an A4 PDF with a table of contents followed by one
+// section per page, each starting on a new page and listed in the document
+// outline. Text is set in the standard Helvetica fonts, so characters
+// outside Windows-1252 are replaced with "?". Markdown headings in the
+// content are set in bold.
+type TextPDFRenderer struct {
+	FontSize float64 // Body text size in points; 0 means 10
+}
+
+// A4 in points
+const (
+	pdfPageWidth  = 595.0
+	pdfPageHeight = 842.0
+	pdfMargin     = 56.0
+	pdfTextWidth  = pdfPageWidth - 2*pdfMargin
+)
+
+type pdfText struct {
+	text string
+	bold bool
+	size float64
+	x, y float64
+}
+
+type pdfLayout struct {
+	pages [][]pdfText
+	y     float64
+}
+
+func (l *pdfLayout) newPage() {
+	l.pages = append(l.pages, nil)
+	l.y = pdfPageHeight - pdfMargin
+}
+
+func (l *pdfLayout) line(text string, bold bool, size, x float64) {
+	height := size * 1.4
+	if len(l.pages) == 0 || l.y-height < pdfMargin {
+		l.newPage()
+	}
+	l.y -= height
+	page := &l.pages[len(l.pages)-1]
+	*page = append(*page, pdfText{text: text, bold: bold, size: size, x: x, y: l.y})
+}
+
+func (l *pdfLayout) paragraph(text string, bold bool, size float64) {
+	for _, line := range wrapPDFText(text, size, pdfTextWidth) {
+		l.line(line, bold, size, pdfMargin)
+	}
+}
+
+func (l *pdfLayout) space(height float64) {
+	l.y -= height
+}
+
+func (t TextPDFRenderer) RenderPDF(w io.Writer, title string, sections []PDFSection) error {
+	size := t.FontSize
+	if size <= 0 {
+		size = 10
+	}
+
+	// The table of contents needs the page each section starts on, which
+	// depends on how many pages the table itself takes
+	tocPages := len(layoutPDFContents(title, sections, make([]int, len(sections)), size).pages)
+
+	body := &pdfLayout{}
+	startPages := make([]int, len(sections))
+	for i, section := range sections {
+		body.newPage()
+		startPages[i] = tocPages + len(body.pages)
+		body.paragraph(section.Title, true, size*1.6)
+		body.paragraph(section.URL, false, size*0.8)
+		body.space(size)
+		layoutPDFSection(body, section.Content, size)
+	}
+
+	toc := layoutPDFContents(title, sections, startPages, size)
+	pages := append(toc.pages, body.pages...)
+	return writePDF(w, title, sections, startPages, pages)
+}
+
+func layoutPDFContents(title string, sections []PDFSection, startPages []int, size float64) *pdfLayout {
+	toc := &pdfLayout{}
+	toc.newPage()
+	if title != "" {
+		toc.paragraph(title, true, size*2)
+		toc.space(size)
+	}
+	toc.paragraph("Contents", true, size*1.4)
+	toc.space(size / 2)
+	for i, section := range sections {
+		number := fmt.Sprint(startPages[i])
+		numberWidth := pdfTextWidthOf(number, size)
+		entry := truncatePDFText(fmt.Sprintf("%d. %s", i+1, section.Title), size, pdfTextWidth-numberWidth-size*2)
+		toc.line(entry, false, size, pdfMargin)
+		page := &toc.pages[len(toc.pages)-1]
+		*page = append(*page, pdfText{text: number, size: size, x: pdfPageWidth - pdfMargin - numberWidth, y: toc.y})
+	}
+	return toc
+}
+
+func layoutPDFSection(l *pdfLayout, content string, size float64) {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		switch {
+		case strings.TrimSpace(line) == "":
+			l.space(size / 2)
+		case strings.HasPrefix(line, "```"):
+			// Code fences only delimit; the code itself is kept as is
+		case strings.HasPrefix(line, "#"):
+			l.space(size / 2)
+			l.paragraph(strings.TrimSpace(strings.TrimLeft(line, "#")), true, size*1.2)
+		default:
+			l.paragraph(line, false, size)
+		}
+	}
+}
+
+// wrapPDFText breaks text into lines no wider than width, splitting words
+// that don't fit on a line of their own.
+func wrapPDFText(text string, size, width float64) []string {
+	var lines []string
+	var current string
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if current != "" {
+			candidate = current + " " + word
+		}
+		if pdfTextWidthOf(candidate, size) <= width {
+			current = candidate
+			continue
+		}
+		if current != "" {
+			lines = append(lines, current)
+		}
+		current = word
+		for pdfTextWidthOf(current, size) > width {
+			runes := []rune(current)
+			cut := len(runes) - 1
+			for cut > 1 && pdfTextWidthOf(string(runes[:cut]), size) > width {
+				cut--
+			}
+			lines = append(lines, string(runes[:cut]))
+			current = string(runes[cut:])
+		}
+	}
+	if current != "" {
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+func truncatePDFText(text string, size, width float64) string {
+	if pdfTextWidthOf(text, size) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && pdfTextWidthOf(string(runes)+"...", size) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return strings.TrimSpace(string(runes)) + "..."
+}
+
+// Helvetica advance widths of the printable ASCII characters, in thousandths
+// of the font size
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// pdfTextWidthOf estimates the width of text in points, padded by a tenth so
+// that bold text, which is slightly wider, still fits.
+func pdfTextWidthOf(text string, size float64) float64 {
+	total := 0
+	for _, r := range text {
+		if r >= ' ' && r <= '~' {
+			total += helveticaWidths[r-' ']
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000 * 1.1
+}
+
+// Windows-1252 codes of the characters outside Latin-1 that pages commonly use
+var winAnsiPunctuation = map[rune]byte{
+	'€': 0x80, '…': 0x85, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94,
+	'•': 0x95, '–': 0x96, '—': 0x97, '™': 0x99,
+}
+
+// pdfString encodes text as a PDF literal string in WinAnsiEncoding.
+func pdfString(text string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= ' ' && r <= '~':
+			b.WriteRune(r)
+		case r >= 0xA0 && r <= 0xFF:
+			fmt.Fprintf(&b, "\\%03o", r)
+		case winAnsiPunctuation[r] != 0:
+			fmt.Fprintf(&b, "\\%03o", winAnsiPunctuation[r])
+		case r == '\t':
+			b.WriteByte(' ')
+		default:
+			b.WriteByte('?')
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// writePDF serializes the laid out pages with a document outline entry for
+// each section.
+func writePDF(w io.Writer, title string, sections []PDFSection, startPages []int, pages [][]pdfText) error {
+	const (
+		catalogObj = 1
+		pagesObj   = 2
+		fontObj    = 3
+		boldObj    = 4
+		outlineObj = 5
+		infoObj    = 6
+		firstPage  = 7
+	)
+	pageObj := func(i int) int { return firstPage + 2*i }
+	firstItem := firstPage + 2*len(pages)
+
+	var buf bytes.Buffer
+	offsets := make([]int, firstItem-1+len(sections))
+	object := func(n int, body string) {
+		offsets[n-1] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", n, body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	object(catalogObj, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R /Outlines %d 0 R /PageMode /UseOutlines >>", pagesObj, outlineObj))
+
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", pageObj(i))
+	}
+	object(pagesObj, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	object(fontObj, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object(boldObj, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	if len(sections) == 0 {
+		object(outlineObj, "<< /Type /Outlines /Count 0 >>")
+	} else {
+		object(outlineObj, fmt.Sprintf("<< /Type /Outlines /First %d 0 R /Last %d 0 R /Count %d >>",
+			firstItem, firstItem+len(sections)-1, len(sections)))
+	}
+	object(infoObj, fmt.Sprintf("<< /Title %s /Producer (go-webspider) >>", pdfString(title)))
+
+	for i, texts := range pages {
+		var content strings.Builder
+		for _, text := range texts {
+			font := "F1"
+			if text.bold {
+				font = "F2"
+			}
+			fmt.Fprintf(&content, "BT /%s %.1f Tf %.2f %.2f Td %s Tj ET\n", font, text.size, text.x, text.y, pdfString(text.text))
+		}
+		number := fmt.Sprint(i + 1)
+		fmt.Fprintf(&content, "BT /F1 8 Tf %.2f %.2f Td %s Tj ET\n", (pdfPageWidth-pdfTextWidthOf(number, 8))/2, pdfMargin/2, pdfString(number))
+
+		object(pageObj(i), fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 %d 0 R /F2 %d 0 R >> >> /Contents %d 0 R >>",
+			pagesObj, pdfPageWidth, pdfPageHeight, fontObj, boldObj, pageObj(i)+1))
+		stream := strings.TrimSuffix(content.String(), "\n")
+		object(pageObj(i)+1, fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(stream), stream))
+	}
+
+	for i, section := range sections {
+		var links strings.Builder
+		if i > 0 {
+			fmt.Fprintf(&links, " /Prev %d 0 R", firstItem+i-1)
+		}
+		if i < len(sections)-1 {
+			fmt.Fprintf(&links, " /Next %d 0 R", firstItem+i+1)
+		}
+		object(firstItem+i, fmt.Sprintf("<< /Title %s /Parent %d 0 R%s /Dest [%d 0 R /XYZ 0 %.0f 0] >>",
+			pdfString(section.Title), outlineObj, links.String(), pageObj(startPages[i]-1), pdfPageHeight))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, catalogObj, infoObj, xref)
+
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write PDF: %w", err)
+	}
+	return nil
+}
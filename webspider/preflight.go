@@ -0,0 +1,164 @@
+package webspider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/amal5haji/go-webspider/webcrawl"
+	"go.uber.org/zap"
+)
+
+// PreflightReport is a quick assessment of whether a site can be crawled
+// from a seed URL.
+type PreflightReport struct {
+	URL        string
+	Reachable  bool
+	Error      string // Why the seed could not be fetched
+	StatusCode int
+	FinalURL   string // Seed URL after redirects
+	FinalHost  string
+	// RedirectLeavesScope is set when the seed redirects to a host the
+	// crawl scope, which is based on the seed host, does not include, so
+	// none of the links found would be followed.
+	RedirectLeavesScope bool
+	RobotsFound         bool
+	RobotsAllowed       bool // robots.txt allows the final URL, or RespectRobotsTxt is off
+	CrawlDelay          time.Duration
+	Sitemaps            []string // From robots.txt, or /sitemap.xml if it exists
+	// LikelyRequiresJS is set when the page has scripts but almost no text,
+	// as single-page apps do before they render.
+	LikelyRequiresJS bool
+	// Problems lists the findings that would make a crawl fail or come back
+	// empty. A report without problems is a go.
+	Problems []string
+}
+
+// OK reports whether the preflight found no problems.
+func (r *PreflightReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Pages with less visible text than this and at least one script are
+// assumed to render their content with JavaScript
+const preflightMinTextLength = 200
+
+const preflightMaxBodySize = 10 * 1024 * 1024
+
+// Preflight fetches the seed URL, its robots.txt and its sitemap to tell
+// before a long crawl whether the site is reachable and crawlable. It uses
+// the client settings, UserAgent and robots.txt handling of options
+// (DefaultSpiderOptions when nil), so its answer matches what a crawl with
+// them would do. It only returns an error for an invalid URL; fetch failures
+// are reported.
+func Preflight(targetURL string, options *SpiderOptions) (*PreflightReport, error) {
+	return PreflightContext(context.Background(), targetURL, options)
+}
+
+// PreflightContext is Preflight with a context that can cancel its requests.
+func PreflightContext(ctx context.Context, targetURL string, options *SpiderOptions) (*PreflightReport, error) {
+	parsedURL, err := url.Parse(targetURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse target URL: %w", err)
+	}
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return nil, fmt.Errorf("target URL %s is not HTTP or HTTPS", targetURL)
+	}
+	if options == nil {
+		options = DefaultSpiderOptions()
+	}
+
+	transport, closeTransport := crawlTransport(options)
+	defer closeTransport()
+	crawlOptions := &webcrawl.CrawlOptions{
+		Timeout:           options.Timeout,
+		UserAgent:         options.UserAgent,
+		FollowRedirects:   true,
+		MaxRedirects:      options.MaxRedirects,
+		MaxBodySize:       preflightMaxBodySize,
+		TruncateBody:      true,
+		Headers:           options.Headers,
+		Cookies:           options.Cookies,
+		CaptureErrorPages: true,
+		Transport:         transport,
+	}
+
+	report := &PreflightReport{URL: targetURL, RobotsAllowed: true}
+
+	page, err := webcrawl.FetchPageContext(ctx, targetURL, crawlOptions)
+	var statusErr *webcrawl.StatusError
+	if errors.As(err, &statusErr) {
+		// A status CaptureErrorPages doesn't keep, such as 204
+		page, err = &webcrawl.FetchedPage{URL: targetURL, FinalURL: targetURL, StatusCode: statusErr.StatusCode}, nil
+	}
+	if err != nil {
+		report.Error = err.Error()
+		report.Problems = append(report.Problems, fmt.Sprintf("seed URL is unreachable: %v", err))
+		return report, nil
+	}
+	report.Reachable = true
+	report.StatusCode = page.StatusCode
+	finalURL, err := url.Parse(page.FinalURL)
+	if err != nil {
+		finalURL = parsedURL
+	}
+	report.FinalURL = finalURL.String()
+	report.FinalHost = finalURL.Host
+
+	if page.StatusCode != http.StatusOK {
+		report.Problems = append(report.Problems, fmt.Sprintf("seed URL returned status %d", page.StatusCode))
+	}
+	if !shouldCrawlURL(finalURL, parsedURL, true) {
+		report.RedirectLeavesScope = true
+		report.Problems = append(report.Problems, fmt.Sprintf("seed redirects to %s, outside the crawl scope; use it as the seed instead", finalURL.Host))
+	}
+	if page.StatusCode == http.StatusOK && likelyRequiresJS(string(page.Body)) {
+		report.LikelyRequiresJS = true
+		report.Problems = append(report.Problems, "page has scripts but almost no text; content is probably rendered with JavaScript")
+	}
+
+	// The same cache the crawl uses, so a robots.txt that fails is judged by
+	// RobotsFetchFailurePolicy
+	rules := newRobotsCache(ctx, options, transport, zap.NewNop()).rules(finalURL)
+	report.RobotsFound = rules.found
+	report.CrawlDelay = rules.crawlDelay
+	report.Sitemaps = rules.sitemaps
+	if options.RespectRobotsTxt {
+		report.RobotsAllowed = rules.allowed(finalURL.RequestURI())
+		switch {
+		case rules == disallowAll:
+			report.Problems = append(report.Problems, "robots.txt is unavailable, so the crawl would skip the site")
+		case !report.RobotsAllowed:
+			report.Problems = append(report.Problems, "robots.txt disallows the seed URL")
+		}
+	}
+
+	if len(report.Sitemaps) == 0 {
+		sitemapURL := (&url.URL{Scheme: finalURL.Scheme, Host: finalURL.Host}).JoinPath("sitemap.xml").String()
+		sitemapOptions := *crawlOptions
+		sitemapOptions.CaptureErrorPages = false
+		if _, err := webcrawl.FetchPageContext(ctx, sitemapURL, &sitemapOptions); err == nil {
+			report.Sitemaps = []string{sitemapURL}
+		}
+	}
+
+	return report, nil
+}
+
+func likelyRequiresJS(body string) bool {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(body))
+	if err != nil {
+		return false
+	}
+	if doc.Find("script").Length() == 0 {
+		return false
+	}
+	doc.Find("script, style, noscript, template").Remove()
+	text := strings.Join(strings.Fields(doc.Find("body").Text()), " ")
+	return len(text) < preflightMinTextLength
+}
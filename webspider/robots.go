@@ -0,0 +1,287 @@
+package webspider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amal5haji/go-webspider/webcrawl"
+	"go.uber.org/zap"
+)
+
+// Google stops reading robots.txt files after 500 KiB; the rest is ignored
+const robotsMaxBodySize = 500 * 1024
+
+// robotsRules are the robots.txt rules that apply to one user agent.
+type robotsRules struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+	sitemaps   []string
+	found      bool // Read from a robots.txt rather than assumed
+}
+
+type robotsRule struct {
+	allow   bool
+	length  int // Pattern length; the longest matching rule wins
+	pattern *regexp.Regexp
+}
+
+type robotsGroup struct {
+	agents     []string
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// parseRobots reads a robots.txt file and keeps the rules of the groups
+// naming userAgent, or of the "*" groups when none does. Sitemap lines apply
+// to every agent.
+func parseRobots(r io.Reader, userAgent string) *robotsRules {
+	var groups []*robotsGroup
+	var current *robotsGroup
+	inAgents := false
+	result := &robotsRules{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// Consecutive User-agent lines share one group
+			if !inAgents {
+				current = &robotsGroup{}
+				groups = append(groups, current)
+				inAgents = true
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+		case "allow", "disallow":
+			inAgents = false
+			if current == nil || value == "" {
+				continue
+			}
+			current.rules = append(current.rules, robotsRule{
+				allow:   key == "allow",
+				length:  len(value),
+				pattern: robotsPattern(value),
+			})
+		case "crawl-delay":
+			inAgents = false
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && current != nil && seconds > 0 {
+				current.crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+		case "sitemap":
+			if value != "" {
+				result.sitemaps = append(result.sitemaps, value)
+			}
+		}
+	}
+
+	userAgent = strings.ToLower(userAgent)
+	matches := func(wildcard bool) []*robotsGroup {
+		var matched []*robotsGroup
+		for _, group := range groups {
+			for _, agent := range group.agents {
+				if (agent == "*") == wildcard && (wildcard || strings.Contains(userAgent, agent)) {
+					matched = append(matched, group)
+					break
+				}
+			}
+		}
+		return matched
+	}
+	matched := matches(false)
+	if len(matched) == 0 {
+		matched = matches(true)
+	}
+	for _, group := range matched {
+		result.rules = append(result.rules, group.rules...)
+		result.crawlDelay = max(result.crawlDelay, group.crawlDelay)
+	}
+	return result
+}
+
+// robotsPattern compiles a robots.txt path pattern, where "*" matches any
+// run of characters and a trailing "$" anchors the end of the path.
+func robotsPattern(value string) *regexp.Regexp {
+	anchored := strings.HasSuffix(value, "$")
+	value = strings.TrimSuffix(value, "$")
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(value), `\*`, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// allowed reports whether the path (with its query) may be crawled. The
+// longest matching rule decides, and Allow wins a tie.
+func (r *robotsRules) allowed(path string) bool {
+	if path == "" {
+		path = "/"
+	}
+	allowed := true
+	longest := -1
+	for _, rule := range r.rules {
+		if !rule.pattern.MatchString(path) {
+			continue
+		}
+		if rule.length > longest || (rule.length == longest && rule.allow) {
+			allowed = rule.allow
+			longest = rule.length
+		}
+	}
+	return allowed
+}
+
+// How long a robots.txt that failed with a server error or didn't answer
+// disallows its host before it is fetched again
+const robotsRetryInterval = time.Minute
+
+// disallowAll are the rules kept while a host's robots.txt is unavailable
+var disallowAll = &robotsRules{rules: []robotsRule{{length: 1, pattern: robotsPattern("/")}}}
+
+// robotsCache fetches and parses the robots.txt of each host, keeping it for
+// the crawl or for RobotsCacheTTL.
+type robotsCache struct {
+	ctx           context.Context
+	options       *webcrawl.CrawlOptions
+	userAgent     string
+	ttl           time.Duration
+	failurePolicy RobotsFailurePolicy
+	logger        *zap.Logger
+
+	mu    sync.Mutex
+	hosts map[string]*robotsHost
+}
+
+type robotsHost struct {
+	mu      sync.Mutex
+	rules   *robotsRules
+	expires time.Time // Zero when the rules are kept for the whole crawl
+}
+
+func newRobotsCache(ctx context.Context, options *SpiderOptions, transport http.RoundTripper, logger *zap.Logger) *robotsCache {
+	return &robotsCache{
+		ctx: ctx,
+		options: &webcrawl.CrawlOptions{
+			Timeout:         options.Timeout,
+			UserAgent:       options.UserAgent,
+			FollowRedirects: true,
+			MaxRedirects:    options.MaxRedirects,
+			MaxBodySize:     robotsMaxBodySize,
+			TruncateBody:    true,
+			Headers:         options.Headers,
+			Cookies:         options.Cookies,
+			Transport:       transport,
+		},
+		userAgent:     options.UserAgent,
+		ttl:           options.RobotsCacheTTL,
+		failurePolicy: options.RobotsFetchFailurePolicy,
+		logger:        logger,
+		hosts:         make(map[string]*robotsHost),
+	}
+}
+
+// rules returns the robots.txt rules of u's host, fetching them the first
+// time the host is seen and again once they expire. Other callers asking for
+// the same host meanwhile wait for that fetch.
+func (c *robotsCache) rules(u *url.URL) *robotsRules {
+	site := u.Scheme + "://" + strings.ToLower(u.Host)
+	c.mu.Lock()
+	host := c.hosts[site]
+	if host == nil {
+		host = &robotsHost{}
+		c.hosts[site] = host
+	}
+	c.mu.Unlock()
+
+	host.mu.Lock()
+	defer host.mu.Unlock()
+	if host.rules != nil && (host.expires.IsZero() || time.Now().Before(host.expires)) {
+		return host.rules
+	}
+	var ttl time.Duration
+	host.rules, ttl = c.fetch(site)
+	host.expires = time.Time{}
+	if ttl > 0 {
+		host.expires = time.Now().Add(ttl)
+	}
+	return host.rules
+}
+
+// fetch reads the robots.txt of site and returns its rules with how long to
+// keep them. A missing robots.txt (a 4xx response) allows everything. A
+// server error, a 429 or no answer at all disallows everything for
+// robotsRetryInterval, unless the failure policy is RobotsFailureAllow.
+func (c *robotsCache) fetch(site string) (*robotsRules, time.Duration) {
+	page, err := webcrawl.FetchPageContext(c.ctx, site+"/robots.txt", c.options)
+	if err == nil {
+		rules := parseRobots(bytes.NewReader(page.Body), c.userAgent)
+		rules.found = true
+		return rules, c.ttl
+	}
+
+	var statusErr *webcrawl.StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode < 500 && statusErr.StatusCode != http.StatusTooManyRequests {
+		c.logger.Debug("No robots.txt, allowing all URLs",
+			zap.String("site", site),
+			zap.Error(err),
+		)
+		return &robotsRules{}, c.ttl
+	}
+	if c.failurePolicy == RobotsFailureAllow {
+		c.logger.Debug("Unavailable robots.txt, allowing all URLs",
+			zap.String("site", site),
+			zap.Error(err),
+		)
+		return &robotsRules{}, c.ttl
+	}
+
+	retry := robotsRetryInterval
+	if c.ttl > 0 {
+		retry = min(retry, c.ttl)
+	}
+	c.logger.Debug("Unavailable robots.txt, disallowing all URLs",
+		zap.String("site", site),
+		zap.Duration("retry_in", retry),
+		zap.Error(err),
+	)
+	return disallowAll, retry
+}
+
+// allowed reports whether robots.txt lets pageURL be crawled. Only http and
+// https URLs have a robots.txt.
+func (c *robotsCache) allowed(pageURL string) bool {
+	u, err := url.Parse(pageURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return true
+	}
+	return c.rules(u).allowed(u.RequestURI())
+}
+
+// crawlDelay returns the Crawl-delay robots.txt sets for pageURL's host,
+// along with the host to pace.
+func (c *robotsCache) crawlDelay(pageURL string) (string, time.Duration) {
+	u, err := url.Parse(pageURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", 0
+	}
+	return strings.ToLower(u.Host), c.rules(u).crawlDelay
+}
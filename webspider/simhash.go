@@ -0,0 +1,78 @@
+package webspider
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Default Hamming distance up to which two pages are near duplicates
+const defaultNearDuplicateDistance = 3
+
+// Words per shingle; overlapping shingles keep some word order in the
+// fingerprint, so pages with the same vocabulary but different text differ
+const simhashShingleSize = 3
+
+// simhash returns a 64-bit fingerprint of content in which similar texts
+// differ in few bits. Case and punctuation are ignored.
+func simhash(content string) uint64 {
+	words := strings.FieldsFunc(strings.ToLower(content), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return 0
+	}
+
+	var weights [64]int
+	shingles := max(len(words)-simhashShingleSize+1, 1)
+	for i := 0; i < shingles; i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:min(i+simhashShingleSize, len(words))], " ")))
+		sum := h.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var fingerprint uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			fingerprint |= 1 << bit
+		}
+	}
+	return fingerprint
+}
+
+// nearDuplicates groups pages whose fingerprints are within maxDistance bits
+// of an earlier page, in crawl order. The result maps the first page of each
+// group to the pages that duplicate it; pages without duplicates are left out.
+func nearDuplicates(pages []PageResult, maxDistance int) map[string][]string {
+	ordered := make([]PageResult, len(pages))
+	copy(ordered, pages)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].crawlOrder < ordered[j].crawlOrder
+	})
+
+	var originals []PageResult
+	groups := make(map[string][]string)
+	for _, page := range ordered {
+		duplicate := false
+		for _, original := range originals {
+			if bits.OnesCount64(page.SimHash^original.SimHash) <= maxDistance {
+				groups[original.URL] = append(groups[original.URL], page.URL)
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			originals = append(originals, page)
+		}
+	}
+	return groups
+}
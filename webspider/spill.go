@@ -0,0 +1,30 @@
+package webspider
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// spillPage writes the page content to its own file in dir and returns the
+// file's path.
+func spillPage(dir string, page PageResult) (string, error) {
+	path := filepath.Join(dir, fmt.Sprintf("%06d-%s.md", page.crawlOrder, pageSlug(page.URL)))
+	if err := os.WriteFile(path, []byte(page.Content), 0o644); err != nil {
+		return "", fmt.Errorf("failed to spill page content: %w", err)
+	}
+	return path, nil
+}
+
+// LoadContent returns the page content, reading it from ContentFile when the
+// crawl spilled it to disk with SpillToDir.
+func (p PageResult) LoadContent() (string, error) {
+	if p.ContentFile == "" {
+		return p.Content, nil
+	}
+	data, err := os.ReadFile(p.ContentFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to load page content: %w", err)
+	}
+	return string(data), nil
+}
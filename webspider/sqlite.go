@@ -0,0 +1,119 @@
+package webspider
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Driver names registered by modernc.org/sqlite and github.com/mattn/go-sqlite3
+var sqliteDrivers = []string{"sqlite", "sqlite3"}
+
+var sqliteSchema = []string{
+	`DROP TABLE IF EXISTS pages`,
+	`DROP TABLE IF EXISTS links`,
+	`DROP TABLE IF EXISTS files`,
+	// A URL can appear twice, e.g. when a PostSeeds entry repeats a crawled page
+	`CREATE TABLE pages (
+		id INTEGER PRIMARY KEY,
+		url TEXT NOT NULL,
+		title TEXT,
+		content TEXT,
+		status INTEGER,
+		depth INTEGER,
+		hash TEXT,
+		fetched_at TEXT
+	)`,
+	`CREATE INDEX pages_url ON pages (url)`,
+	`CREATE TABLE links (
+		from_url TEXT NOT NULL,
+		to_url TEXT NOT NULL,
+		anchor_text TEXT,
+		rel TEXT
+	)`,
+	`CREATE INDEX links_to_url ON links (to_url)`,
+	`CREATE TABLE files (
+		url TEXT PRIMARY KEY,
+		filename TEXT,
+		content_type TEXT,
+		size INTEGER,
+		referrer TEXT
+	)`,
+}
+
+// WriteSQLite writes the crawl to the SQLite database at path, creating it if
+// needed, with tables pages, links (one row per link on each page) and files.
+// Tables of those names already in the database are replaced.
+//
+// The module does not depend on a SQLite driver: import one that registers
+// itself with database/sql, such as modernc.org/sqlite or
+// github.com/mattn/go-sqlite3.
+func (r *SpiderResult) WriteSQLite(path string) error {
+	drivers := sql.Drivers()
+	i := slices.IndexFunc(sqliteDrivers, func(name string) bool {
+		return slices.Contains(drivers, name)
+	})
+	if i < 0 {
+		return errors.New("no SQLite driver registered; import one such as modernc.org/sqlite")
+	}
+
+	db, err := sql.Open(sqliteDrivers[i], path)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range sqliteSchema {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to create tables: %w", err)
+		}
+	}
+
+	for _, page := range r.Pages {
+		content, err := page.LoadContent()
+		if err != nil {
+			return err
+		}
+		var fetchedAt any
+		if !page.FetchedAt.IsZero() {
+			fetchedAt = page.FetchedAt.UTC().Format(time.RFC3339)
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO pages (url, title, content, status, depth, hash, fetched_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			page.URL, page.Title, content, page.StatusCode, page.Depth, page.ContentHash, fetchedAt,
+		); err != nil {
+			return fmt.Errorf("failed to insert page %s: %w", page.URL, err)
+		}
+
+		for _, link := range page.Links {
+			if _, err := tx.Exec(
+				`INSERT INTO links (from_url, to_url, anchor_text, rel) VALUES (?, ?, ?, ?)`,
+				page.URL, link.Href, link.Text, link.Rel,
+			); err != nil {
+				return fmt.Errorf("failed to insert link from %s: %w", page.URL, err)
+			}
+		}
+	}
+
+	for _, file := range r.DetectedFiles {
+		if _, err := tx.Exec(
+			`INSERT INTO files (url, filename, content_type, size, referrer) VALUES (?, ?, ?, ?, ?)`,
+			file.URL, file.Filename, file.ContentType, file.Size, file.Referrer,
+		); err != nil {
+			return fmt.Errorf("failed to insert file %s: %w", file.URL, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit database: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package webspider
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+type DuplicateTitleMode string
+
+const (
+	DuplicateTitlesIgnore DuplicateTitleMode = ""
+	DuplicateTitlesReport DuplicateTitleMode = "report" // List them in DuplicateTitles
+	DuplicateTitlesSuffix DuplicateTitleMode = "suffix" // Also add each page's URL path to its Title
+)
+
+// duplicateTitles maps each title shared by more than one page to the sorted
+// URLs of those pages. Titles are compared after collapsing whitespace; pages
+// without a title are left out.
+func duplicateTitles(pages []PageResult) map[string][]string {
+	byTitle := make(map[string][]string)
+	for _, page := range pages {
+		title := strings.Join(strings.Fields(page.Title), " ")
+		if title != "" {
+			byTitle[title] = append(byTitle[title], page.URL)
+		}
+	}
+
+	duplicates := make(map[string][]string)
+	for title, urls := range byTitle {
+		if len(urls) > 1 {
+			sort.Strings(urls)
+			duplicates[title] = urls
+		}
+	}
+	return duplicates
+}
+
+// disambiguateTitles appends the URL path to the title of every page listed
+// in duplicates, e.g. "Products (/shoes/)".
+func disambiguateTitles(pages []PageResult, duplicates map[string][]string) {
+	for i, page := range pages {
+		title := strings.Join(strings.Fields(page.Title), " ")
+		if _, ok := duplicates[title]; !ok {
+			continue
+		}
+		path := page.URL
+		if u, err := url.Parse(page.URL); err == nil {
+			path = u.EscapedPath()
+			if path == "" {
+				path = "/"
+			}
+			if u.RawQuery != "" {
+				path += "?" + u.RawQuery
+			}
+		}
+		pages[i].Title = title + " (" + path + ")"
+	}
+}
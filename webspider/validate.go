@@ -0,0 +1,60 @@
+package webspider
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Validate checks that the result is internally consistent: CrawledURLs and
+// Pages list the same pages, the counters agree with them, and no page is
+// reported as both crawled and failed. A crawl returned by SpiderWebsite
+// should always pass; an error points at a bug in the crawler.
+func (r *SpiderResult) Validate() error {
+	var errs []error
+
+	if r.SuccessfulPages != len(r.Pages) {
+		errs = append(errs, fmt.Errorf("SuccessfulPages is %d but there are %d pages", r.SuccessfulPages, len(r.Pages)))
+	}
+	if len(r.CrawledURLs) != len(r.Pages) {
+		errs = append(errs, fmt.Errorf("%d crawled URLs but %d pages", len(r.CrawledURLs), len(r.Pages)))
+	}
+
+	// POST seeds may crawl the same URL more than once, with different
+	// outcomes, so compare counts
+	pageCounts := make(map[string]int, len(r.Pages))
+	for _, page := range r.Pages {
+		pageCounts[page.URL]++
+	}
+	for _, crawledURL := range r.CrawledURLs {
+		if pageCounts[crawledURL] == 0 {
+			errs = append(errs, fmt.Errorf("crawled URL %s has no page", crawledURL))
+			continue
+		}
+		pageCounts[crawledURL]--
+	}
+
+	postURLs := make(map[string]bool)
+	if r.EffectiveOptions != nil {
+		for _, seed := range r.EffectiveOptions.PostSeeds {
+			postURLs[seed.URL] = true
+		}
+	}
+	for _, crawledURL := range r.CrawledURLs {
+		if _, failed := r.FailedPages[crawledURL]; failed && !postURLs[crawledURL] {
+			errs = append(errs, fmt.Errorf("%s is both crawled and failed", crawledURL))
+		}
+	}
+
+	if fetched := r.SuccessfulPages + len(r.FailedPages); r.TotalPages < fetched {
+		errs = append(errs, fmt.Errorf("TotalPages is %d but %d pages succeeded or failed", r.TotalPages, fetched))
+	}
+	failures := 0
+	for _, count := range r.FailureSummary {
+		failures += count
+	}
+	if failures < len(r.FailedPages) {
+		errs = append(errs, fmt.Errorf("FailureSummary counts %d failures but %d pages failed", failures, len(r.FailedPages)))
+	}
+
+	return errors.Join(errs...)
+}
@@ -1,12 +1,26 @@
 package webspider
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
 	"net/url"
+	"os"
+	"path"
 	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
+	"unicode/utf8"
 
 	"github.com/amal5haji/go-webspider/webcrawl"
 
@@ -20,35 +34,454 @@ type SpiderOptions struct {
 	Timeout        time.Duration
 	Concurrency    int
 	DelayBetween   time.Duration
+	MaxBodySize    int64
+	MaxRedirects   int // Redirect hops per page before it fails; 0 means 10
+	// ProcessConcurrency bounds how many fetched pages are parsed and cleaned
+	// at once. Extraction is CPU-bound, so it runs in its own pool and a
+	// fetch slot is freed as soon as the body has downloaded. 0 means the
+	// number of CPUs.
+	ProcessConcurrency int
+	// TokenProvider supplies a bearer token for every request of the crawl,
+	// cached and refreshed when a response is 401 Unauthorized; see
+	// webcrawl.NewTokenTransport.
+	TokenProvider webcrawl.TokenProvider `json:"-"`
+	// DialTimeout and ResponseHeaderTimeout bound connecting to a host and
+	// waiting for its response headers separately from Timeout, which covers
+	// the whole request including the body. They are ignored with Transport.
+	DialTimeout           time.Duration
+	ResponseHeaderTimeout time.Duration
+	// Pages whose cleaned content has fewer characters than this are left
+	// out of Content and reported in ThinPages; their links are still followed.
+	MinContentLength int
+	// Shuffle randomizes the order in which each page's links are queued so a
+	// limited page budget samples the site instead of exhausting one section.
+	Shuffle bool
+	// AdaptiveConcurrency lowers the number of in-flight requests (down to
+	// MinConcurrency) when the error rate over ErrorRateWindow fetches exceeds
+	// ErrorRateThreshold, and ramps back up to Concurrency once healthy.
+	AdaptiveConcurrency bool
+	MinConcurrency      int
+	ErrorRateThreshold  float64
+	ErrorRateWindow     int
+	// IgnoreQueryString drops the query string from discovered links, so
+	// /search?q=a and /search?q=b are crawled once as /search. Only use it on
+	// sites where the query never selects different content (pagination via
+	// ?page=N is lost, for example). File links keep their query.
+	IgnoreQueryString bool
+	// Discovered links have "." and ".." path segments resolved and runs of
+	// slashes collapsed, so /a//b/../c is crawled once as /a/c. Set
+	// KeepDuplicateSlashes for sites where an empty segment is significant.
+	KeepDuplicateSlashes bool
+	// MaxPathSegments skips links whose path has more segments than this, so
+	// /docs/x/y (3 segments) is crawled with 3 but not /docs/x/y/z. The seed
+	// is always crawled. 0 means unlimited.
+	MaxPathSegments int
+	// IncludePatterns and ExcludePatterns are regular expressions matched
+	// against the absolute URL of each discovered link. Links matching an
+	// exclude pattern are dropped, and so are links matching none of the
+	// include patterns when there are any. The seed is always crawled.
+	IncludePatterns []string
+	ExcludePatterns []string
+	includeRegexps  []*regexp.Regexp
+	excludeRegexps  []*regexp.Regexp
+	// DelayJitter randomizes each delay to DelayBetween ± a random amount up
+	// to DelayJitter, so requests don't follow a fixed cadence.
+	DelayJitter  time.Duration
+	KeepNoscript bool
+	// HostDelays overrides DelayBetween for the listed hosts ("example.com",
+	// or "example.com:8080" for one port). Requests to such a host are
+	// spaced at least its delay apart across all workers, so a fragile host
+	// can be crawled gently while others go faster.
+	HostDelays map[string]time.Duration
+	// RecordEvents collects the per-URL crawl timeline into SpiderResult.Events.
+	// OnEvent, if set, receives each event as it happens; it is called from
+	// worker goroutines and must be safe for concurrent use.
+	RecordEvents bool
+	OnEvent      func(CrawlEvent) `json:"-"`
+	// FollowPagination queues rel="next"/rel="prev" targets at the current
+	// depth, so paginated listings are followed past MaxDepth.
+	FollowPagination bool
+	// PreserveLinks keeps page links as markdown in the extracted content
+	// instead of reducing them to their text.
+	PreserveLinks bool
+	// PreserveFormatting keeps bold and italic text as markdown emphasis.
+	PreserveFormatting bool
+	// ChangedSince skips the content of pages not modified since then. Pages
+	// below the seed are requested with If-Modified-Since, so a 304 costs no
+	// body download but also yields no links of its own; pages whose
+	// Last-Modified header predates it are still parsed for links. Both are
+	// reported in UnchangedPages.
+	ChangedSince time.Time
+	// PreviousResult is the crawl ChangedSince compares against. A page that
+	// returns 304 has the links it had there followed instead, taken from
+	// its Pages entry or, for pages it did not store, its LinkGraph; set
+	// TrackLinkGraph so unchanged pages pass their links on to the next crawl.
+	PreviousResult *SpiderResult `json:"-"`
+	// ClassifyLinks tags each page's links with the region they were found
+	// in, telling content links from those that only appear in navigation,
+	// headers and footers. With DeprioritizeNavLinks, each page queues its
+	// content links first.
+	ClassifyLinks        bool
+	DeprioritizeNavLinks bool
+	// DetectMixedContent reports the http:// subresources of each https://
+	// page in MixedContent.
+	DetectMixedContent bool
+	// CaptureErrorPages stores 4xx and 5xx pages with their content and
+	// follows their links instead of reporting them in FailedPages. Their
+	// PageResult.StatusCode tells them apart.
+	CaptureErrorPages bool
+	// DetectNearDuplicates fingerprints each page's content with simhash and
+	// reports pages that differ only trivially, e.g. in a timestamp, in
+	// NearDuplicates. Fingerprints within NearDuplicateDistance bits of each
+	// other (0 means 3) count as duplicates.
+	DetectNearDuplicates  bool
+	NearDuplicateDistance int
+	// TrackLinkGraph records the in-scope links of every crawled page in
+	// LinkGraph, including pages at MaxDepth whose links are not followed.
+	TrackLinkGraph bool
+	// BoilerplatePhrases are removed from page content wherever they appear
+	// as whole words, ignoring case, e.g. "Sign up for our newsletter".
+	BoilerplatePhrases []string
+	// LinkAttributes lists the attributes links are followed from, e.g. add
+	// "data-href" for sites that navigate with JavaScript. nil means "href".
+	LinkAttributes []string
+	// FollowOnclick also follows the targets of simple onclick navigation,
+	// such as location='/page' or window.open('/page'). It is a heuristic,
+	// so it is off by default.
+	FollowOnclick bool
+	// FlagLowConfidenceExtraction also runs readability on every page and
+	// lists pages whose content it agrees with on less than
+	// MinExtractionAgreement of the words (0 means 0.3) in
+	// LowConfidenceExtraction, for manual review.
+	FlagLowConfidenceExtraction bool
+	MinExtractionAgreement      float64
+	// FollowIframes also follows in-scope iframe sources, e.g. embedded
+	// documents served from the same site.
+	FollowIframes bool
+	// OCRImages adds the text OCR recognizes in content images that have no
+	// alt text; OCR is called from worker goroutines. The images are fetched
+	// like pages, within robots.txt, Concurrency, the delays and MaxBytes.
+	OCRImages bool
+	OCR       webcrawl.OCR `json:"-"`
+	// HTTPSOnly skips http:// links, reporting them in SkippedInsecure. With
+	// UpgradeInsecureLinks they are rewritten to https:// and crawled instead;
+	// upgraded URLs that fail to load end up in FailedPages.
+	HTTPSOnly            bool
+	UpgradeInsecureLinks bool
+	// IdleTimeout is how long the crawl waits for a new job before checking
+	// whether all workers are done. A longer value lets slow pages still queue
+	// links; it only delays the end of a finished crawl and does not bound the
+	// total crawl time.
+	IdleTimeout time.Duration
+	// InspectFiles sends a HEAD request for each detected file link once the
+	// crawl finishes, filling in size, type and filename in DetectedFiles.
+	InspectFiles bool
+	// PageOrder sorts Pages, CrawledURLs and Content before returning. The
+	// default keeps completion order, which varies between runs.
+	PageOrder PageOrder
+	// DuplicateTitles reports pages sharing a title, such as templated
+	// pages that all use the site name, in SpiderResult.DuplicateTitles.
+	// DuplicateTitlesSuffix also appends each such page's URL path to its
+	// Title so the titles can be told apart.
+	DuplicateTitles DuplicateTitleMode
+	// ClassifyURL decides whether an in-scope link is crawled, recorded as a
+	// file, or ignored. When nil, DefaultClassifyURL is used.
+	ClassifyURL func(u *url.URL) URLClass `json:"-"`
+	// FileQueryPatterns are query parameters that mark a URL as a file
+	// download, e.g. {"format", "pdf"}; nil means DefaultFileQueryPatterns.
+	// They apply when ClassifyURL is nil.
+	FileQueryPatterns []FileQueryPattern
+	// PreferAMP crawls a page's rel="amphtml" variant instead of the page
+	// itself; PreferCanonical does the same for rel="canonical". If both are
+	// set, PreferAMP wins. Replaced pages are reported in Alternates.
+	PreferAMP       bool
+	PreferCanonical bool
+	// MaxTotalChars caps the size of Content in characters. The first page
+	// that would overflow it sets ContentLimitReached and no further pages are
+	// stored; with StopAtMaxTotalChars the crawl also stops scheduling pages.
+	MaxTotalChars       int
+	StopAtMaxTotalChars bool
+	// MaxBytes stops the crawl once the response bodies downloaded so far
+	// add up to more than this many bytes, setting ByteLimitReached. Pages
+	// already being fetched are still finished. 0 means unlimited.
+	MaxBytes int64
+	// MaxQueueSize caps the number of links waiting to be crawled; 0 means
+	// unlimited, so every in-scope link is crawled until MaxPages is reached.
+	// Links found while the queue is full are dropped and counted in
+	// DroppedLinks, and a queue smaller than MaxPages can drop links before
+	// that budget is spent.
+	MaxQueueSize int
+	// Frontier and VisitedSet replace the in-memory queue and visited set,
+	// e.g. with Redis-backed ones shared by several crawling processes.
+	// MaxQueueSize only applies to the default frontier.
+	Frontier   Frontier   `json:"-"`
+	VisitedSet VisitedSet `json:"-"`
+	// CanonicalizeForDedup decides which URLs are the same page: links whose
+	// keys match are crawled once. It gets each link after the normalization
+	// the other options apply (IgnoreQueryString, HashRouting, dot segments
+	// and duplicate slashes), and the key is also what VisitedSet stores.
+	// nil means DefaultCanonicalizeForDedup. Wrap it to also, say, treat
+	// http and https as equal or ignore a session parameter.
+	CanonicalizeForDedup func(u *url.URL) string `json:"-"`
+	// Resolver is used for DNS lookups (default net.DefaultResolver), and
+	// HostIPOverride pins hostnames to IPs, e.g. to crawl a staging server
+	// under the production hostname. Lookups are cached for the whole crawl.
+	Resolver       *net.Resolver `json:"-"`
+	HostIPOverride map[string]string
+	// Transport sends every request of the crawl. When set, Resolver and
+	// HostIPOverride are ignored; build it with webcrawl.NewTransport to
+	// combine them with custom middleware.
+	Transport http.RoundTripper `json:"-"`
+	// ExcludeURLs are treated as already visited, so they are neither fetched
+	// nor counted towards MaxPages. LoadURLList reads them from a file.
+	ExcludeURLs []string
+	// HashRouting keeps route fragments ("#/path" or "#!/path") so each
+	// route of a hash-routed single-page app is crawled as its own URL. Plain
+	// anchors like "#section" are still stripped before deduplication. The
+	// spider doesn't run JavaScript, so routes only differ in content if the
+	// server renders them.
+	HashRouting bool
+	// ContentScorer rates each page; pages scoring below MinScore are left
+	// out of the output and listed in LowScorePages. It runs on worker
+	// goroutines and must be safe for concurrent use.
+	ContentScorer func(PageResult) float64 `json:"-"`
+	MinScore      float64
+	// PageHeaderTemplate is a text/template written before each page's
+	// content in Content, executed with the PageResult. It defaults to
+	// DefaultPageHeaderTemplate; use "\n\n" to separate pages without a header.
+	PageHeaderTemplate string
+	// MarkdownFrontMatter starts each page with YAML front matter (title,
+	// url, date, depth, word count) instead of the default header, in
+	// Content and in the files written by WriteZip, for static site
+	// generators such as Hugo or Jekyll.
+	MarkdownFrontMatter bool
+	// SpillToDir writes each page's content to a file in this directory as
+	// soon as it is crawled, instead of keeping it in memory. Pages then have
+	// an empty Content and a ContentFile to read with LoadContent, and
+	// SpiderResult.Content stays empty. The files are left for the caller.
+	SpillToDir string
+	// MaxHosts limits how many distinct hosts (including the seed's) the crawl
+	// queues pages from; links to further hosts end up in SkippedHosts.
+	MaxHosts int
+	// BuildAnchorIndex collects every link's anchor text and target into
+	// AnchorIndex, for auditing internal linking.
+	BuildAnchorIndex bool
+	// RecordExternalLinks aggregates the links that leave the crawl scope
+	// into ExternalLinks, with how often each was linked.
+	RecordExternalLinks bool
+	// PostSeeds are fetched with a POST in addition to the seed URL, and
+	// their pages are processed like any other. Several seeds may share a
+	// URL with different bodies; they are not deduplicated.
+	PostSeeds []PostSeed
+	// RespectRobotsTxt fetches the robots.txt of each host and skips the
+	// links it disallows for UserAgent, listing them in SkippedByRobots.
+	// A Crawl-delay longer than DelayBetween spaces that host's requests
+	// instead. DefaultSpiderOptions enables it.
+	RespectRobotsTxt bool
+	// RobotsCacheTTL refetches a host's robots.txt once it is this old, so
+	// long crawls pick up changes; zero keeps it for the whole crawl.
+	RobotsCacheTTL time.Duration
+	// RobotsFetchFailurePolicy decides what a robots.txt that fails with a
+	// server error or a 429, or that can't be fetched at all, means. A 4xx
+	// response always allows every URL.
+	RobotsFetchFailurePolicy RobotsFailurePolicy
+	// UserAgent is sent with every request and picks the robots.txt group
+	// that applies; when empty, only the "*" group does.
+	UserAgent string
+	// MaxRetries retries pages that fail with a network error, a timeout or
+	// a 429 or 5xx response before reporting them in FailedPages; see
+	// webcrawl.CrawlOptions.MaxRetries.
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// Headers and Cookies are sent with every request of the crawl, including
+	// robots.txt and file requests; see webcrawl.CrawlOptions.Headers.
+	Headers map[string]string
+	Cookies []*http.Cookie
 }
 
+// ExternalLink is a link target outside the crawl scope, with the first
+// non-empty anchor text it was seen with and the number of links to it.
+type ExternalLink struct {
+	webcrawl.LinkData
+	Count int `json:"count"`
+}
+
+type PostSeed struct {
+	URL         string
+	Body        []byte
+	ContentType string // e.g. "application/x-www-form-urlencoded"
+}
+
+const DefaultPageHeaderTemplate = "\n\n# URL: {{.URL}}\n\n"
+
+// Header used instead of DefaultPageHeaderTemplate with MarkdownFrontMatter
+const frontMatterPageHeaderTemplate = "\n\n{{.FrontMatter}}\n"
+
+type URLClass int
+
+const (
+	Crawlable URLClass = iota
+	File
+	Skip
+)
+
+// FileQueryPattern matches URLs whose query has Param set to Value (compared
+// case-insensitively), or set at all when Value is empty.
+type FileQueryPattern struct {
+	Param string
+	Value string
+}
+
+var DefaultFileQueryPatterns = []FileQueryPattern{{Param: "download", Value: "1"}}
+
+// ItemFileQueryPatterns are the item=form and item=statute rules that used to
+// apply to every site. Append them to FileQueryPatterns to keep treating
+// those pages as files.
+var ItemFileQueryPatterns = []FileQueryPattern{{Param: "item", Value: "form"}, {Param: "item", Value: "statute"}}
+
+type RobotsFailurePolicy string
+
+const (
+	RobotsFailureDisallow RobotsFailurePolicy = ""      // Skip the host's URLs, fetching robots.txt again after a minute
+	RobotsFailureAllow    RobotsFailurePolicy = "allow" // Crawl the host as if it had no robots.txt
+)
+
+type PageOrder string
+
+const (
+	PageOrderCompletion      PageOrder = ""           // Order in which pages finished
+	PageOrderCrawl           PageOrder = "crawl"      // Order in which pages were dequeued
+	PageOrderDepth           PageOrder = "depth"      // Shallowest first, then by URL
+	PageOrderDepthDescending PageOrder = "depth_desc" // Deepest first, then by URL
+)
+
 type SpiderResult struct {
 	Content          string
+	Pages            []PageResult
 	CrawledURLs      []string
 	DetectedFileUrls []string
+	DetectedFiles    []webcrawl.FileInfo
+	ThinPages        []string
+	LowScorePages    []string
+	WalledPages      []string            // Pages that look login-gated or paywalled
+	UnchangedPages   []string            // Pages not modified since ChangedSince
+	SkippedInsecure  []string            // http:// links not followed because of HTTPSOnly
+	SkippedHosts     []string            // Links not followed because of MaxHosts
+	SkippedByRobots  []string            // Links not followed because robots.txt disallows them
+	PaginationLoops  []string            // Pages whose rel="next" pointed back into their own pagination chain
+	AnchorIndex      map[string][]string // Anchor text -> sorted link targets, with BuildAnchorIndex
+	ExternalLinks    []ExternalLink      // Sorted by URL, with RecordExternalLinks
+	MixedContent     map[string][]string // Page URL -> insecure subresources, with DetectMixedContent
+	Alternates       map[string]string   // Page URL -> AMP or canonical variant crawled instead
+	LinkGraph        map[string][]string // Page URL -> sorted in-scope links on it, with TrackLinkGraph
+	NearDuplicates   map[string][]string // Page URL -> later pages nearly identical to it, with DetectNearDuplicates
+	DuplicateTitles  map[string][]string // Title -> sorted URLs of the pages sharing it, with SpiderOptions.DuplicateTitles
 	TotalPages       int
 	SuccessfulPages  int
 	FailedPages      map[string]string
+	FailureSummary   map[string]int // Failure counts keyed by error category
+	HostStats        map[string]HostStat
+	Events           []CrawlEvent
 	ProcessingTime   time.Duration
+	BytesDownloaded  int64 // Size of all response bodies fetched
+	// EffectiveOptions is a copy of the options the crawl ran with, after
+	// defaults were applied. Hooks and transports are not serialized.
+	EffectiveOptions *SpiderOptions
+
+	// Set when MaxTotalChars was hit; later pages were fetched but not stored
+	ContentLimitReached bool
+	// Set when MaxBytes was exceeded and the crawl stopped early
+	ByteLimitReached bool
+	// Pages whose readability and manual extraction disagreed, with
+	// FlagLowConfidenceExtraction
+	LowConfidenceExtraction []string
+	// Links not queued because the queue was at MaxQueueSize
+	DroppedLinks int
+}
+
+// PageResult is one crawled page. It marshals to JSON directly, so Pages can
+// be handed to other tools without parsing Content.
+type PageResult struct {
+	URL         string              `json:"url"`
+	Title       string              `json:"title,omitempty"` // See webcrawl.Metadata.Title
+	Depth       int                 `json:"depth"`
+	StatusCode  int                 `json:"status_code"` // Not 200 only for error pages kept with CaptureErrorPages
+	Content     string              `json:"content"`
+	ContentFile string              `json:"content_file,omitempty"` // Where Content was written with SpillToDir
+	ContentHash string              `json:"content_hash"`           // Hex SHA-256 of Content
+	SimHash     uint64              `json:"simhash,omitempty"`      // Content fingerprint, with DetectNearDuplicates
+	Headings    []webcrawl.Heading  `json:"headings,omitempty"`
+	Score       float64             `json:"score,omitempty"` // Set by SpiderOptions.ContentScorer
+	PublishedAt time.Time           `json:"published_at,omitzero"`
+	FetchedAt   time.Time           `json:"fetched_at,omitzero"`
+	Links       []webcrawl.LinkData `json:"links,omitempty"` // Internal and external links on the page
+
+	crawlOrder int
+}
+
+// FrontMatter renders the page's title, URL, published date, depth and word
+// count as a YAML front matter block, including the "---" delimiters.
+func (p PageResult) FrontMatter() string {
+	var b strings.Builder
+	b.WriteString("---\n")
+	if p.Title != "" {
+		fmt.Fprintf(&b, "title: %s\n", strconv.Quote(p.Title))
+	}
+	fmt.Fprintf(&b, "url: %s\n", strconv.Quote(p.URL))
+	if !p.PublishedAt.IsZero() {
+		fmt.Fprintf(&b, "date: %s\n", p.PublishedAt.Format(time.RFC3339))
+	}
+	fmt.Fprintf(&b, "depth: %d\n", p.Depth)
+	fmt.Fprintf(&b, "word_count: %d\n", len(strings.Fields(p.Content)))
+	b.WriteString("---\n")
+	return b.String()
 }
 
-type urlJob struct {
-	url   string
-	depth int
+// TableOfContents renders the page's heading outline as a nested markdown list.
+func (p PageResult) TableOfContents() string {
+	return webcrawl.TableOfContents(p.Headings)
 }
 
+type HostStat struct {
+	Pages          int
+	Failures       int
+	Bytes          int64
+	AverageLatency time.Duration
+	totalLatency   time.Duration
+}
+
+// Error categories used as keys of SpiderResult.FailureSummary.
+const (
+	FailureTimeout          = "timeout"
+	FailureNonOK            = "non_ok_status"
+	FailureBodyTooLarge     = "body_too_large"
+	FailureRobotsDisallowed = "robots_disallowed"
+	FailureParse            = "parse"
+	FailureTooManyRedirects = "too_many_redirects"
+	FailureOther            = "other"
+)
+
 func DefaultSpiderOptions() *SpiderOptions {
 	return &SpiderOptions{
-		MaxPages:       100,
-		MaxDepth:       3,
-		CrawlSubDomain: true,
-		Timeout:        30 * time.Second,
-		Concurrency:    5,
-		DelayBetween:   1 * time.Second,
+		MaxPages:         100,
+		MaxDepth:         3,
+		CrawlSubDomain:   true,
+		Timeout:          30 * time.Second,
+		Concurrency:      5,
+		DelayBetween:     1 * time.Second,
+		IdleTimeout:      2 * time.Second,
+		RespectRobotsTxt: true,
 	}
 }
 
 func SpiderWebsite(targetURL string, options *SpiderOptions) (*SpiderResult, error) {
+	return SpiderWebsiteContext(context.Background(), targetURL, options)
+}
+
+// SpiderWebsiteContext is SpiderWebsite with a context. Once ctx is done no
+// more pages are started and in-flight requests are aborted; the pages
+// crawled so far are returned along with ctx.Err().
+func SpiderWebsiteContext(ctx context.Context, targetURL string, options *SpiderOptions) (*SpiderResult, error) {
 	logger, _ := zap.NewDevelopment()
 	defer logger.Sync()
 
@@ -56,9 +489,26 @@ func SpiderWebsite(targetURL string, options *SpiderOptions) (*SpiderResult, err
 	if options == nil {
 		options = DefaultSpiderOptions()
 	}
+	// Defaults and compiled patterns go on a copy, leaving the caller's as is
+	copied := *options
+	options = &copied
 	if options.MaxPages <= 0 {
 		options.MaxPages = 1
 	}
+	if options.IdleTimeout <= 0 {
+		options.IdleTimeout = 2 * time.Second
+	}
+	if options.SpillToDir != "" {
+		if err := os.MkdirAll(options.SpillToDir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create spill directory: %w", err)
+		}
+	}
+	if options.PageHeaderTemplate == "" {
+		options.PageHeaderTemplate = DefaultPageHeaderTemplate
+		if options.MarkdownFrontMatter {
+			options.PageHeaderTemplate = frontMatterPageHeaderTemplate
+		}
+	}
 
 	startTime := time.Now()
 
@@ -67,134 +517,708 @@ func SpiderWebsite(targetURL string, options *SpiderOptions) (*SpiderResult, err
 		return nil, fmt.Errorf("failed to parse target URL: %w", err)
 	}
 
+	headerTemplate, err := parsePageHeaderTemplate(options.PageHeaderTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if options.includeRegexps, err = compilePatterns(options.IncludePatterns); err != nil {
+		return nil, fmt.Errorf("invalid include pattern: %w", err)
+	}
+	if options.excludeRegexps, err = compilePatterns(options.ExcludePatterns); err != nil {
+		return nil, fmt.Errorf("invalid exclude pattern: %w", err)
+	}
+	if options.HTTPSOnly && parsedURL.Scheme == "http" {
+		if !options.UpgradeInsecureLinks {
+			return nil, fmt.Errorf("target URL %s is not HTTPS", targetURL)
+		}
+		parsedURL.Scheme = "https"
+		targetURL = parsedURL.String()
+	}
+
 	result := &SpiderResult{
 		Content:          "",
+		Pages:            []PageResult{},
 		CrawledURLs:      []string{},
 		DetectedFileUrls: []string{},
+		ThinPages:        []string{},
+		LowScorePages:    []string{},
+		WalledPages:      []string{},
 		FailedPages:      make(map[string]string),
+		FailureSummary:   make(map[string]int),
+		HostStats:        make(map[string]HostStat),
+		Alternates:       make(map[string]string),
 	}
+	effectiveOptions := *options
+	result.EffectiveOptions = &effectiveOptions
 
-	visitedURLs := make(map[string]bool)
+	visitedURLs := options.VisitedSet
+	if visitedURLs == nil {
+		visitedURLs = NewMemoryVisitedSet()
+	}
+	canonicalize := options.CanonicalizeForDedup
+	if canonicalize == nil {
+		canonicalize = DefaultCanonicalizeForDedup
+	}
+	// dedupKey is what the visited and queued sets hold for a link
+	dedupKey := func(link string) string {
+		u, err := url.Parse(link)
+		if err != nil {
+			return link
+		}
+		return canonicalize(u)
+	}
+	for _, excluded := range options.ExcludeURLs {
+		if u, err := url.Parse(strings.TrimSpace(excluded)); err == nil {
+			visitedURLs.Add(dedupKey(normalizeURL(u, options)))
+		}
+	}
+	// URLs sitting in the frontier, so several pages linking to the same URL
+	// before it is crawled only queue it once
+	queuedURLs := map[string]bool{dedupKey(targetURL): true}
+	crawlHosts := map[string]bool{parsedURL.Host: true}
+	skippedHosts := make(map[string]bool)
+	skippedByRobots := make(map[string]bool)
+	anchorPairs := make(map[string]map[string]bool)
+	externalLinks := make(map[string]*ExternalLink)
+	contentChars := 0
+	skippedInsecure := make(map[string]bool)
+	// Pagination chain each page belongs to, and the pages whose next link
+	// looped back into theirs
+	paginationChains := make(map[string]map[string]bool)
+	paginationLoops := make(map[string]bool)
+	// Where each detected file was first linked from
+	fileSources := make(map[string]webcrawl.FileInfo)
 	var mu sync.Mutex
+	events := newEventRecorder(options)
 
-	urlJobs := make(chan urlJob, options.MaxPages*2)
-	urlJobs <- urlJob{url: targetURL, depth: 0}
+	transport, closeTransport := crawlTransport(options)
+	defer closeTransport()
+	if parsedURL.Scheme == "file" && transport == nil {
+		// Serve the whole mirror, not just the directory of each page
+		transport = webcrawl.NewFileTransport(path.Dir(parsedURL.Path))
+	}
+	var robots *robotsCache
+	if options.RespectRobotsTxt {
+		robots = newRobotsCache(ctx, options, transport, logger)
+	}
+	// robotsAllowed records link in SkippedByRobots if robots.txt disallows it
+	robotsAllowed := func(link string) bool {
+		if robots == nil || robots.allowed(link) {
+			return true
+		}
+		mu.Lock()
+		skippedByRobots[link] = true
+		mu.Unlock()
+		logger.Debug("Skipping link disallowed by robots.txt",
+			zap.String("link", link),
+		)
+		return false
+	}
+
+	frontier := options.Frontier
+	if frontier == nil {
+		frontier = NewMemoryFrontier(options.MaxQueueSize)
+	}
+	if robotsAllowed(targetURL) {
+		frontier.Push(FrontierJob{URL: targetURL, Depth: 0})
+		events.emit(CrawlEvent{Type: EventEnqueued, URL: targetURL, Depth: 0})
+	}
+	for i := range options.PostSeeds {
+		seed := &options.PostSeeds[i]
+		if !robotsAllowed(seed.URL) {
+			continue
+		}
+		if frontier.Push(FrontierJob{URL: seed.URL, Depth: 0, Post: seed}) {
+			events.emit(CrawlEvent{Type: EventEnqueued, URL: seed.URL, Depth: 0})
+		}
+	}
 
 	var wg sync.WaitGroup
-	semaphore := make(chan struct{}, options.Concurrency)
+	limiter := newConcurrencyLimiter(options)
+	pacer := newHostPacer()
+	minAgreement := options.MinExtractionAgreement
+	if minAgreement <= 0 {
+		minAgreement = defaultMinExtractionAgreement
+	}
+	processConcurrency := options.ProcessConcurrency
+	if processConcurrency <= 0 {
+		processConcurrency = runtime.NumCPU()
+	}
+	processSlots := make(chan struct{}, processConcurrency)
+
+	// pace waits before a request to targetURL for its HostDelays entry, or
+	// for DelayBetween or the robots.txt Crawl-delay
+	pace := func(targetURL string) {
+		host, delay, paced := hostDelay(options.HostDelays, targetURL)
+		if !paced {
+			delay = options.DelayBetween
+			if robots != nil {
+				// Crawl-delay is per site, not per worker
+				if siteHost, crawlDelay := robots.crawlDelay(targetURL); crawlDelay > delay {
+					host, delay, paced = siteHost, crawlDelay, true
+				}
+			}
+		}
+		if paced {
+			pacer.wait(ctx, host, jitteredDelay(delay, options.DelayJitter))
+		} else if delay := jitteredDelay(delay, options.DelayJitter); delay > 0 {
+			sleepContext(ctx, delay)
+		}
+	}
+	// fetchImage fetches the images OCRImages recognizes under the same
+	// limits as pages: robots.txt, Concurrency, the delays and MaxBytes
+	fetchImage := func(ctx context.Context, imageURL string, crawlOptions *webcrawl.CrawlOptions) (*webcrawl.FetchedPage, error) {
+		if robots != nil && !robots.allowed(imageURL) {
+			return nil, webcrawl.ErrRobotsDisallowed
+		}
+		limiter.acquire()
+		pace(imageURL)
+		mu.Lock()
+		limitReached := result.ByteLimitReached
+		mu.Unlock()
+		if limitReached {
+			limiter.release(false)
+			return nil, fmt.Errorf("byte limit of %d reached", options.MaxBytes)
+		}
+
+		image, err := webcrawl.FetchPageContext(ctx, imageURL, crawlOptions)
+		limiter.release(err != nil)
+		if image != nil {
+			mu.Lock()
+			result.BytesDownloaded += int64(len(image.Body))
+			if options.MaxBytes > 0 && result.BytesDownloaded > options.MaxBytes {
+				result.ByteLimitReached = true
+			}
+			mu.Unlock()
+		}
+		return image, err
+	}
+
+	previousLinks := previousPageLinks(options.PreviousResult, dedupKey)
+
 	activeWorkers := 0
 	var workerMu sync.Mutex
 
-	for {
-		select {
-		case job := <-urlJobs:
-			if result.TotalPages >= options.MaxPages || job.depth > options.MaxDepth {
-				continue
+	enqueue := func(link string, depth int) {
+		key := dedupKey(link)
+		mu.Lock()
+		if queuedURLs[key] || visitedURLs.Contains(key) {
+			mu.Unlock()
+			return
+		}
+		mu.Unlock()
+		if !robotsAllowed(link) {
+			return
+		}
+		mu.Lock()
+		if options.MaxHosts > 0 {
+			host := ""
+			if u, err := url.Parse(link); err == nil {
+				host = u.Host
+			}
+			if !crawlHosts[host] {
+				if len(crawlHosts) >= options.MaxHosts {
+					skippedHosts[link] = true
+					mu.Unlock()
+					return
+				}
+				crawlHosts[host] = true
 			}
+		}
+		queuedURLs[key] = true
+		mu.Unlock()
 
+		if frontier.Push(FrontierJob{URL: link, Depth: depth}) {
+			events.emit(CrawlEvent{Type: EventEnqueued, URL: link, Depth: depth})
+			logger.Debug("Added link to queue",
+				zap.String("link", link),
+				zap.Int("depth", depth),
+			)
+		} else {
 			mu.Lock()
-			if visitedURLs[job.url] {
-				mu.Unlock()
-				continue
+			delete(queuedURLs, key)
+			result.DroppedLinks++
+			mu.Unlock()
+			logger.Debug("Queue full, skipping link",
+				zap.String("link", link),
+				zap.Int("max_queue_size", options.MaxQueueSize),
+			)
+		}
+	}
+
+	// followLinks records a page's links in LinkGraph and the detected files,
+	// and queues the crawlable ones below it
+	followLinks := func(pageURL string, depth int, links *linkSet) {
+		if options.TrackLinkGraph {
+			mu.Lock()
+			if result.LinkGraph == nil {
+				result.LinkGraph = make(map[string][]string)
 			}
-			visitedURLs[job.url] = true
-			result.TotalPages++
+			result.LinkGraph[pageURL] = sortedKeys(links.crawlable)
 			mu.Unlock()
+		}
+
+		if depth < options.MaxDepth {
+			crawlableLinks := sortedKeys(links.crawlable)
+			fileLinks := sortedKeys(links.files)
+
+			logger.Debug("Extracted links",
+				zap.String("url", pageURL),
+				zap.Int("depth", depth),
+				zap.Int("crawlable_links", len(crawlableLinks)),
+				zap.Int("file_links", len(fileLinks)),
+			)
+
+			mu.Lock()
+			result.DetectedFileUrls = append(result.DetectedFileUrls, fileLinks...)
+			for _, link := range fileLinks {
+				if _, seen := fileSources[link]; !seen {
+					fileSources[link] = webcrawl.FileInfo{URL: link, Referrer: pageURL, Text: links.fileText[link]}
+				}
+			}
+			for link := range links.insecure {
+				skippedInsecure[link] = true
+			}
+			mu.Unlock()
+
+			if options.Shuffle {
+				rand.Shuffle(len(crawlableLinks), func(i, j int) {
+					crawlableLinks[i], crawlableLinks[j] = crawlableLinks[j], crawlableLinks[i]
+				})
+			}
+			if options.DeprioritizeNavLinks {
+				sort.SliceStable(crawlableLinks, func(i, j int) bool {
+					return !links.navigational[crawlableLinks[i]] && links.navigational[crawlableLinks[j]]
+				})
+			}
 
-			semaphore <- struct{}{}
-			wg.Add(1)
+			events.emit(CrawlEvent{Type: EventLinksExtracted, URL: pageURL, Depth: depth, Links: len(crawlableLinks)})
+
+			for _, link := range crawlableLinks {
+				enqueue(link, depth+1)
+			}
+		}
+	}
+
+	for {
+		if ctx.Err() != nil {
+			logger.Debug("Context done, finishing crawl", zap.Error(ctx.Err()))
+			goto done
+		}
+		job, ok := frontier.Pop(options.IdleTimeout)
+		if !ok {
 			workerMu.Lock()
-			activeWorkers++
+			currentActiveWorkers := activeWorkers
 			workerMu.Unlock()
+			if currentActiveWorkers == 0 && frontier.Len() == 0 {
+				logger.Debug("No active workers and no pending jobs, finishing crawl")
+				goto done
+			}
+			continue
+		}
+
+		if result.TotalPages >= options.MaxPages || job.Depth > options.MaxDepth {
+			continue
+		}
+
+		mu.Lock()
+		if options.StopAtMaxTotalChars && result.ContentLimitReached {
+			mu.Unlock()
+			logger.Debug("Reached maximum content size, finishing crawl",
+				zap.Int("max_total_chars", options.MaxTotalChars),
+			)
+			goto done
+		}
+		if result.ByteLimitReached {
+			mu.Unlock()
+			logger.Debug("Reached maximum bytes downloaded, finishing crawl",
+				zap.Int64("max_bytes", options.MaxBytes),
+				zap.Int64("bytes_downloaded", result.BytesDownloaded),
+			)
+			goto done
+		}
+		if job.Post == nil {
+			key := dedupKey(job.URL)
+			delete(queuedURLs, key)
+			if !visitedURLs.Add(key) {
+				mu.Unlock()
+				continue
+			}
+		}
+		result.TotalPages++
+		crawlOrder := result.TotalPages
+		mu.Unlock()
+
+		limiter.acquire()
+		wg.Add(1)
+		workerMu.Lock()
+		activeWorkers++
+		workerMu.Unlock()
+
+		go func(currentURL string, currentDepth, crawlOrder int, post *PostSeed) {
+			failed := false
+			fetching := true
+			releaseFetch := func() {
+				if !fetching {
+					return
+				}
+				fetching = false
+				if limit, changed := limiter.release(failed); changed {
+					logger.Debug("Adjusted concurrency",
+						zap.Int("limit", limit),
+					)
+				}
+			}
+			defer wg.Done()
+			defer releaseFetch()
+			defer func() {
+				workerMu.Lock()
+				activeWorkers--
+				workerMu.Unlock()
+			}()
+
+			logger.Debug("Processing URL",
+				zap.String("url", currentURL),
+				zap.Int("depth", currentDepth),
+			)
+
+			pace(currentURL)
+			if ctx.Err() != nil {
+				return
+			}
+
+			crawlOptions := &webcrawl.CrawlOptions{
+				Timeout:            options.Timeout,
+				UserAgent:          options.UserAgent,
+				FollowRedirects:    true,
+				MaxRedirects:       options.MaxRedirects,
+				MaxBodySize:        options.MaxBodySize,
+				MaxRetries:         options.MaxRetries,
+				RetryBackoff:       options.RetryBackoff,
+				Headers:            options.Headers,
+				Cookies:            options.Cookies,
+				KeepNoscript:       options.KeepNoscript,
+				PreserveLinks:      options.PreserveLinks,
+				PreserveFormatting: options.PreserveFormatting,
+				LinkAttributes:     options.LinkAttributes,
+				FollowOnclick:      options.FollowOnclick,
+				CompareExtractors:  options.FlagLowConfidenceExtraction,
+				DetectMixedContent: options.DetectMixedContent,
+				ClassifyLinks:      options.ClassifyLinks,
+				BoilerplatePhrases: options.BoilerplatePhrases,
+				CaptureErrorPages:  options.CaptureErrorPages,
+				OCRImages:          options.OCRImages,
+				OCR:                options.OCR,
+				FetchImage:         fetchImage,
+				Transport:          transport,
+			}
+			if post != nil {
+				crawlOptions.Method = http.MethodPost
+				crawlOptions.Body = post.Body
+				crawlOptions.ContentType = post.ContentType
+			}
+			if currentDepth > 0 && post == nil {
+				crawlOptions.IfModifiedSince = options.ChangedSince
+			}
 
-			go func(currentURL string, currentDepth int) {
-				defer wg.Done()
-				defer func() { <-semaphore }()
-				defer func() {
-					workerMu.Lock()
-					activeWorkers--
-					workerMu.Unlock()
-				}()
+			events.emit(CrawlEvent{Type: EventFetchStarted, URL: currentURL, Depth: currentDepth})
+			fetchStart := time.Now()
+			fetched, err := webcrawl.FetchPageContext(ctx, currentURL, crawlOptions)
+			latency := time.Since(fetchStart)
+			if fetched != nil {
+				mu.Lock()
+				result.BytesDownloaded += int64(len(fetched.Body))
+				if options.MaxBytes > 0 && result.BytesDownloaded > options.MaxBytes {
+					result.ByteLimitReached = true
+				}
+				mu.Unlock()
+			}
 
-				logger.Debug("Processing URL",
+			var crawlResult *webcrawl.CrawlResult
+			if err == nil {
+				// Free the fetch slot before the CPU-bound extraction
+				releaseFetch()
+				processSlots <- struct{}{}
+				defer func() { <-processSlots }()
+				crawlResult, err = webcrawl.ExtractPageContext(ctx, fetched, crawlOptions)
+			}
+			if errors.Is(err, webcrawl.ErrNotModified) {
+				events.emit(CrawlEvent{Type: EventFetchCompleted, URL: currentURL, Depth: currentDepth, StatusCode: http.StatusNotModified})
+
+				mu.Lock()
+				result.UnchangedPages = append(result.UnchangedPages, currentURL)
+				result.recordHostStat(currentURL, 0, latency, true)
+				mu.Unlock()
+				logger.Debug("Skipping unchanged page",
 					zap.String("url", currentURL),
-					zap.Int("depth", currentDepth),
 				)
 
-				if options.DelayBetween > 0 {
-					time.Sleep(options.DelayBetween)
+				// The page is as the last crawl saw it, so its links still lead
+				// to everything below it
+				if pageLinks, ok := previousLinks[dedupKey(currentURL)]; ok {
+					previous := &webcrawl.CrawlResult{Links: webcrawl.Links{Internal: pageLinks}}
+					followLinks(currentURL, currentDepth, extractLinks(previous, currentURL, parsedURL, options))
+				}
+				return
+			}
+			if err != nil && ctx.Err() != nil {
+				// Aborted, not broken; leave it out of FailedPages
+				logger.Debug("Crawl cancelled during fetch",
+					zap.String("url", currentURL),
+				)
+				return
+			}
+			if err != nil {
+				failed = true
+				var statusErr *webcrawl.StatusError
+				if errors.As(err, &statusErr) {
+					events.emit(CrawlEvent{Type: EventFetchCompleted, URL: currentURL, Depth: currentDepth, StatusCode: statusErr.StatusCode})
 				}
+				events.emit(CrawlEvent{Type: EventErrored, URL: currentURL, Depth: currentDepth, Error: err.Error()})
+
+				mu.Lock()
+				result.FailedPages[currentURL] = err.Error()
+				result.FailureSummary[classifyError(err)]++
+				result.recordHostStat(currentURL, 0, latency, false)
+				mu.Unlock()
+				logger.Debug("Failed to crawl URL",
+					zap.String("url", currentURL),
+					zap.Error(err),
+				)
+				return
+			}
+
+			events.emit(CrawlEvent{Type: EventFetchCompleted, URL: currentURL, Depth: currentDepth, StatusCode: crawlResult.StatusCode})
+			events.emit(CrawlEvent{Type: EventParsed, URL: currentURL, Depth: currentDepth})
+
+			mu.Lock()
+			result.recordHostStat(currentURL, crawlResult.BytesDownloaded, latency, true)
+			mu.Unlock()
 
-				crawlOptions := &webcrawl.CrawlOptions{
-					Timeout: options.Timeout,
+			// A meta refresh page is only a placeholder; follow the target
+			// at the same depth instead of storing its (empty) content.
+			if crawlResult.MetaRefreshURL != "" {
+				logger.Debug("Following meta refresh",
+					zap.String("url", currentURL),
+					zap.String("target", crawlResult.MetaRefreshURL),
+				)
+				if link, ok := resolveCrawlableLink(crawlResult.MetaRefreshURL, currentURL, parsedURL, options); ok {
+					enqueue(link, currentDepth)
 				}
+				return
+			}
+
+			if variant, ok := preferredVariant(crawlResult, currentURL, parsedURL, options); ok {
+				logger.Debug("Crawling preferred variant instead",
+					zap.String("url", currentURL),
+					zap.String("variant", variant),
+				)
+				mu.Lock()
+				result.Alternates[currentURL] = variant
+				mu.Unlock()
+				enqueue(variant, currentDepth)
+				return
+			}
+
+			// Remove markdown links and keep only the text, unless the
+			// extractor was asked to preserve them
+			cleanedContent := crawlResult.Content
+			if !options.PreserveLinks {
+				cleanedContent = removeMarkdownLinks(cleanedContent)
+			}
+			unchanged := !options.ChangedSince.IsZero() && !crawlResult.LastModified.IsZero() &&
+				crawlResult.LastModified.Before(options.ChangedSince)
+			thin := !unchanged && options.MinContentLength > 0 &&
+				utf8.RuneCountInString(strings.TrimSpace(cleanedContent)) < options.MinContentLength
+
+			page := PageResult{
+				URL:         currentURL,
+				Title:       crawlResult.Metadata.Title,
+				Depth:       currentDepth,
+				StatusCode:  crawlResult.StatusCode,
+				Content:     cleanedContent,
+				ContentHash: contentHash(cleanedContent),
+				Headings:    crawlResult.Headings,
+				PublishedAt: crawlResult.PublishedAt,
+				FetchedAt:   fetchStart,
+				Links:       slices.Concat(crawlResult.Links.Internal, crawlResult.Links.External),
+				crawlOrder:  crawlOrder,
+			}
+			if options.DetectNearDuplicates {
+				page.SimHash = simhash(cleanedContent)
+			}
+			lowScore := false
+			if !thin && !unchanged && options.ContentScorer != nil {
+				page.Score = options.ContentScorer(page)
+				lowScore = page.Score < options.MinScore
+			}
+
+			entry := formatPage(headerTemplate, page)
+			entryChars := utf8.RuneCountInString(entry)
 
-				crawlResult, err := webcrawl.CrawlWebsite(currentURL, crawlOptions)
+			if options.SpillToDir != "" && !unchanged && !thin && !lowScore {
+				contentFile, err := spillPage(options.SpillToDir, page)
 				if err != nil {
-					mu.Lock()
-					result.FailedPages[currentURL] = err.Error()
-					mu.Unlock()
-					logger.Debug("Failed to crawl URL",
+					// Keep the content in memory rather than losing the page
+					logger.Debug("Failed to spill page content",
 						zap.String("url", currentURL),
 						zap.Error(err),
 					)
-					return
+				} else {
+					page.Content = ""
+					page.ContentFile = contentFile
+					entry = ""
 				}
+			}
 
-				mu.Lock()
-				// Remove markdown links and keep only the text
-				cleanedContent := removeMarkdownLinks(crawlResult.Content)
-				result.Content += fmt.Sprintf("\n\n# URL: %s\n\n%s", currentURL, cleanedContent)
+			mu.Lock()
+			if isWalled(crawlResult, cleanedContent) {
+				result.WalledPages = append(result.WalledPages, currentURL)
+			}
+			overBudget := !unchanged && !thin && !lowScore && options.MaxTotalChars > 0 &&
+				(result.ContentLimitReached || contentChars+entryChars > options.MaxTotalChars)
+			if overBudget {
+				result.ContentLimitReached = true
+				if page.ContentFile != "" {
+					os.Remove(page.ContentFile)
+				}
+			}
+			if options.FlagLowConfidenceExtraction && crawlResult.ExtractionAgreement < minAgreement {
+				result.LowConfidenceExtraction = append(result.LowConfidenceExtraction, currentURL)
+			}
+			switch {
+			case unchanged:
+				result.UnchangedPages = append(result.UnchangedPages, currentURL)
+			case thin:
+				result.ThinPages = append(result.ThinPages, currentURL)
+			case lowScore:
+				result.LowScorePages = append(result.LowScorePages, currentURL)
+			case !overBudget:
+				result.Content += entry
+				contentChars += entryChars
+				result.Pages = append(result.Pages, page)
 
 				result.CrawledURLs = append(result.CrawledURLs, currentURL)
 				result.SuccessfulPages++
-				mu.Unlock()
+			}
+			mu.Unlock()
 
+			switch {
+			case unchanged:
+				logger.Debug("Skipping unchanged page content",
+					zap.String("url", currentURL),
+					zap.Time("last_modified", crawlResult.LastModified),
+				)
+			case thin:
+				logger.Debug("Skipping thin page content",
+					zap.String("url", currentURL),
+					zap.Int("min_content_length", options.MinContentLength),
+				)
+			case lowScore:
+				logger.Debug("Skipping low scoring page content",
+					zap.String("url", currentURL),
+					zap.Float64("score", page.Score),
+				)
+			case overBudget:
+				logger.Debug("Skipping page content over size budget",
+					zap.String("url", currentURL),
+					zap.Int("max_total_chars", options.MaxTotalChars),
+				)
+			default:
 				logger.Debug("Successfully crawled URL",
 					zap.String("url", currentURL),
 					zap.Int("depth", currentDepth),
 				)
+			}
 
-				if currentDepth < options.MaxDepth {
-					crawlableLinks, fileLinks := extractLinks(crawlResult, currentURL, parsedURL, options.CrawlSubDomain)
+			if options.BuildAnchorIndex {
+				mu.Lock()
+				for _, pageLinks := range [][]webcrawl.LinkData{crawlResult.Links.Internal, crawlResult.Links.External} {
+					for _, link := range pageLinks {
+						text := strings.Join(strings.Fields(link.Text), " ")
+						if anchorPairs[text] == nil {
+							anchorPairs[text] = make(map[string]bool)
+						}
+						anchorPairs[text][link.Href] = true
+					}
+				}
+				mu.Unlock()
+			}
 
-					logger.Debug("Extracted links",
-						zap.String("url", currentURL),
-						zap.Int("depth", currentDepth),
-						zap.Int("crawlable_links", len(crawlableLinks)),
-						zap.Int("file_links", len(fileLinks)),
-					)
+			if len(crawlResult.MixedContent) > 0 {
+				mu.Lock()
+				if result.MixedContent == nil {
+					result.MixedContent = make(map[string][]string)
+				}
+				result.MixedContent[currentURL] = crawlResult.MixedContent
+				mu.Unlock()
+			}
+
+			if options.RecordExternalLinks {
+				mu.Lock()
+				for _, link := range crawlResult.Links.External {
+					target, err := url.Parse(link.Href)
+					if err != nil || (target.Scheme != "http" && target.Scheme != "https") ||
+						shouldCrawlURL(target, parsedURL, options.CrawlSubDomain) {
+						continue
+					}
+					external, ok := externalLinks[link.Href]
+					if !ok {
+						external = &ExternalLink{LinkData: link}
+						externalLinks[link.Href] = external
+					}
+					if external.Text == "" {
+						external.Text = link.Text
+					}
+					external.Count++
+				}
+				mu.Unlock()
+			}
 
+			if options.FollowPagination {
+				for i, target := range []string{crawlResult.NextURL, crawlResult.PrevURL} {
+					if target == "" {
+						continue
+					}
+					link, ok := resolveCrawlableLink(target, currentURL, parsedURL, options)
+					if !ok {
+						continue
+					}
+
+					// Pages reached through rel="next"/"prev" share one chain, so a
+					// next link back into it is a loop rather than a new page
 					mu.Lock()
-					result.DetectedFileUrls = append(result.DetectedFileUrls, fileLinks...)
+					chain := paginationChains[currentURL]
+					if chain == nil {
+						chain = map[string]bool{currentURL: true}
+						paginationChains[currentURL] = chain
+					}
+					inChain := chain[link]
+					if !inChain {
+						chain[link] = true
+						paginationChains[link] = chain
+					} else if i == 0 {
+						paginationLoops[currentURL] = true
+					}
 					mu.Unlock()
 
-					for _, link := range crawlableLinks {
-						select {
-						case urlJobs <- urlJob{url: link, depth: currentDepth + 1}:
-							logger.Debug("Added link to queue",
-								zap.String("link", link),
-								zap.Int("depth", currentDepth+1),
-							)
-						default:
-							logger.Debug("Channel full, skipping link",
-								zap.String("link", link),
-							)
-						}
+					if inChain {
+						logger.Debug("Stopping pagination chain that loops back",
+							zap.String("url", currentURL),
+							zap.String("target", link),
+						)
+						continue
 					}
+					enqueue(link, currentDepth)
 				}
-			}(job.url, job.depth)
+			}
 
-		case <-time.After(2 * time.Second):
-			workerMu.Lock()
-			currentActiveWorkers := activeWorkers
-			workerMu.Unlock()
-			if currentActiveWorkers == 0 && len(urlJobs) == 0 {
-				logger.Debug("No active workers and no pending jobs, finishing crawl")
-				goto done
+			var links *linkSet
+			if currentDepth < options.MaxDepth || options.TrackLinkGraph {
+				links = extractLinks(crawlResult, currentURL, parsedURL, options)
 			}
-			continue
-		}
+			followLinks(currentURL, currentDepth, links)
+		}(job.URL, job.Depth, crawlOrder, job.Post)
 
 		if result.TotalPages >= options.MaxPages {
 			logger.Debug("Reached maximum pages limit",
@@ -207,56 +1231,365 @@ func SpiderWebsite(targetURL string, options *SpiderOptions) (*SpiderResult, err
 
 done:
 	wg.Wait()
+	crawlErr := ctx.Err()
 
-	mu.Lock()
-	if len(result.DetectedFileUrls) > 0 {
-		uniqueFileUrls := make(map[string]bool)
-		for _, fileUrl := range result.DetectedFileUrls {
-			uniqueFileUrls[fileUrl] = true
-		}
-
-		finalFileList := make([]string, 0, len(uniqueFileUrls))
-		for fileUrl := range uniqueFileUrls {
-			finalFileList = append(finalFileList, fileUrl)
-		}
-
+	uniqueFileUrls := make(map[string]bool)
+	for _, fileUrl := range result.DetectedFileUrls {
+		uniqueFileUrls[fileUrl] = true
+	}
+	finalFileList := sortedKeys(uniqueFileUrls)
+	if len(finalFileList) > 0 {
 		logger.Info("Detected file URLs that were not crawled",
 			zap.Strings("files", finalFileList),
 		)
 	}
-	mu.Unlock()
+	result.DetectedFiles = make([]webcrawl.FileInfo, 0, len(finalFileList))
+	for _, fileUrl := range finalFileList {
+		result.DetectedFiles = append(result.DetectedFiles, fileSources[fileUrl])
+	}
+	if crawlErr == nil {
+		inspectFiles(ctx, result.DetectedFiles, options, transport, logger)
+	}
+
+	if options.DetectNearDuplicates {
+		distance := options.NearDuplicateDistance
+		if distance <= 0 {
+			distance = defaultNearDuplicateDistance
+		}
+		result.NearDuplicates = nearDuplicates(result.Pages, distance)
+	}
+	if options.DuplicateTitles != DuplicateTitlesIgnore {
+		result.DuplicateTitles = duplicateTitles(result.Pages)
+		if options.DuplicateTitles == DuplicateTitlesSuffix {
+			disambiguateTitles(result.Pages, result.DuplicateTitles)
+		}
+	}
 
+	if options.PageOrder != PageOrderCompletion {
+		sortPages(result, options.PageOrder, headerTemplate)
+	}
+
+	result.SkippedInsecure = sortedKeys(skippedInsecure)
+	result.SkippedHosts = sortedKeys(skippedHosts)
+	result.SkippedByRobots = sortedKeys(skippedByRobots)
+	result.PaginationLoops = sortedKeys(paginationLoops)
+	if options.BuildAnchorIndex {
+		result.AnchorIndex = make(map[string][]string, len(anchorPairs))
+		for text, targets := range anchorPairs {
+			result.AnchorIndex[text] = sortedKeys(targets)
+		}
+	}
+	for _, external := range externalLinks {
+		result.ExternalLinks = append(result.ExternalLinks, *external)
+	}
+	sort.Slice(result.ExternalLinks, func(i, j int) bool {
+		return result.ExternalLinks[i].Href < result.ExternalLinks[j].Href
+	})
+	result.Events = events.recorded()
 	result.ProcessingTime = time.Since(startTime)
 
-	return result, nil
+	return result, crawlErr
+}
+
+// recordHostStat updates the per-host statistics; callers must hold the result mutex.
+func (r *SpiderResult) recordHostStat(pageURL string, bytes int64, latency time.Duration, success bool) {
+	host := pageURL
+	if u, err := url.Parse(pageURL); err == nil {
+		host = strings.ToLower(u.Host)
+	}
+
+	stat := r.HostStats[host]
+	if success {
+		stat.Pages++
+	} else {
+		stat.Failures++
+	}
+	stat.Bytes += bytes
+	stat.totalLatency += latency
+	stat.AverageLatency = stat.totalLatency / time.Duration(stat.Pages+stat.Failures)
+	r.HostStats[host] = stat
+}
+
+// crawlTransport returns the transport the crawl's requests go through, nil
+// for webcrawl's shared one, and a function releasing its idle connections.
+func crawlTransport(options *SpiderOptions) (http.RoundTripper, func()) {
+	transport := options.Transport
+	closeTransport := func() {}
+	if transport == nil && (options.Resolver != nil || len(options.HostIPOverride) > 0 ||
+		options.DialTimeout > 0 || options.ResponseHeaderTimeout > 0) {
+		customTransport := webcrawl.NewTransport(options.Resolver, options.HostIPOverride)
+		webcrawl.SetTransportTimeouts(customTransport, options.DialTimeout, options.ResponseHeaderTimeout)
+		closeTransport = customTransport.CloseIdleConnections
+		transport = customTransport
+	}
+	if options.TokenProvider != nil {
+		transport = webcrawl.NewTokenTransport(transport, options.TokenProvider)
+	}
+	return transport, closeTransport
+}
+
+func jitteredDelay(delay, jitter time.Duration) time.Duration {
+	if jitter > 0 {
+		delay += rand.N(2*jitter+1) - jitter
+	}
+	return max(delay, 0)
+}
+
+func classifyError(err error) string {
+	switch {
+	case errors.Is(err, webcrawl.ErrTimeout):
+		return FailureTimeout
+	case errors.Is(err, webcrawl.ErrNonOK):
+		return FailureNonOK
+	case errors.Is(err, webcrawl.ErrBodyTooLarge):
+		return FailureBodyTooLarge
+	case errors.Is(err, webcrawl.ErrRobotsDisallowed):
+		return FailureRobotsDisallowed
+	case errors.Is(err, webcrawl.ErrParse):
+		return FailureParse
+	case errors.Is(err, webcrawl.ErrTooManyRedirects):
+		return FailureTooManyRedirects
+	default:
+		return FailureOther
+	}
+}
+
+// A login form only suggests a wall when the page has little else to read
+const walledContentLength = 500
+
+// Pages on whose words the two extractors agree less than this are flagged
+const defaultMinExtractionAgreement = 0.3
+
+func isWalled(crawlResult *webcrawl.CrawlResult, content string) bool {
+	if crawlResult.HasPaywall {
+		return true
+	}
+	return crawlResult.HasLoginForm &&
+		utf8.RuneCountInString(strings.TrimSpace(content)) < walledContentLength
+}
+
+// preferredVariant returns the AMP or canonical URL to crawl in place of the
+// current page, if the options ask for one and it differs from the page.
+func preferredVariant(crawlResult *webcrawl.CrawlResult, currentURL string, parsedBaseURL *url.URL, options *SpiderOptions) (string, bool) {
+	var target string
+	switch {
+	case options.PreferAMP:
+		target = crawlResult.AMPURL
+	case options.PreferCanonical:
+		target = crawlResult.CanonicalURL
+	}
+	if target == "" {
+		return "", false
+	}
+
+	variant, ok := resolveCrawlableLink(target, currentURL, parsedBaseURL, options)
+	if !ok || variant == currentURL {
+		return "", false
+	}
+	if final, ok := resolveCrawlableLink(crawlResult.FinalURL, currentURL, parsedBaseURL, options); ok && variant == final {
+		return "", false
+	}
+	return variant, true
+}
+
+func parsePageHeaderTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultPageHeaderTemplate
+	}
+	tmpl, err := template.New("page header").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid page header template: %w", err)
+	}
+	// Catch references to unknown fields before the crawl starts
+	if err := tmpl.Execute(io.Discard, PageResult{}); err != nil {
+		return nil, fmt.Errorf("invalid page header template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// formatPage renders a page as it appears in SpiderResult.Content.
+func formatPage(headerTemplate *template.Template, page PageResult) string {
+	var b strings.Builder
+	// The template was checked against PageResult when parsed, so it can't fail here
+	_ = headerTemplate.Execute(&b, page)
+	b.WriteString(page.Content)
+	return b.String()
+}
+
+// sortPages reorders Pages and rebuilds CrawledURLs and Content to match.
+func sortPages(result *SpiderResult, order PageOrder, headerTemplate *template.Template) {
+	pages := result.Pages
+	sort.SliceStable(pages, func(i, j int) bool {
+		switch order {
+		case PageOrderCrawl:
+			return pages[i].crawlOrder < pages[j].crawlOrder
+		case PageOrderDepthDescending:
+			if pages[i].Depth != pages[j].Depth {
+				return pages[i].Depth > pages[j].Depth
+			}
+		default:
+			if pages[i].Depth != pages[j].Depth {
+				return pages[i].Depth < pages[j].Depth
+			}
+		}
+		return pages[i].URL < pages[j].URL
+	})
+
+	var content strings.Builder
+	result.CrawledURLs = result.CrawledURLs[:0]
+	for _, page := range pages {
+		// Spilled pages are not part of Content
+		if page.ContentFile == "" {
+			content.WriteString(formatPage(headerTemplate, page))
+		}
+		result.CrawledURLs = append(result.CrawledURLs, page.URL)
+	}
+	result.Content = content.String()
+}
+
+// inspectFiles fills in size, type and filename of each file with HEAD
+// requests, up to Concurrency in flight, when InspectFiles is set. Files that
+// can't be inspected are left as they are.
+func inspectFiles(ctx context.Context, files []webcrawl.FileInfo, options *SpiderOptions, transport http.RoundTripper, logger *zap.Logger) {
+	if !options.InspectFiles {
+		return
+	}
+
+	crawlOptions := webcrawl.DefaultCrawlOptions()
+	crawlOptions.Timeout = options.Timeout
+	crawlOptions.UserAgent = options.UserAgent
+	crawlOptions.Headers = options.Headers
+	crawlOptions.Cookies = options.Cookies
+	crawlOptions.Transport = transport
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(options.Concurrency, 1))
+	for i := range files {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(file *webcrawl.FileInfo) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			info, err := webcrawl.InspectFileContext(ctx, file.URL, crawlOptions)
+			if err != nil {
+				logger.Debug("Failed to inspect file", zap.String("url", file.URL), zap.Error(err))
+				return
+			}
+			file.Size = info.Size
+			file.ContentType = info.ContentType
+			file.Filename = info.Filename
+		}(&files[i])
+	}
+	wg.Wait()
+}
+
+// previousPageLinks indexes the links of each page in a previous crawl's
+// result by their key, falling back to its LinkGraph for pages it did not
+// store, e.g. pages that were themselves unchanged.
+func previousPageLinks(previous *SpiderResult, key func(string) string) map[string][]webcrawl.LinkData {
+	if previous == nil {
+		return nil
+	}
+	links := make(map[string][]webcrawl.LinkData)
+	for pageURL, targets := range previous.LinkGraph {
+		pageLinks := make([]webcrawl.LinkData, 0, len(targets))
+		for _, target := range targets {
+			pageLinks = append(pageLinks, webcrawl.LinkData{Href: target})
+		}
+		links[key(pageURL)] = pageLinks
+	}
+	for _, page := range previous.Pages {
+		links[key(page.URL)] = page.Links
+	}
+	return links
+}
+
+// linkSet collects the links discovered on a page, by how they are handled.
+type linkSet struct {
+	crawlable    map[string]bool
+	navigational map[string]bool // Crawlable links only found in navigation, headers or footers
+	files        map[string]bool
+	fileText     map[string]string // Anchor text of the first link to each file
+	insecure     map[string]bool   // http:// links skipped because of HTTPSOnly
+}
+
+func newLinkSet() *linkSet {
+	return &linkSet{
+		crawlable:    make(map[string]bool),
+		navigational: make(map[string]bool),
+		files:        make(map[string]bool),
+		fileText:     make(map[string]string),
+		insecure:     make(map[string]bool),
+	}
 }
 
-func extractLinks(crawlResult *webcrawl.CrawlResult, baseURL string, parsedBaseURL *url.URL, crawlSubDomain bool) (crawlableLinks []string, fileLinks []string) {
-	crawlableLinkSet := make(map[string]bool)
-	fileLinkSet := make(map[string]bool)
+func extractLinks(crawlResult *webcrawl.CrawlResult, baseURL string, parsedBaseURL *url.URL, options *SpiderOptions) *linkSet {
+	links := newLinkSet()
+	regionLinks := newLinkSet()
 
-	// Process internal links from the crawl response
-	for _, link := range crawlResult.Links.Internal {
+	// The page's external links include other subdomains; processLinkFromResponse
+	// applies the crawl scope to both lists
+	pageLinks := append(append([]webcrawl.LinkData{}, crawlResult.Links.Internal...), crawlResult.Links.External...)
+	for _, link := range pageLinks {
 		href := strings.TrimSpace(link.Href)
 		if href == "" {
 			continue
 		}
 
-		processLinkFromResponse(href, link.Text, baseURL, parsedBaseURL, crawlSubDomain, crawlableLinkSet, fileLinkSet)
+		target := links
+		if link.Source == webcrawl.LinkSourceNav || link.Source == webcrawl.LinkSourceFooter {
+			target = regionLinks
+		}
+		processLinkFromResponse(href, link.Text, baseURL, parsedBaseURL, options, target)
+	}
+	if options.FollowIframes {
+		for _, src := range crawlResult.Iframes {
+			processLinkFromResponse(src, "", baseURL, parsedBaseURL, options, links)
+		}
 	}
 
-	// Convert sets to slices
-	for link := range crawlableLinkSet {
-		crawlableLinks = append(crawlableLinks, link)
+	for link := range regionLinks.crawlable {
+		if !links.crawlable[link] {
+			links.crawlable[link] = true
+			links.navigational[link] = true
+		}
 	}
-	for link := range fileLinkSet {
-		fileLinks = append(fileLinks, link)
+	for link := range regionLinks.files {
+		if !links.files[link] {
+			links.files[link] = true
+			links.fileText[link] = regionLinks.fileText[link]
+		}
+	}
+	for link := range regionLinks.insecure {
+		links.insecure[link] = true
 	}
 
-	return crawlableLinks, fileLinks
+	return links
 }
 
-func processLinkFromResponse(href, text, baseURL string, parsedBaseURL *url.URL, crawlSubDomain bool, crawlableLinkSet, fileLinkSet map[string]bool) {
+// sortedKeys converts a set to a slice, sorted so the queue order is deterministic.
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// resolveCrawlableLink applies the same resolution and scope rules as links
+// found in page content to a single href, reporting whether it should be crawled.
+func resolveCrawlableLink(href, baseURL string, parsedBaseURL *url.URL, options *SpiderOptions) (string, bool) {
+	links := newLinkSet()
+	processLinkFromResponse(href, "", baseURL, parsedBaseURL, options, links)
+	for link := range links.crawlable {
+		return link, true
+	}
+	return "", false
+}
+
+func processLinkFromResponse(href, text, baseURL string, parsedBaseURL *url.URL, options *SpiderOptions, links *linkSet) {
 	if href == "" || strings.HasPrefix(href, "#") {
 		return
 	}
@@ -281,17 +1614,168 @@ func processLinkFromResponse(href, text, baseURL string, parsedBaseURL *url.URL,
 		resolvedURL = base.ResolveReference(resolvedURL)
 	}
 
+	if options.HTTPSOnly && resolvedURL.Scheme == "http" {
+		if !options.UpgradeInsecureLinks {
+			links.insecure[resolvedURL.String()] = true
+			return
+		}
+		resolvedURL.Scheme = "https"
+	}
+
 	// Check if we should crawl this URL
-	if shouldCrawlURL(resolvedURL, parsedBaseURL, crawlSubDomain) {
+	if !shouldCrawlURL(resolvedURL, parsedBaseURL, options.CrawlSubDomain) {
+		return
+	}
+	if matchesAny(options.excludeRegexps, resolvedURL.String()) ||
+		(len(options.includeRegexps) > 0 && !matchesAny(options.includeRegexps, resolvedURL.String())) {
+		return
+	}
+
+	var class URLClass
+	if options.ClassifyURL != nil {
+		class = options.ClassifyURL(resolvedURL)
+	} else {
+		class = classifyURL(resolvedURL, options.FileQueryPatterns)
+	}
+	switch class {
+	case File:
 		resolvedURL.Fragment = ""
-		cleanURL := resolvedURL.String()
+		fileURL := resolvedURL.String()
+		if !links.files[fileURL] {
+			links.files[fileURL] = true
+			links.fileText[fileURL] = text
+		}
+	case Crawlable:
+		if options.MaxPathSegments > 0 && pathSegments(resolvedURL.Path) > options.MaxPathSegments {
+			return
+		}
+		links.crawlable[normalizeURL(resolvedURL, options)] = true
+	}
+}
 
-		if isFileURL(resolvedURL) {
-			fileLinkSet[cleanURL] = true
-		} else {
-			crawlableLinkSet[cleanURL] = true
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathSegments counts the non-empty segments of a URL path.
+func pathSegments(p string) int {
+	segments := 0
+	for _, segment := range strings.Split(p, "/") {
+		if segment != "" {
+			segments++
+		}
+	}
+	return segments
+}
+
+// DefaultClassifyURL treats URLs that look like downloads as files and
+// everything else as crawlable. Custom ClassifyURL hooks can fall back to it.
+func DefaultClassifyURL(u *url.URL) URLClass {
+	return classifyURL(u, nil)
+}
+
+func classifyURL(u *url.URL, fileQueryPatterns []FileQueryPattern) URLClass {
+	if fileQueryPatterns == nil {
+		fileQueryPatterns = DefaultFileQueryPatterns
+	}
+	if isFileURL(u, fileQueryPatterns) {
+		return File
+	}
+	return Crawlable
+}
+
+// normalizeURL returns the form of a crawlable URL used for queueing and dedup.
+func normalizeURL(u *url.URL, options *SpiderOptions) string {
+	normalized := *u
+	if !options.HashRouting || !isRouteFragment(u.Fragment) {
+		normalized.Fragment = ""
+		normalized.RawFragment = ""
+	}
+	if options.IgnoreQueryString {
+		normalized.RawQuery = ""
+		normalized.ForceQuery = false
+	}
+	if escaped := u.EscapedPath(); escaped != "" {
+		cleaned := cleanPath(escaped, !options.KeepDuplicateSlashes)
+		if p, err := url.PathUnescape(cleaned); err == nil && cleaned != escaped {
+			normalized.Path = p
+			normalized.RawPath = cleaned
+		}
+	}
+	return normalized.String()
+}
+
+// DefaultCanonicalizeForDedup is the default CanonicalizeForDedup. It
+// lowercases the scheme and host and drops the default port, so
+// HTTP://Example.com:80/a and http://example.com/a are one page; the path and
+// query are compared as they are.
+func DefaultCanonicalizeForDedup(u *url.URL) string {
+	canonical := *u
+	canonical.Scheme = strings.ToLower(u.Scheme)
+	canonical.Host = strings.ToLower(u.Host)
+	port := u.Port()
+	if (canonical.Scheme == "http" && port == "80") || (canonical.Scheme == "https" && port == "443") {
+		canonical.Host = strings.TrimSuffix(canonical.Host, ":"+port)
+	}
+	return canonical.String()
+}
+
+// cleanPath removes "." and ".." segments from an escaped absolute path as
+// described in RFC 3986 section 5.2.4, and optionally collapses runs of
+// slashes, so /a//b/../c becomes /a/c.
+func cleanPath(p string, collapseSlashes bool) string {
+	if !strings.HasPrefix(p, "/") {
+		return p
+	}
+
+	segments := strings.Split(p[1:], "/")
+	last := segments[len(segments)-1]
+	trailingSlash := last == "" || last == "." || last == ".."
+
+	cleaned := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		switch segment {
+		case ".":
+			continue
+		case "..":
+			if len(cleaned) > 0 {
+				cleaned = cleaned[:len(cleaned)-1]
+			}
+			continue
+		case "":
+			if collapseSlashes {
+				continue
+			}
 		}
+		cleaned = append(cleaned, segment)
+	}
+
+	result := "/" + strings.Join(cleaned, "/")
+	if trailingSlash && !strings.HasSuffix(result, "/") {
+		result += "/"
 	}
+	return result
+}
+
+func isRouteFragment(fragment string) bool {
+	return strings.HasPrefix(fragment, "/") || strings.HasPrefix(fragment, "!")
 }
 
 var fileExtensions = map[string]bool{
@@ -301,34 +1785,35 @@ var fileExtensions = map[string]bool{
 	".svg": true, ".png": true, ".jpg": true, ".jpeg": true, ".gif": true,
 }
 
-func isFileURL(u *url.URL) bool {
+func isFileURL(u *url.URL, fileQueryPatterns []FileQueryPattern) bool {
 	// Check for patterns like 'download=1'
-	if u.Query().Get("download") == "1" {
-		return true
+	query := u.Query()
+	for _, pattern := range fileQueryPatterns {
+		for _, value := range query[pattern.Param] {
+			if pattern.Value == "" || strings.EqualFold(value, pattern.Value) {
+				return true
+			}
+		}
 	}
 
-	path := strings.ToLower(u.Path)
-	query := strings.ToLower(u.RawQuery)
+	lowerPath := strings.ToLower(u.Path)
 
 	// Check file extension in the path
 	for ext := range fileExtensions {
-		if strings.HasSuffix(path, ext) {
+		if strings.HasSuffix(lowerPath, ext) {
 			return true
 		}
 	}
 
 	// Add heuristics for this specific site based on log analysis
-	if strings.Contains(path, "/resource/") {
-		return true
-	}
-	if strings.Contains(query, "item=form") || strings.Contains(query, "item=statute") {
+	if strings.Contains(lowerPath, "/resource/") {
 		return true
 	}
 
 	return false
 }
 
-var sanitizeRegex = regexp.MustCompile(`^https?://[^\s")'\]}]+`)
+var sanitizeRegex = regexp.MustCompile(`^(?:https?|file)://[^\s")'\]}]+`)
 
 func sanitizeURL(rawURL string) string {
 	// Find the first valid-looking URL part and discard the rest.
@@ -337,6 +1822,12 @@ func sanitizeURL(rawURL string) string {
 }
 
 func shouldCrawlURL(targetURL, baseURL *url.URL, crawlSubDomain bool) bool {
+	// A local mirror has no hosts to scope by; follow any local file, but
+	// never leave the filesystem for the network.
+	if baseURL.Scheme == "file" || targetURL.Scheme == "file" {
+		return baseURL.Scheme == targetURL.Scheme
+	}
+
 	targetHost := strings.ToLower(targetURL.Host)
 	baseHost := strings.ToLower(baseURL.Host)
 
@@ -357,7 +1848,8 @@ func shouldCrawlURL(targetURL, baseURL *url.URL, crawlSubDomain bool) bool {
 	return false
 }
 
+var markdownLinkRegex = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+
 func removeMarkdownLinks(content string) string {
-	markdownLinkRegex := regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
 	return markdownLinkRegex.ReplaceAllString(content, "$1")
 }
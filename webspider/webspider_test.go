@@ -0,0 +1,2864 @@
+package webspider
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/amal5haji/go-webspider/webcrawl"
+	"go.uber.org/zap"
+)
+
+func fixturePage(text string, links ...string) string {
+	var b strings.Builder
+	b.WriteString("<html><body><main><p>")
+	b.WriteString(text)
+	b.WriteString("</p>")
+	for _, link := range links {
+		fmt.Fprintf(&b, `<a href="%s">%s</a>`, link, link)
+	}
+	b.WriteString("</main></body></html>")
+	return b.String()
+}
+
+// newFixtureSite serves a small site with a known link structure:
+//
+//	/            -> /a, /b, /moved, /refresh, /missing, /docs/report.pdf, external
+//	/a           -> /a/deep
+//	/a/deep      -> /a/deep/deeper
+//	/docs/report.pdf -> a small PDF with Content-Disposition
+//	/search      -> POST only, results page linking to /a
+//	/moved       -> 301 to /c
+//	/refresh     -> meta refresh to /target
+//	/list/1      -> rel="next" chain through /list/3
+//	/login, /premium -> a login form and a paywall marker (not linked)
+//	/story       -> rel="amphtml" /story/amp, which is rel="canonical" /story
+func newFixtureSite(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	pages := map[string]string{
+		"/": fixturePage("Home page",
+			"/a", "/b", "/moved", "/refresh", "/missing", "/docs/report.pdf",
+			"https://external.example/page"),
+		"/a":             fixturePage("Page A", "/a/deep"),
+		"/a/deep":        fixturePage("Deep page", "/a/deep/deeper"),
+		"/a/deep/deeper": fixturePage("Deeper page"),
+		"/b":             fixturePage("Page B", "/"),
+		"/c":             fixturePage("Redirect target"),
+		"/refresh":       `<html><head><meta http-equiv="refresh" content="0;url=/target"></head><body></body></html>`,
+		"/target":        fixturePage("Meta refresh target"),
+		"/list/1":        `<html><head><link rel="next" href="/list/2"></head><body><main><p>List page 1</p></main></body></html>`,
+		"/list/2":        `<html><body><main><p>List page 2</p><a rel="prev" href="/list/1">Prev</a><a rel="next" href="/list/3">Next</a></main></body></html>`,
+		"/list/3":        fixturePage("List page 3"),
+		"/story":         `<html><head><link rel="canonical" href="/story"><link rel="amphtml" href="/story/amp"></head><body><main><p>Full story</p></main></body></html>`,
+		"/login":         `<html><body><main><p>Sign in to continue</p><form><input type="PASSWORD" name="pw"></form></main></body></html>`,
+		"/premium":       `<html><head><meta property="og:type" content="paywall"></head><body><main><p>Premium teaser</p></main></body></html>`,
+		"/story/amp":     `<html><head><link rel="canonical" href="/story"></head><body><main><p>AMP story</p></main></body></html>`,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, page)
+	})
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "User-agent: *\nDisallow:\n")
+	})
+	mux.HandleFunc("/docs/report.pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `attachment; filename="annual-report.pdf"`)
+		fmt.Fprint(w, "%PDF-1.4 fixture")
+	})
+	mux.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		r.ParseForm()
+		fmt.Fprint(w, fixturePage("Results for "+r.PostForm.Get("q"), "/a"))
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/c", http.StatusMovedPermanently)
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func testOptions() *SpiderOptions {
+	options := DefaultSpiderOptions()
+	options.DelayBetween = 0
+	options.Timeout = 5 * time.Second
+	options.IdleTimeout = 100 * time.Millisecond
+	return options
+}
+
+func crawledPaths(t *testing.T, result *SpiderResult) []string {
+	t.Helper()
+
+	paths := make([]string, 0, len(result.CrawledURLs))
+	for _, raw := range result.CrawledURLs {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatalf("invalid crawled URL %q: %v", raw, err)
+		}
+		paths = append(paths, u.Path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestSpiderWebsiteCrawlsSite(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	result, err := SpiderWebsite(srv.URL+"/", testOptions())
+	if err != nil {
+		t.Fatalf("SpiderWebsite returned error: %v", err)
+	}
+
+	want := []string{"/", "/a", "/a/deep", "/a/deep/deeper", "/b", "/moved", "/target"}
+	if got := crawledPaths(t, result); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("crawled paths = %v, want %v", got, want)
+	}
+
+	if err := result.Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+	if len(result.DetectedFileUrls) != 1 || result.DetectedFileUrls[0] != srv.URL+"/docs/report.pdf" {
+		t.Errorf("DetectedFileUrls = %v", result.DetectedFileUrls)
+	}
+	if _, ok := result.FailedPages[srv.URL+"/missing"]; !ok || len(result.FailedPages) != 1 {
+		t.Errorf("FailedPages = %v, want only /missing", result.FailedPages)
+	}
+	if result.FailureSummary[FailureNonOK] != 1 {
+		t.Errorf("FailureSummary = %v", result.FailureSummary)
+	}
+
+	for _, text := range []string{"Home page", "Redirect target", "Meta refresh target"} {
+		if !strings.Contains(result.Content, text) {
+			t.Errorf("content is missing %q", text)
+		}
+	}
+	for _, crawled := range result.CrawledURLs {
+		if strings.Contains(crawled, "external.example") || strings.HasSuffix(crawled, "/refresh") {
+			t.Errorf("unexpected URL in crawl: %s", crawled)
+		}
+	}
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	stat := result.HostStats[host]
+	if stat.Pages != result.TotalPages-len(result.FailedPages) || stat.Failures != 1 || stat.Bytes == 0 {
+		t.Errorf("HostStats[%s] = %+v", host, stat)
+	}
+}
+
+func TestSpiderResultValidate(t *testing.T) {
+	result := &SpiderResult{
+		Pages:           []PageResult{{URL: "https://example.com/"}, {URL: "https://example.com/a"}},
+		CrawledURLs:     []string{"https://example.com/", "https://example.com/b"},
+		SuccessfulPages: 3,
+		TotalPages:      3,
+		FailedPages:     map[string]string{"https://example.com/": "timeout"},
+		FailureSummary:  map[string]int{},
+	}
+
+	err := result.Validate()
+	if err == nil {
+		t.Fatal("Validate() = nil for an inconsistent result")
+	}
+	for _, want := range []string{
+		"SuccessfulPages is 3 but there are 2 pages",
+		"crawled URL https://example.com/b has no page",
+		"https://example.com/ is both crawled and failed",
+		"TotalPages is 3 but 4 pages succeeded or failed",
+		"FailureSummary counts 0 failures but 1 pages failed",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() error is missing %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestSpiderWebsiteRespectsMaxDepth(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	options := testOptions()
+	options.MaxDepth = 1
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite returned error: %v", err)
+	}
+
+	for _, path := range crawledPaths(t, result) {
+		if strings.HasPrefix(path, "/a/deep") {
+			t.Errorf("crawled %s beyond MaxDepth", path)
+		}
+	}
+}
+
+func TestSpiderWebsiteRespectsMaxPages(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	options := testOptions()
+	options.MaxPages = 2
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite returned error: %v", err)
+	}
+
+	if result.TotalPages > 2 || len(result.CrawledURLs) > 2 {
+		t.Errorf("crawled %d pages (%v), want at most 2", result.TotalPages, result.CrawledURLs)
+	}
+}
+
+func TestSpiderWebsiteThinPages(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	options := testOptions()
+	options.MinContentLength = 10
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite returned error: %v", err)
+	}
+
+	// "Page A /a/deep" is long enough, "Page B /" is not
+	thin := strings.Join(result.ThinPages, ",")
+	if !strings.Contains(thin, srv.URL+"/b") {
+		t.Errorf("ThinPages = %v, want /b", result.ThinPages)
+	}
+	if strings.Contains(result.Content, "Page B") {
+		t.Error("thin page content should be excluded")
+	}
+}
+
+func TestSpiderWebsiteFollowsPagination(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	options := testOptions()
+	options.MaxDepth = 0
+	options.FollowPagination = true
+	result, err := SpiderWebsite(srv.URL+"/list/1", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite returned error: %v", err)
+	}
+
+	want := []string{"/list/1", "/list/2", "/list/3"}
+	if got := crawledPaths(t, result); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("crawled paths = %v, want %v", got, want)
+	}
+}
+
+func TestSpiderWebsiteFlagLowConfidenceExtraction(t *testing.T) {
+	t.Parallel()
+
+	var article strings.Builder
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&article, "<p>Paragraph %d of the long article explains the topic in considerable detail, with examples.</p>", i)
+	}
+	pages := map[string]string{
+		"/":       "<html><body><main><article>" + article.String() + `<a href="/teaser">Teaser</a></article></main></body></html>`,
+		"/teaser": `<html><body><main><p>Subscribe to read more.</p></main><div class="story">` + article.String() + "</div></body></html>",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, pages[r.URL.Path])
+	}))
+	t.Cleanup(srv.Close)
+
+	options := testOptions()
+	options.FlagLowConfidenceExtraction = true
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+	if want := []string{srv.URL + "/teaser"}; fmt.Sprint(result.LowConfidenceExtraction) != fmt.Sprint(want) {
+		t.Errorf("LowConfidenceExtraction = %v, want %v", result.LowConfidenceExtraction, want)
+	}
+}
+
+func TestSpiderWebsiteFollowIframes(t *testing.T) {
+	t.Parallel()
+
+	pages := map[string]string{
+		"/":      `<html><body><main><p>Index</p><iframe src="/embed"></iframe><iframe src="https://video.example/embed/1"></iframe></main></body></html>`,
+		"/embed": fixturePage("Embedded"),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, pages[r.URL.Path])
+	}))
+	t.Cleanup(srv.Close)
+
+	options := testOptions()
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+	if got := crawledPaths(t, result); fmt.Sprint(got) != "[/]" {
+		t.Errorf("crawled paths without FollowIframes = %v, want [/]", got)
+	}
+
+	options.FollowIframes = true
+	result, err = SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+	if got := crawledPaths(t, result); fmt.Sprint(got) != "[/ /embed]" {
+		t.Errorf("crawled paths = %v, want [/ /embed]", got)
+	}
+}
+
+func TestSpiderWebsitePaginationLoops(t *testing.T) {
+	t.Parallel()
+
+	pages := map[string]string{
+		"/":     fixturePage("Index", "/p/1", "/self"),
+		"/p/1":  `<html><head><link rel="next" href="/p/2"></head><body><main><p>Page one</p></main></body></html>`,
+		"/p/2":  `<html><head><link rel="prev" href="/p/1"><link rel="next" href="/p/3"></head><body><main><p>Page two</p></main></body></html>`,
+		"/p/3":  `<html><head><link rel="next" href="/p/1"></head><body><main><p>Page three</p></main></body></html>`,
+		"/self": `<html><head><link rel="next" href="/self#top"></head><body><main><p>Self</p></main></body></html>`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, pages[r.URL.Path])
+	}))
+	t.Cleanup(srv.Close)
+
+	options := testOptions()
+	options.MaxDepth = 1
+	options.FollowPagination = true
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	want := []string{"/", "/p/1", "/p/2", "/p/3", "/self"}
+	if got := crawledPaths(t, result); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("crawled paths = %v, want %v", got, want)
+	}
+	if want := []string{srv.URL + "/p/3", srv.URL + "/self"}; fmt.Sprint(result.PaginationLoops) != fmt.Sprint(want) {
+		t.Errorf("PaginationLoops = %v, want %v", result.PaginationLoops, want)
+	}
+}
+
+func TestSpiderWebsiteRecordsEvents(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	var callbackEvents atomic.Int64
+	options := testOptions()
+	options.MaxDepth = 0
+	options.RecordEvents = true
+	options.OnEvent = func(CrawlEvent) { callbackEvents.Add(1) }
+
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite returned error: %v", err)
+	}
+
+	var types []EventType
+	for _, event := range result.Events {
+		types = append(types, event.Type)
+	}
+	want := []EventType{EventEnqueued, EventFetchStarted, EventFetchCompleted, EventParsed}
+	if fmt.Sprint(types) != fmt.Sprint(want) {
+		t.Errorf("event types = %v, want %v", types, want)
+	}
+	if result.Events[2].StatusCode != http.StatusOK {
+		t.Errorf("fetch_completed status = %d", result.Events[2].StatusCode)
+	}
+	if int(callbackEvents.Load()) != len(result.Events) {
+		t.Errorf("callback saw %d events, recorded %d", callbackEvents.Load(), len(result.Events))
+	}
+}
+
+func TestDiffResults(t *testing.T) {
+	page := func(url, content string) PageResult {
+		return PageResult{URL: url, Content: content, ContentHash: contentHash(content)}
+	}
+	previous := &SpiderResult{
+		Pages: []PageResult{
+			page("/same", "unchanged"),
+			page("/edited", "old text"),
+			page("/gone", "removed"),
+		},
+		FailedPages: map[string]string{"/repaired": "status 500", "/still": "status 404"},
+	}
+	current := &SpiderResult{
+		Pages: []PageResult{
+			page("/same", "unchanged"),
+			page("/edited", "new text"),
+			page("/fresh", "added"),
+			page("/repaired", "back"),
+		},
+		FailedPages: map[string]string{"/still": "status 404", "/gone": "status 404"},
+	}
+
+	diff := DiffResults(previous, current)
+	got := fmt.Sprint(diff.New, diff.Changed, diff.Unchanged, diff.Removed, diff.NewlyBroken, diff.Fixed)
+	if want := "[/fresh /repaired] [/edited] [/same] [/gone] [/gone] [/repaired]"; got != want {
+		t.Errorf("DiffResults = %s, want %s", got, want)
+	}
+}
+
+func TestIsFileURL(t *testing.T) {
+	tests := map[string]bool{
+		"https://example.com/report.PDF":          true,
+		"https://example.com/archive.tar":         true,
+		"https://example.com/get?download=1":      true,
+		"https://example.com/resource/123":        true,
+		"https://example.com/view?item=form&id=1": false,
+		"https://example.com/docs/page":           false,
+		"https://example.com/pdf-guide":           false,
+	}
+
+	for raw, want := range tests {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := isFileURL(u, DefaultFileQueryPatterns); got != want {
+			t.Errorf("isFileURL(%s) = %v, want %v", raw, got, want)
+		}
+	}
+}
+
+func TestIsFileURLQueryPatterns(t *testing.T) {
+	patterns := append([]FileQueryPattern{{Param: "format", Value: "pdf"}, {Param: "export"}}, ItemFileQueryPatterns...)
+	tests := map[string]bool{
+		"https://example.com/view?item=form&id=1": true,
+		"https://example.com/view?item=formal":    false,
+		"https://example.com/report?format=PDF":   true,
+		"https://example.com/report?format=html":  false,
+		"https://example.com/report?export":       true,
+		"https://example.com/get?download=1":      false,
+	}
+
+	for raw, want := range tests {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := isFileURL(u, patterns); got != want {
+			t.Errorf("isFileURL(%s) = %v, want %v", raw, got, want)
+		}
+	}
+}
+
+func TestShouldCrawlURL(t *testing.T) {
+	base, _ := url.Parse("https://www.example.com/")
+
+	tests := []struct {
+		target         string
+		crawlSubDomain bool
+		want           bool
+	}{
+		{"https://www.example.com/page", false, true},
+		{"https://WWW.EXAMPLE.COM/page", false, true},
+		{"https://docs.example.com/page", false, false},
+		{"https://docs.example.com/page", true, true},
+		{"https://example.com/page", true, false},
+		{"https://notexample.com/page", true, false},
+		{"https://other.org/page", true, false},
+	}
+
+	for _, tt := range tests {
+		target, _ := url.Parse(tt.target)
+		if got := shouldCrawlURL(target, base, tt.crawlSubDomain); got != tt.want {
+			t.Errorf("shouldCrawlURL(%s, subdomains=%v) = %v, want %v", tt.target, tt.crawlSubDomain, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeURL(t *testing.T) {
+	tests := map[string]string{
+		"https://example.com/file?download=1)(pdf)": "https://example.com/file?download=1",
+		"  http://example.com/page  ":               "http://example.com/page",
+		"https://example.com/a\"b":                  "https://example.com/a",
+		"/relative/path":                            "",
+		"mailto:someone@example.com":                "",
+	}
+
+	for raw, want := range tests {
+		if got := sanitizeURL(raw); got != want {
+			t.Errorf("sanitizeURL(%q) = %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		raw         string
+		ignoreQuery bool
+		want        string
+	}{
+		{"https://example.com/page#section", false, "https://example.com/page"},
+		{"https://example.com/search?q=a#top", false, "https://example.com/search?q=a"},
+		{"https://example.com/search?q=a", true, "https://example.com/search"},
+		{"https://example.com/search?", true, "https://example.com/search"},
+		{"https://example.com/a//b/../c", false, "https://example.com/a/c"},
+		{"https://example.com/a/./b/..", false, "https://example.com/a/"},
+		{"https://example.com/../../x", false, "https://example.com/x"},
+		{"https://example.com/a%2Fb//c", false, "https://example.com/a%2Fb/c"},
+	}
+
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.raw)
+		options := &SpiderOptions{IgnoreQueryString: tt.ignoreQuery}
+		if got := normalizeURL(u, options); got != tt.want {
+			t.Errorf("normalizeURL(%s, ignoreQuery=%v) = %s, want %s", tt.raw, tt.ignoreQuery, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultCanonicalizeForDedup(t *testing.T) {
+	tests := map[string]string{
+		"HTTP://Example.COM:80/Path?Q=1": "http://example.com/Path?Q=1",
+		"https://example.com:443/":       "https://example.com/",
+		"https://example.com:8443/a":     "https://example.com:8443/a",
+		"http://example.com:443/a":       "http://example.com:443/a",
+	}
+	for raw, want := range tests {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := DefaultCanonicalizeForDedup(u); got != want {
+			t.Errorf("DefaultCanonicalizeForDedup(%s) = %s, want %s", raw, got, want)
+		}
+	}
+}
+
+func TestSpiderWebsiteCanonicalizeForDedup(t *testing.T) {
+	t.Parallel()
+
+	pages := map[string]string{
+		"/":  fixturePage("Index", "/a?session=1", "/a?session=2&lang=en", "/a?lang=en"),
+		"/a": fixturePage("Page A"),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, pages[r.URL.Path])
+	}))
+	t.Cleanup(srv.Close)
+
+	options := testOptions()
+	options.Concurrency = 1
+	options.CanonicalizeForDedup = func(u *url.URL) string {
+		canonical := *u
+		query := u.Query()
+		query.Del("session")
+		canonical.RawQuery = query.Encode()
+		return DefaultCanonicalizeForDedup(&canonical)
+	}
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	var crawled []string
+	for _, crawledURL := range result.CrawledURLs {
+		crawled = append(crawled, strings.TrimPrefix(crawledURL, srv.URL))
+	}
+	sort.Strings(crawled)
+	if want := "[/ /a?lang=en /a?session=1]"; fmt.Sprint(crawled) != want {
+		t.Errorf("crawled = %v, want %s", crawled, want)
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		path            string
+		collapseSlashes bool
+		want            string
+	}{
+		{"/", true, "/"},
+		{"//a///b/", true, "/a/b/"},
+		{"/a//b/", false, "/a//b/"},
+		{"/a//../b", false, "/a/b"},
+		{"/a/b/.", false, "/a/b/"},
+	}
+
+	for _, tt := range tests {
+		if got := cleanPath(tt.path, tt.collapseSlashes); got != tt.want {
+			t.Errorf("cleanPath(%q, %v) = %q, want %q", tt.path, tt.collapseSlashes, got, tt.want)
+		}
+	}
+}
+
+func TestSpiderWebsiteDeprioritizeNavLinks(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+		if r.URL.Path != "/" {
+			fmt.Fprint(w, fixturePage("Page "+r.URL.Path))
+			return
+		}
+		fmt.Fprint(w, `<html><body>
+			<nav><a href="/a-nav">Nav</a><a href="/shared">Shared</a></nav>
+			<main><p>Index with <a href="/z-content">content</a> and <a href="/shared">shared</a> links.</p></main>
+			<footer><a href="/b-footer">Footer</a><a href="/terms.pdf">Terms</a></footer>
+		</body></html>`)
+	}))
+	t.Cleanup(srv.Close)
+
+	options := testOptions()
+	options.Concurrency = 1
+	options.MaxDepth = 1
+	options.ClassifyLinks = true
+	options.DeprioritizeNavLinks = true
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := fmt.Sprint(requested); got != "[/ /shared /z-content /a-nav /b-footer]" {
+		t.Errorf("requests = %s, want content links queued before navigation and footer links", got)
+	}
+	if len(result.DetectedFiles) != 1 || result.DetectedFiles[0].Text != "Terms" {
+		t.Errorf("DetectedFiles = %+v, want the footer file link", result.DetectedFiles)
+	}
+}
+
+func TestProcessLinkFromResponseHashRouting(t *testing.T) {
+	base, _ := url.Parse("https://example.com/app/")
+
+	for _, hashRouting := range []bool{false, true} {
+		options := &SpiderOptions{HashRouting: hashRouting}
+		links := newLinkSet()
+		for _, href := range []string{"https://example.com/app/#/users", "https://example.com/app/#!/settings", "https://example.com/docs#intro"} {
+			processLinkFromResponse(href, "", base.String(), base, options, links)
+		}
+
+		want := "[https://example.com/app/ https://example.com/docs]"
+		if hashRouting {
+			want = "[https://example.com/app/#!/settings https://example.com/app/#/users https://example.com/docs]"
+		}
+		if got := fmt.Sprint(sortedKeys(links.crawlable)); got != want {
+			t.Errorf("HashRouting=%v: crawlable = %s, want %s", hashRouting, got, want)
+		}
+	}
+}
+
+func TestProcessLinkFromResponseHTTPSOnly(t *testing.T) {
+	base, _ := url.Parse("https://example.com/")
+
+	options := &SpiderOptions{HTTPSOnly: true}
+	links := newLinkSet()
+	processLinkFromResponse("http://example.com/plain", "", base.String(), base, options, links)
+	processLinkFromResponse("https://example.com/secure", "", base.String(), base, options, links)
+	if got := sortedKeys(links.crawlable); fmt.Sprint(got) != "[https://example.com/secure]" {
+		t.Errorf("crawlable = %v", got)
+	}
+	if !links.insecure["http://example.com/plain"] {
+		t.Errorf("insecure link was not recorded: %v", links.insecure)
+	}
+
+	options.UpgradeInsecureLinks = true
+	links = newLinkSet()
+	processLinkFromResponse("http://example.com/plain", "", base.String(), base, options, links)
+	if !links.crawlable["https://example.com/plain"] || len(links.insecure) != 0 {
+		t.Errorf("link was not upgraded: crawlable=%v insecure=%v", links.crawlable, links.insecure)
+	}
+}
+
+func TestSpiderWebsiteHeadersAndCookies(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var unauthorized []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("session")
+		if r.Header.Get("X-Api-Key") != "secret" || err != nil || cookie.Value != "abc123" {
+			mu.Lock()
+			unauthorized = append(unauthorized, r.URL.Path)
+			mu.Unlock()
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprint(w, "User-agent: *\nDisallow:\n")
+		case "/":
+			fmt.Fprint(w, fixturePage("Index", "/a"))
+		default:
+			fmt.Fprint(w, fixturePage("Page "+r.URL.Path))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	options := testOptions()
+	options.Headers = map[string]string{"X-Api-Key": "secret"}
+	options.Cookies = []*http.Cookie{{Name: "session", Value: "abc123"}}
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+	if got := crawledPaths(t, result); fmt.Sprint(got) != "[/ /a]" {
+		t.Errorf("crawled paths = %v, want [/ /a]", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(unauthorized) != 0 {
+		t.Errorf("requests without the header or cookie: %v", unauthorized)
+	}
+}
+
+func TestSpiderWebsiteURLPatterns(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, fixturePage("Index", "/docs/a", "/docs/archive/old", "/blog/b", "/docs/b"))
+			return
+		}
+		fmt.Fprint(w, fixturePage("Page "+r.URL.Path))
+	}))
+	t.Cleanup(srv.Close)
+
+	options := testOptions()
+	options.IncludePatterns = []string{"/docs/"}
+	options.ExcludePatterns = []string{"/archive/"}
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+	if got := crawledPaths(t, result); fmt.Sprint(got) != "[/ /docs/a /docs/b]" {
+		t.Errorf("crawled paths = %v, want the seed and /docs/ pages outside /archive/", got)
+	}
+
+	options = testOptions()
+	options.ExcludePatterns = []string{"(unclosed"}
+	if _, err := SpiderWebsite(srv.URL+"/", options); err == nil || !strings.Contains(err.Error(), "invalid exclude pattern") {
+		t.Errorf("SpiderWebsite() error = %v, want an invalid exclude pattern error", err)
+	}
+}
+
+func TestFormatResult(t *testing.T) {
+	result := &SpiderResult{
+		Content: "\n\n# URL: https://example.com/\n\n## Intro\n\nSome **bold** and *italic* text with [a link](https://example.com/a) and `code`.\n- first\n> quoted\n```\n# not a heading\n```\n",
+		Pages:   []PageResult{{URL: "https://example.com/", Depth: 0, StatusCode: http.StatusOK, Content: "Intro"}},
+	}
+
+	markdown, err := FormatResult(result, FormatMarkdown)
+	if err != nil || markdown != result.Content {
+		t.Errorf("markdown = %q, %v; want Content", markdown, err)
+	}
+
+	text, err := FormatResult(result, FormatText)
+	if err != nil {
+		t.Fatalf("FormatResult(text) error = %v", err)
+	}
+	want := "\n\nURL: https://example.com/\n\nIntro\n\nSome bold and italic text with a link and code.\nfirst\nquoted\n# not a heading\n"
+	if text != want {
+		t.Errorf("text = %q, want %q", text, want)
+	}
+
+	data, err := FormatResult(result, FormatJSON)
+	if err != nil {
+		t.Fatalf("FormatResult(json) error = %v", err)
+	}
+	loaded, err := ReadJSON(strings.NewReader(data))
+	if err != nil || len(loaded.Pages) != 1 || loaded.Pages[0].StatusCode != http.StatusOK {
+		t.Errorf("json = %s, %v", data, err)
+	}
+
+	if _, err := FormatResult(result, "yaml"); err == nil {
+		t.Error("FormatResult(yaml) should fail")
+	}
+}
+
+func TestRemoveMarkdownLinks(t *testing.T) {
+	got := removeMarkdownLinks("See [the docs](https://example.com/docs) and [this]() too.")
+	if want := "See the docs and this too."; got != want {
+		t.Errorf("removeMarkdownLinks = %q, want %q", got, want)
+	}
+}
+
+func TestJitteredDelay(t *testing.T) {
+	if got := jitteredDelay(time.Second, 0); got != time.Second {
+		t.Errorf("delay without jitter = %v, want 1s", got)
+	}
+
+	for i := 0; i < 100; i++ {
+		got := jitteredDelay(time.Second, 200*time.Millisecond)
+		if got < 800*time.Millisecond || got > 1200*time.Millisecond {
+			t.Fatalf("jittered delay %v outside 1s ± 200ms", got)
+		}
+		if got := jitteredDelay(50*time.Millisecond, time.Second); got < 0 {
+			t.Fatalf("jittered delay should never be negative, got %v", got)
+		}
+	}
+}
+
+func TestSpiderWebsiteContextCancel(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, fixturePage("Index", "/slow/1", "/slow/2"))
+			return
+		case "/robots.txt":
+			http.NotFound(w, r)
+			return
+		}
+		// Cancel the crawl while this request is in flight
+		cancel()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	start := time.Now()
+	result, err := SpiderWebsiteContext(ctx, srv.URL+"/", testOptions())
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("SpiderWebsiteContext() error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("cancelled crawl took %v", elapsed)
+	}
+	if got := crawledPaths(t, result); fmt.Sprint(got) != "[/]" {
+		t.Errorf("crawled paths = %v, want the pages finished before cancelling", got)
+	}
+	if len(result.FailedPages) != 0 {
+		t.Errorf("FailedPages = %v, want aborted requests left out", result.FailedPages)
+	}
+}
+
+func TestSpiderWebsiteHostDelays(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var requests []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		requests = append(requests, time.Now())
+		mu.Unlock()
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, fixturePage("Index", "/a", "/b", "/c"))
+			return
+		}
+		fmt.Fprint(w, fixturePage("Page "+r.URL.Path))
+	}))
+	t.Cleanup(srv.Close)
+
+	u, _ := url.Parse(srv.URL)
+	options := testOptions()
+	options.Concurrency = 4
+	options.DelayBetween = time.Hour // Overridden for the test server
+	options.HostDelays = map[string]time.Duration{u.Hostname(): 80 * time.Millisecond}
+	if _, err := SpiderWebsite(srv.URL+"/", options); err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 4 {
+		t.Fatalf("got %d requests, want 4", len(requests))
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].Before(requests[j]) })
+	for i := 1; i < len(requests); i++ {
+		if gap := requests[i].Sub(requests[i-1]); gap < 60*time.Millisecond {
+			t.Errorf("request %d came %v after the previous one, want about 80ms", i, gap)
+		}
+	}
+}
+
+func TestSpiderWebsiteRespectsRobotsTxt(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	robotsFetches := 0
+	var pageTimes []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/robots.txt":
+			robotsFetches++
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private\nCrawl-delay: 0.1\n\nUser-agent: TestBot\nDisallow: /\n")
+		case "/":
+			pageTimes = append(pageTimes, time.Now())
+			fmt.Fprint(w, fixturePage("Index", "/a", "/private/secret", "/private/other"))
+		default:
+			pageTimes = append(pageTimes, time.Now())
+			fmt.Fprint(w, fixturePage("Page "+r.URL.Path))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	options := testOptions()
+	options.Concurrency = 2
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+	if got := crawledPaths(t, result); fmt.Sprint(got) != "[/ /a]" {
+		t.Errorf("crawled paths = %v, want [/ /a]", got)
+	}
+	want := []string{srv.URL + "/private/other", srv.URL + "/private/secret"}
+	if fmt.Sprint(result.SkippedByRobots) != fmt.Sprint(want) {
+		t.Errorf("SkippedByRobots = %v, want %v", result.SkippedByRobots, want)
+	}
+	mu.Lock()
+	if robotsFetches != 1 {
+		t.Errorf("robots.txt fetched %d times, want 1", robotsFetches)
+	}
+	if len(pageTimes) == 2 {
+		if gap := pageTimes[1].Sub(pageTimes[0]); gap < 80*time.Millisecond {
+			t.Errorf("second request came %v after the first, want the 100ms Crawl-delay", gap)
+		}
+	}
+	mu.Unlock()
+
+	options = testOptions()
+	options.UserAgent = "TestBot/1.0"
+	result, err = SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+	if len(result.CrawledURLs) != 0 || fmt.Sprint(result.SkippedByRobots) != fmt.Sprint([]string{srv.URL + "/"}) {
+		t.Errorf("TestBot crawled %v and skipped %v, want only the seed skipped", result.CrawledURLs, result.SkippedByRobots)
+	}
+
+	options = testOptions()
+	options.RespectRobotsTxt = false
+	result, err = SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+	if got := crawledPaths(t, result); len(got) != 4 || len(result.SkippedByRobots) != 0 {
+		t.Errorf("crawled paths = %v, skipped %v; want robots.txt ignored", got, result.SkippedByRobots)
+	}
+}
+
+func TestSpiderWebsiteRobotsFetchFailure(t *testing.T) {
+	t.Parallel()
+
+	var robotsStatus atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.WriteHeader(int(robotsStatus.Load()))
+			return
+		}
+		fmt.Fprint(w, fixturePage("Page "+r.URL.Path))
+	}))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		status  int
+		policy  RobotsFailurePolicy
+		crawled bool
+	}{
+		{http.StatusNotFound, RobotsFailureDisallow, true},
+		{http.StatusForbidden, RobotsFailureDisallow, true},
+		{http.StatusServiceUnavailable, RobotsFailureDisallow, false},
+		{http.StatusTooManyRequests, RobotsFailureDisallow, false},
+		{http.StatusServiceUnavailable, RobotsFailureAllow, true},
+	}
+	for _, tt := range tests {
+		robotsStatus.Store(int32(tt.status))
+		options := testOptions()
+		options.RobotsFetchFailurePolicy = tt.policy
+		result, err := SpiderWebsite(srv.URL+"/", options)
+		if err != nil {
+			t.Fatalf("SpiderWebsite() error = %v", err)
+		}
+		if crawled := len(result.CrawledURLs) == 1; crawled != tt.crawled {
+			t.Errorf("robots.txt status %d with policy %q: crawled %v, skipped %v", tt.status, tt.policy, result.CrawledURLs, result.SkippedByRobots)
+		}
+	}
+}
+
+type countingOCR struct {
+	calls atomic.Int32
+}
+
+func (o *countingOCR) Recognize(image []byte, contentType string) (string, error) {
+	o.calls.Add(1)
+	return string(image), nil
+}
+
+func TestSpiderWebsiteOCRImages(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private/\n")
+		case "/":
+			fmt.Fprint(w, `<html><body><main><p>Quarterly numbers for the whole team to read.</p>
+				<img src="/chart.png"><img src="/private/scan.png"></main></body></html>`)
+		default:
+			fmt.Fprint(w, "chart words")
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	ocr := &countingOCR{}
+	options := testOptions()
+	options.OCRImages = true
+	options.OCR = ocr
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+	if len(result.Pages) != 1 || !strings.Contains(result.Pages[0].Content, "[Image text: chart words]") {
+		t.Fatalf("pages = %+v, want the chart's text", result.Pages)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(requested) != "[/robots.txt / /chart.png]" || ocr.calls.Load() != 1 {
+		t.Errorf("requested %v with %d OCR calls, want the disallowed image left alone", requested, ocr.calls.Load())
+	}
+	if want := int64(len("chart words")); result.BytesDownloaded <= want {
+		t.Errorf("BytesDownloaded = %d, want the image counted", result.BytesDownloaded)
+	}
+}
+
+func TestRobotsCacheTTL(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	robots := "User-agent: *\nDisallow: /a\n"
+	status := http.StatusOK
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		w.WriteHeader(status)
+		fmt.Fprint(w, robots)
+	}))
+	t.Cleanup(srv.Close)
+	set := func(newStatus int, newRobots string) {
+		mu.Lock()
+		status, robots = newStatus, newRobots
+		mu.Unlock()
+	}
+
+	options := testOptions()
+	options.RobotsCacheTTL = 50 * time.Millisecond
+	cache := newRobotsCache(context.Background(), options, nil, zap.NewNop())
+	if cache.allowed(srv.URL + "/a") {
+		t.Error("/a allowed, want disallowed by the first robots.txt")
+	}
+
+	set(http.StatusOK, "User-agent: *\nDisallow: /b\n")
+	if cache.allowed(srv.URL + "/a") {
+		t.Error("/a allowed before the TTL expired")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !cache.allowed(srv.URL+"/a") || cache.allowed(srv.URL+"/b") {
+		t.Error("rules not refreshed after the TTL expired")
+	}
+
+	// A server error disallows everything until the next fetch
+	set(http.StatusInternalServerError, "")
+	time.Sleep(60 * time.Millisecond)
+	if cache.allowed(srv.URL + "/c") {
+		t.Error("/c allowed while robots.txt fails with 500")
+	}
+	set(http.StatusOK, "")
+	time.Sleep(60 * time.Millisecond)
+	if !cache.allowed(srv.URL + "/c") {
+		t.Error("/c disallowed after robots.txt recovered")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if fetches != 4 {
+		t.Errorf("robots.txt fetched %d times, want 4", fetches)
+	}
+}
+
+func TestRobotsCacheTruncatesLargeFiles(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "User-agent: *\nDisallow: /a\n")
+		fmt.Fprint(w, strings.Repeat("# padding\n", robotsMaxBodySize/10))
+		fmt.Fprint(w, "Disallow: /b\n")
+	}))
+	t.Cleanup(srv.Close)
+
+	cache := newRobotsCache(context.Background(), testOptions(), nil, zap.NewNop())
+	if cache.allowed(srv.URL + "/a") {
+		t.Error("/a allowed, want the rules before the size limit kept")
+	}
+	if !cache.allowed(srv.URL + "/b") {
+		t.Error("/b disallowed, want the rules past the size limit ignored")
+	}
+}
+
+func TestConcurrencyLimiterAdaptsToErrors(t *testing.T) {
+	limiter := newConcurrencyLimiter(&SpiderOptions{
+		Concurrency:         8,
+		AdaptiveConcurrency: true,
+		ErrorRateThreshold:  0.5,
+		ErrorRateWindow:     4,
+	})
+
+	run := func(failed bool) {
+		limiter.acquire()
+		limiter.release(failed)
+	}
+
+	for i := 0; i < 4; i++ {
+		run(true)
+	}
+	if limiter.limit != 4 {
+		t.Fatalf("limit after failing window = %d, want 4", limiter.limit)
+	}
+
+	for i := 0; i < 4; i++ {
+		run(false)
+	}
+	if limiter.limit != 5 {
+		t.Fatalf("limit after healthy window = %d, want 5", limiter.limit)
+	}
+
+	for i := 0; i < 40; i++ {
+		run(true)
+	}
+	if limiter.limit != 1 {
+		t.Fatalf("limit should not drop below the minimum, got %d", limiter.limit)
+	}
+}
+
+func TestSpiderWebsiteCrawlsLocalMirror(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"index.html":      fixturePage("Local home", "about.html", "docs/guide.html", "files/manual.pdf", "https://example.com/"),
+		"about.html":      fixturePage("About page", "index.html"),
+		"docs/guide.html": fixturePage("Guide page", "../about.html", "missing.html"),
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seed := (&url.URL{Scheme: "file", Path: filepath.ToSlash(dir) + "/about.html"}).String()
+	result, err := SpiderWebsite(seed, testOptions())
+	if err != nil {
+		t.Fatalf("SpiderWebsite returned error: %v", err)
+	}
+
+	for _, text := range []string{"Local home", "About page", "Guide page"} {
+		if !strings.Contains(result.Content, text) {
+			t.Errorf("content is missing %q", text)
+		}
+	}
+	if len(result.DetectedFileUrls) != 1 || !strings.HasSuffix(result.DetectedFileUrls[0], "/files/manual.pdf") {
+		t.Errorf("DetectedFileUrls = %v", result.DetectedFileUrls)
+	}
+	if len(result.FailedPages) != 1 {
+		t.Errorf("FailedPages = %v, want only the missing page", result.FailedPages)
+	}
+	for _, crawled := range result.CrawledURLs {
+		if !strings.HasPrefix(crawled, "file://") {
+			t.Errorf("crawl left the local mirror: %s", crawled)
+		}
+	}
+}
+
+func TestSpiderWebsiteInspectFiles(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	options := testOptions()
+	options.MaxDepth = 1
+	options.InspectFiles = true
+
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	want := webcrawl.FileInfo{
+		URL:         srv.URL + "/docs/report.pdf",
+		Size:        int64(len("%PDF-1.4 fixture")),
+		ContentType: "application/pdf",
+		Filename:    "annual-report.pdf",
+		Referrer:    srv.URL + "/",
+		Text:        "/docs/report.pdf",
+	}
+	if len(result.DetectedFiles) != 1 || result.DetectedFiles[0] != want {
+		t.Errorf("DetectedFiles = %+v, want [%+v]", result.DetectedFiles, want)
+	}
+
+	var b strings.Builder
+	if err := result.WriteDetectedFilesJSONL(&b); err != nil {
+		t.Fatalf("WriteDetectedFilesJSONL() error = %v", err)
+	}
+	var line webcrawl.FileInfo
+	if err := json.Unmarshal([]byte(b.String()), &line); err != nil || line != want {
+		t.Errorf("WriteDetectedFilesJSONL() = %s", b.String())
+	}
+}
+
+func TestSpiderWebsitePageOrder(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	tests := []struct {
+		order PageOrder
+		want  []string
+	}{
+		{PageOrderDepth, []string{"/", "/a", "/b", "/moved", "/target", "/a/deep"}},
+		{PageOrderDepthDescending, []string{"/a/deep", "/a", "/b", "/moved", "/target", "/"}},
+	}
+	for _, tt := range tests {
+		options := testOptions()
+		options.MaxDepth = 2
+		options.PageOrder = tt.order
+
+		result, err := SpiderWebsite(srv.URL+"/", options)
+		if err != nil {
+			t.Fatalf("SpiderWebsite() error = %v", err)
+		}
+
+		var got []string
+		for _, page := range result.Pages {
+			got = append(got, strings.TrimPrefix(page.URL, srv.URL))
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: pages = %v, want %v", tt.order, got, tt.want)
+		}
+		if !strings.HasPrefix(result.Content, "\n\n# URL: "+result.Pages[0].URL+"\n") {
+			t.Errorf("%s: Content does not start with %s", tt.order, result.Pages[0].URL)
+		}
+	}
+}
+
+func TestSpiderWebsiteClassifyURL(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	options := testOptions()
+	options.MaxDepth = 1
+	options.ClassifyURL = func(u *url.URL) URLClass {
+		switch u.Path {
+		case "/a":
+			return File
+		case "/b", "/docs/report.pdf":
+			return Skip
+		}
+		return DefaultClassifyURL(u)
+	}
+
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	got := crawledPaths(t, result)
+	want := []string{"/", "/moved", "/target"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("crawled = %v, want %v", got, want)
+	}
+	if len(result.DetectedFileUrls) != 1 || result.DetectedFileUrls[0] != srv.URL+"/a" {
+		t.Errorf("DetectedFileUrls = %v, want [%s/a]", result.DetectedFileUrls, srv.URL)
+	}
+}
+
+func TestSpiderWebsitePreferredVariant(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	tests := []struct {
+		name      string
+		seed      string
+		configure func(*SpiderOptions)
+		crawled   string
+		replaced  string
+	}{
+		{"amp", "/story", func(o *SpiderOptions) { o.PreferAMP = true }, "/story/amp", "/story"},
+		{"canonical", "/story/amp", func(o *SpiderOptions) { o.PreferCanonical = true }, "/story", "/story/amp"},
+		{"amp wins", "/story/amp", func(o *SpiderOptions) { o.PreferAMP = true; o.PreferCanonical = true }, "/story/amp", ""},
+	}
+	for _, tt := range tests {
+		options := testOptions()
+		tt.configure(options)
+
+		result, err := SpiderWebsite(srv.URL+tt.seed, options)
+		if err != nil {
+			t.Fatalf("%s: SpiderWebsite() error = %v", tt.name, err)
+		}
+
+		if got := crawledPaths(t, result); len(got) != 1 || got[0] != tt.crawled {
+			t.Errorf("%s: crawled = %v, want [%s]", tt.name, got, tt.crawled)
+		}
+		if tt.replaced == "" {
+			if len(result.Alternates) != 0 {
+				t.Errorf("%s: Alternates = %v, want none", tt.name, result.Alternates)
+			}
+		} else if result.Alternates[srv.URL+tt.replaced] != srv.URL+tt.crawled {
+			t.Errorf("%s: Alternates = %v", tt.name, result.Alternates)
+		}
+	}
+}
+
+func TestSpiderWebsiteWalledPages(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	for _, path := range []string{"/login", "/premium", "/a"} {
+		result, err := SpiderWebsite(srv.URL+path, testOptions())
+		if err != nil {
+			t.Fatalf("SpiderWebsite(%s) error = %v", path, err)
+		}
+
+		walled := len(result.WalledPages) == 1 && result.WalledPages[0] == srv.URL+path
+		if want := path != "/a"; walled != want {
+			t.Errorf("%s: WalledPages = %v, want walled = %v", path, result.WalledPages, want)
+		}
+	}
+}
+
+func TestSpiderWebsiteMaxBytes(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	options := testOptions()
+	options.Concurrency = 1
+	options.MaxBytes = 1
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+	if got := crawledPaths(t, result); fmt.Sprint(got) != "[/]" {
+		t.Errorf("crawled paths = %v, want only the seed", got)
+	}
+	if !result.ByteLimitReached || result.BytesDownloaded <= 1 {
+		t.Errorf("ByteLimitReached = %v, BytesDownloaded = %d", result.ByteLimitReached, result.BytesDownloaded)
+	}
+
+	options.MaxBytes = 1 << 20
+	result, err = SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+	if result.ByteLimitReached || len(result.CrawledURLs) < 2 {
+		t.Errorf("ByteLimitReached = %v with %d pages crawled, want the whole crawl", result.ByteLimitReached, len(result.CrawledURLs))
+	}
+}
+
+func TestSpiderWebsiteMaxTotalChars(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	options := testOptions()
+	options.MaxTotalChars = 200
+
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	if !result.ContentLimitReached {
+		t.Error("ContentLimitReached = false, want true")
+	}
+	if n := len([]rune(result.Content)); n > options.MaxTotalChars {
+		t.Errorf("Content has %d chars, want at most %d", n, options.MaxTotalChars)
+	}
+	if len(result.Pages) != result.SuccessfulPages || len(result.Pages) >= result.TotalPages {
+		t.Errorf("stored %d of %d pages", len(result.Pages), result.TotalPages)
+	}
+}
+
+func TestSpiderWebsiteUnboundedQueue(t *testing.T) {
+	t.Parallel()
+
+	links := make([]string, 60)
+	for i := range links {
+		links[i] = fmt.Sprintf("/page/%d", i)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, fixturePage("Index", links...))
+			return
+		}
+		fmt.Fprint(w, fixturePage("Page "+r.URL.Path))
+	}))
+	t.Cleanup(srv.Close)
+
+	options := testOptions()
+	options.MaxPages = 20
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+	if result.DroppedLinks != 0 || result.SuccessfulPages != 20 {
+		t.Errorf("dropped %d links and crawled %d pages, want 0 and 20", result.DroppedLinks, result.SuccessfulPages)
+	}
+
+	frontier := NewMemoryFrontier(0)
+	for i := 0; i < 1000; i++ {
+		frontier.Push(FrontierJob{URL: strconv.Itoa(i)})
+	}
+	if frontier.Len() != 1000 {
+		t.Fatalf("Len() = %d, want 1000", frontier.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		if job, ok := frontier.Pop(time.Millisecond); !ok || job.URL != strconv.Itoa(i) {
+			t.Fatalf("Pop() = %v, %v; want job %d", job, ok, i)
+		}
+	}
+	if _, ok := frontier.Pop(time.Millisecond); ok {
+		t.Error("Pop() on an empty frontier returned a job")
+	}
+}
+
+func TestSpiderWebsiteMaxQueueSize(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	options := testOptions()
+	options.Concurrency = 1
+	options.MaxQueueSize = 1
+
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	// With one worker, only the first link of each page fits in the queue,
+	// so the crawl follows a single chain instead of the whole site
+	if result.DroppedLinks == 0 {
+		t.Error("DroppedLinks = 0, want links dropped")
+	}
+	if result.TotalPages >= 6 {
+		t.Errorf("TotalPages = %d, want fewer than 6", result.TotalPages)
+	}
+}
+
+func TestSpiderWebsiteEnqueuesEachURLOnce(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	options := testOptions()
+	options.RecordEvents = true
+
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	// /b links back to /, which was already crawled
+	enqueued := make(map[string]int)
+	for _, event := range result.Events {
+		if event.Type == EventEnqueued {
+			enqueued[event.URL]++
+		}
+	}
+	for link, n := range enqueued {
+		if n > 1 {
+			t.Errorf("%s enqueued %d times", link, n)
+		}
+	}
+}
+
+func TestSpiderResultWriteJSONLinkGraph(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	options := testOptions()
+	options.MaxDepth = 1
+	options.TrackLinkGraph = true
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := result.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+	var output jsonResult
+	if err := json.Unmarshal(buf.Bytes(), &output); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(output.Pages) != len(result.Pages) || len(output.Failed) != 1 {
+		t.Errorf("got %d pages and %d failures, want %d and 1", len(output.Pages), len(output.Failed), len(result.Pages))
+	}
+
+	// Pages at MaxDepth still have their links recorded
+	if got := output.LinkGraph[srv.URL+"/a"]; fmt.Sprint(got) != fmt.Sprint([]string{srv.URL + "/a/deep"}) {
+		t.Errorf("linkGraph[/a] = %v", got)
+	}
+	home := strings.Join(output.LinkGraph[srv.URL+"/"], " ")
+	if !strings.Contains(home, srv.URL+"/b") || strings.Contains(home, "report.pdf") {
+		t.Errorf("linkGraph[/] = %s, want crawlable links only", home)
+	}
+
+	result.LinkGraph = nil
+	buf.Reset()
+	if err := result.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+	if strings.Contains(buf.String(), "linkGraph") {
+		t.Error("linkGraph should be left out without TrackLinkGraph")
+	}
+}
+
+func TestSpiderResultWritePDF(t *testing.T) {
+	t.Parallel()
+
+	result := &SpiderResult{Pages: []PageResult{
+		{URL: "https://example.com/", Title: "Home (start)", Content: "## Welcome\n\nCafé prices – " + strings.Repeat("lorem ipsum ", 600)},
+		{URL: "https://example.com/about", Content: "About us " + strings.Repeat("x", 300)},
+	}}
+
+	var buf bytes.Buffer
+	if err := result.WritePDF(&buf, "Example crawl", nil); err != nil {
+		t.Fatalf("WritePDF() error = %v", err)
+	}
+	pdf := buf.String()
+	if !strings.HasPrefix(pdf, "%PDF-1.4\n") || !strings.HasSuffix(pdf, "%%EOF\n") {
+		t.Fatal("output is not a PDF")
+	}
+
+	// Every xref entry must point at its object
+	xrefStart, err := strconv.Atoi(strings.Fields(pdf[strings.LastIndex(pdf, "startxref"):])[1])
+	if err != nil {
+		t.Fatalf("bad startxref: %v", err)
+	}
+	entries := strings.Split(pdf[xrefStart:strings.Index(pdf, "trailer")], "\n")[3:]
+	for i, entry := range entries {
+		if entry == "" {
+			continue
+		}
+		offset, _ := strconv.Atoi(entry[:10])
+		if want := fmt.Sprintf("%d 0 obj", i+1); !strings.HasPrefix(pdf[offset:], want) {
+			t.Errorf("xref entry %d points at %q", i+1, pdf[offset:offset+10])
+		}
+	}
+
+	// The first page's text runs onto a second PDF page, so the second
+	// section starts on page 4 after the table of contents
+	for _, want := range []string{"/Count 4 >>", "(Contents)", `(1. Home \(start\))`, "(https://example.com/about)", "(4)", `(Caf\351 prices \226 lorem`, "/Title (Home \\(start\\))"} {
+		if !strings.Contains(pdf, want) {
+			t.Errorf("PDF does not contain %q", want)
+		}
+	}
+}
+
+// recordingDriver stands in for a SQLite driver, recording the statements
+// executed against each DSN.
+type recordingDriver struct {
+	mu    sync.Mutex
+	execs map[string][]recordedExec
+}
+
+type recordedExec struct {
+	query string
+	args  []driver.Value
+}
+
+type recordingConn struct {
+	driver *recordingDriver
+	dsn    string
+}
+
+type recordingStmt struct {
+	conn  *recordingConn
+	query string
+}
+
+var sqliteRecorder = &recordingDriver{execs: make(map[string][]recordedExec)}
+
+func init() {
+	sql.Register("sqlite", sqliteRecorder)
+}
+
+func (d *recordingDriver) Open(dsn string) (driver.Conn, error) {
+	return &recordingConn{driver: d, dsn: dsn}, nil
+}
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return &recordingStmt{conn: c, query: query}, nil
+}
+func (c *recordingConn) Close() error              { return nil }
+func (c *recordingConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *recordingConn) Commit() error             { return nil }
+func (c *recordingConn) Rollback() error           { return nil }
+
+func (s *recordingStmt) Close() error  { return nil }
+func (s *recordingStmt) NumInput() int { return -1 }
+func (s *recordingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.conn.driver.mu.Lock()
+	defer s.conn.driver.mu.Unlock()
+	s.conn.driver.execs[s.conn.dsn] = append(s.conn.driver.execs[s.conn.dsn], recordedExec{s.query, args})
+	return driver.RowsAffected(1), nil
+}
+func (s *recordingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+func TestSpiderResultWriteSQLite(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	options := testOptions()
+	options.MaxDepth = 1
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "crawl.db")
+	if err := result.WriteSQLite(path); err != nil {
+		t.Fatalf("WriteSQLite() error = %v", err)
+	}
+
+	sqliteRecorder.mu.Lock()
+	execs := sqliteRecorder.execs[path]
+	sqliteRecorder.mu.Unlock()
+	inserts := make(map[string][][]driver.Value)
+	for _, exec := range execs {
+		if table, ok := strings.CutPrefix(exec.query, "INSERT INTO "); ok {
+			table, _, _ = strings.Cut(table, " ")
+			inserts[table] = append(inserts[table], exec.args)
+		}
+	}
+
+	if len(inserts["pages"]) != len(result.Pages) {
+		t.Fatalf("inserted %d pages, want %d", len(inserts["pages"]), len(result.Pages))
+	}
+	for _, row := range inserts["pages"] {
+		if row[0] == srv.URL+"/" && (row[3] != int64(200) || row[6] == nil) {
+			t.Errorf("home page row = %v, want status 200 and fetched_at", row)
+		}
+	}
+	if len(inserts["files"]) != 1 || inserts["files"][0][0] != srv.URL+"/docs/report.pdf" {
+		t.Errorf("files rows = %v", inserts["files"])
+	}
+	found := false
+	for _, row := range inserts["links"] {
+		if row[0] == srv.URL+"/" && row[1] == srv.URL+"/a" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("links rows = %v, want a link from / to /a", inserts["links"])
+	}
+}
+
+// TestSpiderResultWriteSQLiteReplay runs the statements WriteSQLite executes
+// through the sqlite3 command, so the schema is checked by SQLite itself.
+func TestSpiderResultWriteSQLiteReplay(t *testing.T) {
+	t.Parallel()
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 command not found")
+	}
+
+	// A post seed that was already crawled shows up twice
+	result := &SpiderResult{
+		Pages: []PageResult{
+			{URL: "https://example.com/", Title: "Home", Content: "It's home", StatusCode: 200},
+			{URL: "https://example.com/a", Depth: 1, StatusCode: 200, Links: []webcrawl.LinkData{{Href: "https://example.com/"}}},
+			{URL: "https://example.com/", Title: "Home", Content: "It's home", StatusCode: 200},
+		},
+		DetectedFiles: []webcrawl.FileInfo{{URL: "https://example.com/report.pdf", Filename: "report.pdf"}},
+	}
+	path := filepath.Join(t.TempDir(), "crawl.db")
+	if err := result.WriteSQLite(path); err != nil {
+		t.Fatalf("WriteSQLite() error = %v", err)
+	}
+
+	sqliteRecorder.mu.Lock()
+	execs := sqliteRecorder.execs[path]
+	sqliteRecorder.mu.Unlock()
+	var script strings.Builder
+	script.WriteString("BEGIN;\n")
+	for _, e := range execs {
+		query := e.query
+		for _, arg := range e.args {
+			query = strings.Replace(query, "?", sqliteLiteral(arg), 1)
+		}
+		script.WriteString(query + ";\n")
+	}
+	script.WriteString("COMMIT;\nSELECT count(*), count(DISTINCT url) FROM pages;\n")
+
+	cmd := exec.Command(sqlite3, "-bail", path)
+	cmd.Stdin = strings.NewReader(script.String())
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3 error = %v: %s", err, out)
+	}
+	if got := strings.TrimSpace(string(out)); got != "3|2" {
+		t.Errorf("pages count = %q, want 3|2", got)
+	}
+}
+
+// sqliteLiteral renders a driver value as a SQL literal.
+func sqliteLiteral(v driver.Value) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	}
+	return fmt.Sprint(v)
+}
+
+func TestSpiderResultWriteZip(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	options := testOptions()
+	options.MaxDepth = 1
+	options.PageHeaderTemplate = "\n\n## Source: {{.URL}} (depth {{.Depth}})\n\n"
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := result.WriteZip(&buf); err != nil {
+		t.Fatalf("WriteZip() error = %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader() error = %v", err)
+	}
+
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	var manifest []manifestEntry
+	readZipJSON(t, files["manifest.json"], &manifest)
+	if len(manifest) != len(result.Pages) {
+		t.Fatalf("manifest has %d entries, want %d", len(manifest), len(result.Pages))
+	}
+	for _, entry := range manifest {
+		f := files[entry.Filename]
+		if f == nil {
+			t.Errorf("manifest references missing file %s", entry.Filename)
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Open(%s) error = %v", entry.Filename, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("ReadAll(%s) error = %v", entry.Filename, err)
+		}
+		wantHeader := fmt.Sprintf("## Source: %s (depth %d)\n\n", entry.URL, entry.Depth)
+		if !strings.HasPrefix(string(data), wantHeader) {
+			t.Errorf("%s starts with %q, want the page header %q", entry.Filename, string(data[:min(len(data), 60)]), wantHeader)
+		}
+	}
+
+	var failed []failedEntry
+	readZipJSON(t, files["failed.json"], &failed)
+	if len(failed) != 1 || failed[0].URL != srv.URL+"/missing" {
+		t.Errorf("failed.json = %+v, want the /missing page", failed)
+	}
+}
+
+func TestSpiderResultReadJSON(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	result, err := SpiderWebsite(srv.URL+"/", testOptions())
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+	var buf bytes.Buffer
+	if err := result.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+	loaded, err := ReadJSON(&buf)
+	if err != nil {
+		t.Fatalf("ReadJSON() error = %v", err)
+	}
+
+	if len(loaded.Pages) != len(result.Pages) || fmt.Sprint(loaded.FailedPages) != fmt.Sprint(result.FailedPages) {
+		t.Errorf("loaded %d pages and failures %v, want %d and %v", len(loaded.Pages), loaded.FailedPages, len(result.Pages), result.FailedPages)
+	}
+	for i, page := range loaded.Pages {
+		if i < len(result.Pages) && (page.URL != result.Pages[i].URL || !page.FetchedAt.Equal(result.Pages[i].FetchedAt)) {
+			t.Errorf("page %d = %s fetched at %v, want %s fetched at %v", i, page.URL, page.FetchedAt, result.Pages[i].URL, result.Pages[i].FetchedAt)
+		}
+	}
+	diff := DiffResults(result, loaded)
+	if len(diff.New)+len(diff.Changed)+len(diff.Removed)+len(diff.NewlyBroken)+len(diff.Fixed) != 0 {
+		t.Errorf("diff against the original = %+v, want no changes", diff)
+	}
+}
+
+func TestPageResultJSON(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	options := testOptions()
+	options.MaxDepth = 0
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+	data, err := json.Marshal(result.Pages)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+
+	var pages []map[string]any
+	if err := json.Unmarshal(data, &pages); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if len(pages) != 1 {
+		t.Fatalf("got %d pages, want 1: %s", len(pages), data)
+	}
+	page := pages[0]
+	for _, key := range []string{"url", "depth", "status_code", "content", "links"} {
+		if _, ok := page[key]; !ok {
+			t.Errorf("page JSON has no %q: %s", key, data)
+		}
+	}
+	if page["url"] != srv.URL+"/" || page["status_code"] != float64(http.StatusOK) {
+		t.Errorf("page JSON = %s", data)
+	}
+
+	var decoded []PageResult
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if decoded[0].Content != result.Pages[0].Content || len(decoded[0].Links) != len(result.Pages[0].Links) {
+		t.Errorf("decoded page = %+v, want %+v", decoded[0], result.Pages[0])
+	}
+}
+
+func TestSpiderResultGobRoundTrip(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	options := testOptions()
+	options.MaxDepth = 1
+	options.RecordEvents = true
+	options.OnEvent = func(CrawlEvent) {}
+	options.Transport = http.DefaultTransport
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "result.gob")
+	if err := result.SaveGob(path); err != nil {
+		t.Fatalf("SaveGob() error = %v", err)
+	}
+	loaded, err := LoadGob(path)
+	if err != nil {
+		t.Fatalf("LoadGob() error = %v", err)
+	}
+
+	if loaded.Content != result.Content || len(loaded.Pages) != len(result.Pages) ||
+		len(loaded.FailedPages) != len(result.FailedPages) || len(loaded.Events) != len(result.Events) {
+		t.Errorf("loaded result differs from the saved one")
+	}
+	if loaded.EffectiveOptions.MaxDepth != 1 || loaded.EffectiveOptions.Transport != nil {
+		t.Errorf("EffectiveOptions = %+v", loaded.EffectiveOptions)
+	}
+	if result.EffectiveOptions.Transport == nil {
+		t.Errorf("SaveGob modified the result's options")
+	}
+}
+func readZipJSON(t *testing.T, f *zip.File, v interface{}) {
+	t.Helper()
+
+	if f == nil {
+		t.Fatal("file missing from zip")
+	}
+	rc, err := f.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if err := json.NewDecoder(rc).Decode(v); err != nil {
+		t.Fatalf("failed to decode %s: %v", f.Name, err)
+	}
+}
+
+func TestPageSlug(t *testing.T) {
+	tests := map[string]string{
+		"https://Example.com/":                "example-com",
+		"https://example.com/docs/Intro.html": "example-com-docs-intro-html",
+		"https://example.com/a?b=c":           "example-com-a",
+	}
+	for pageURL, want := range tests {
+		if got := pageSlug(pageURL); got != want {
+			t.Errorf("pageSlug(%q) = %q, want %q", pageURL, got, want)
+		}
+	}
+}
+
+func TestSpiderWebsiteExcludeURLs(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	listPath := filepath.Join(t.TempDir(), "exclude.txt")
+	list := "# pages handled by another run\n" + srv.URL + "/a#top\n\n" + srv.URL + "/b\n"
+	if err := os.WriteFile(listPath, []byte(list), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	excluded, err := LoadURLList(listPath)
+	if err != nil {
+		t.Fatalf("LoadURLList() error = %v", err)
+	}
+	if len(excluded) != 2 {
+		t.Fatalf("LoadURLList() = %v, want 2 URLs", excluded)
+	}
+
+	options := testOptions()
+	options.ExcludeURLs = excluded
+
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	// /a/deep is only reachable through the excluded /a
+	got := crawledPaths(t, result)
+	want := []string{"/", "/moved", "/target"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("crawled = %v, want %v", got, want)
+	}
+}
+
+func TestSpiderWebsiteContentScorer(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	options := testOptions()
+	options.MaxDepth = 1
+	options.ContentScorer = func(page PageResult) float64 {
+		if strings.Contains(page.Content, "Page") {
+			return 1
+		}
+		return 0
+	}
+	options.MinScore = 0.5
+
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	got := crawledPaths(t, result)
+	want := []string{"/a", "/b"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("crawled = %v, want %v", got, want)
+	}
+	if len(result.LowScorePages) != 3 {
+		t.Errorf("LowScorePages = %v, want /, /moved and /target", result.LowScorePages)
+	}
+	for _, page := range result.Pages {
+		if page.Score != 1 {
+			t.Errorf("%s: Score = %v, want 1", page.URL, page.Score)
+		}
+	}
+}
+
+func TestSpiderWebsitePageHeaderTemplate(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	options := testOptions()
+	options.MaxDepth = 0
+	options.PageHeaderTemplate = "=== {{.URL}} (depth {{.Depth}}) ===\n"
+
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+	want := "=== " + srv.URL + "/ (depth 0) ===\n" + result.Pages[0].Content
+	if result.Content != want {
+		t.Errorf("Content = %q, want %q", result.Content, want)
+	}
+
+	for _, invalid := range []string{"{{.URL", "{{.Missing}}"} {
+		options.PageHeaderTemplate = invalid
+		if _, err := SpiderWebsite(srv.URL+"/", options); err == nil {
+			t.Errorf("SpiderWebsite() with template %q succeeded, want error", invalid)
+		}
+	}
+}
+
+func TestSpiderWebsiteMaxHosts(t *testing.T) {
+	t.Parallel()
+
+	var port string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.TrimSuffix(r.Host, ":"+port)
+		if host != "site.test" {
+			fmt.Fprint(w, fixturePage("Subdomain "+host))
+			return
+		}
+		fmt.Fprint(w, fixturePage("Main site",
+			"http://a.site.test:"+port+"/",
+			"http://b.site.test:"+port+"/",
+			"http://c.site.test:"+port+"/"))
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	port = u.Port()
+
+	options := testOptions()
+	options.MaxHosts = 2
+	options.HostIPOverride = map[string]string{
+		"site.test":   "127.0.0.1",
+		"a.site.test": "127.0.0.1",
+		"b.site.test": "127.0.0.1",
+		"c.site.test": "127.0.0.1",
+	}
+
+	result, err := SpiderWebsite("http://site.test:"+port+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	// Links are queued in sorted order, so a.site.test takes the second slot
+	want := []string{"http://a.site.test:" + port + "/", "http://site.test:" + port + "/"}
+	got := append([]string{}, result.CrawledURLs...)
+	sort.Strings(got)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("CrawledURLs = %v, want %v", got, want)
+	}
+	if len(result.SkippedHosts) != 2 {
+		t.Errorf("SkippedHosts = %v, want b and c", result.SkippedHosts)
+	}
+}
+
+func TestSpiderWebsiteSharedVisitedSet(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	// Two crawls sharing a visited set behave like two processes working
+	// through the same site: the second finds everything already claimed
+	visited := NewMemoryVisitedSet()
+
+	options := testOptions()
+	options.VisitedSet = visited
+	first, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+	if first.SuccessfulPages == 0 || !visited.Contains(srv.URL+"/a") {
+		t.Fatalf("first crawl stored %d pages, visited /a = %v", first.SuccessfulPages, visited.Contains(srv.URL+"/a"))
+	}
+
+	options = testOptions()
+	options.VisitedSet = visited
+	options.Frontier = NewMemoryFrontier(10)
+	second, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+	if second.TotalPages != 0 {
+		t.Errorf("second crawl fetched %d pages, want 0", second.TotalPages)
+	}
+}
+
+func TestSpiderWebsiteAnchorIndex(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	options := testOptions()
+	options.MaxDepth = 1
+	options.BuildAnchorIndex = true
+
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	// fixturePage uses each link's href as its text
+	if got := result.AnchorIndex["/a"]; len(got) != 1 || got[0] != srv.URL+"/a" {
+		t.Errorf(`AnchorIndex["/a"] = %v`, got)
+	}
+	if got := result.AnchorIndex["https://external.example/page"]; len(got) != 1 {
+		t.Errorf("external link missing from AnchorIndex: %v", result.AnchorIndex)
+	}
+	// /b links back to / with the same text
+	if got := result.AnchorIndex["/"]; len(got) != 1 {
+		t.Errorf(`AnchorIndex["/"] = %v, want one deduplicated target`, got)
+	}
+}
+
+func TestSpiderWebsiteExternalLinks(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	options := testOptions()
+	options.MaxDepth = 1
+	options.RecordExternalLinks = true
+
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	if len(result.ExternalLinks) != 1 {
+		t.Fatalf("ExternalLinks = %+v, want only the external.example link", result.ExternalLinks)
+	}
+	link := result.ExternalLinks[0]
+	if link.Href != "https://external.example/page" || link.Count != 1 || link.Text != link.Href {
+		t.Errorf("ExternalLinks[0] = %+v", link)
+	}
+}
+
+func TestSpiderWebsiteChangedSince(t *testing.T) {
+	t.Parallel()
+
+	cutoff := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	pages := map[string]struct {
+		body         string
+		lastModified time.Time
+	}{
+		"/":            {fixturePage("Home", "/old", "/stale", "/new"), cutoff.Add(-time.Hour)},
+		"/old":         {fixturePage("Old page", "/old/child"), cutoff.AddDate(-1, 0, 0)},
+		"/old/child":   {fixturePage("Only linked from /old"), cutoff.AddDate(0, 1, 0)},
+		"/stale":       {fixturePage("Stale page", "/stale/child"), cutoff.AddDate(-1, 0, 0)},
+		"/stale/child": {fixturePage("Linked from a stale page"), cutoff.AddDate(0, 1, 0)},
+		"/new":         {fixturePage("New page"), cutoff.AddDate(0, 1, 0)},
+	}
+	var conditional atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("If-Modified-Since") != "" {
+			conditional.Add(1)
+		}
+		// /stale ignores If-Modified-Since, like many dynamic pages
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil &&
+			r.URL.Path != "/stale" && !page.lastModified.After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Last-Modified", page.lastModified.Format(http.TimeFormat))
+		fmt.Fprint(w, page.body)
+	}))
+	t.Cleanup(srv.Close)
+
+	options := testOptions()
+	options.ChangedSince = cutoff
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	sort.Strings(result.UnchangedPages)
+	want := []string{srv.URL + "/", srv.URL + "/old", srv.URL + "/stale"}
+	if fmt.Sprint(result.UnchangedPages) != fmt.Sprint(want) {
+		t.Errorf("UnchangedPages = %v, want %v", result.UnchangedPages, want)
+	}
+	if got := crawledPaths(t, result); fmt.Sprint(got) != "[/new /stale/child]" {
+		t.Errorf("crawled = %v, want [/new /stale/child]", got)
+	}
+	if len(result.FailedPages) != 0 {
+		t.Errorf("FailedPages = %v", result.FailedPages)
+	}
+	if conditional.Load() != 4 {
+		t.Errorf("%d conditional requests, want all but the seed", conditional.Load())
+	}
+}
+
+func TestSpiderWebsiteChangedSincePreviousResult(t *testing.T) {
+	t.Parallel()
+	cutoff := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	type fixture struct {
+		body         string
+		lastModified time.Time
+	}
+	pages := map[string]fixture{
+		"/":            {fixturePage("Home", "/section"), cutoff.AddDate(0, -1, 0)},
+		"/section":     {fixturePage("Section index", "/section/old", "/section/new"), cutoff.AddDate(0, -1, 0)},
+		"/section/old": {fixturePage("Old article"), cutoff.AddDate(0, -1, 0)},
+		"/section/new": {fixturePage("New article"), cutoff.AddDate(0, 1, 0)},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !page.lastModified.After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Last-Modified", page.lastModified.Format(http.TimeFormat))
+		fmt.Fprint(w, page.body)
+	}))
+	t.Cleanup(srv.Close)
+
+	options := testOptions()
+	options.TrackLinkGraph = true
+	previous, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	// The second crawl only sees the section index through a 304, so the
+	// third has to find its links in the second's LinkGraph
+	options.ChangedSince = cutoff
+	for i := range 2 {
+		options.PreviousResult = previous
+		result, err := SpiderWebsite(srv.URL+"/", options)
+		if err != nil {
+			t.Fatalf("SpiderWebsite() error = %v", err)
+		}
+		if got := crawledPaths(t, result); fmt.Sprint(got) != "[/section/new]" {
+			t.Errorf("crawl %d: crawled = %v, want [/section/new]", i+2, got)
+		}
+		sort.Strings(result.UnchangedPages)
+		want := []string{srv.URL + "/", srv.URL + "/section", srv.URL + "/section/old"}
+		if fmt.Sprint(result.UnchangedPages) != fmt.Sprint(want) {
+			t.Errorf("crawl %d: UnchangedPages = %v, want %v", i+2, result.UnchangedPages, want)
+		}
+		previous = result
+	}
+}
+
+func TestSpiderWebsiteMarkdownFrontMatter(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	options := testOptions()
+	options.MaxDepth = 0
+	options.MarkdownFrontMatter = true
+	result, err := SpiderWebsite(srv.URL+"/story", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+	if len(result.Pages) != 1 {
+		t.Fatalf("got %d pages, want 1", len(result.Pages))
+	}
+
+	page := result.Pages[0]
+	want := fmt.Sprintf("---\nurl: %q\ndepth: 0\nword_count: %d\n---\n", page.URL, len(strings.Fields(page.Content)))
+	if got := page.FrontMatter(); got != want {
+		t.Errorf("FrontMatter() = %q, want %q", got, want)
+	}
+	if !strings.HasPrefix(result.Content, "\n\n"+want+"\n") || strings.Contains(result.Content, "# URL:") {
+		t.Errorf("Content should start with the front matter instead of the URL header: %q", result.Content)
+	}
+
+	page.Title = `Say "hi"`
+	if got := page.FrontMatter(); !strings.Contains(got, `title: "Say \"hi\""`) {
+		t.Errorf("title not quoted for YAML: %q", got)
+	}
+}
+
+func TestSpiderWebsiteMaxPathSegments(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	options := testOptions()
+	options.MaxPathSegments = 1
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	want := []string{"/", "/a", "/b", "/moved", "/target"}
+	if got := crawledPaths(t, result); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("crawled paths = %v, want %v", got, want)
+	}
+	if len(result.DetectedFileUrls) != 1 {
+		t.Errorf("file links should still be detected: %v", result.DetectedFileUrls)
+	}
+}
+
+func TestSpiderWebsiteCaptureErrorPages(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	options := testOptions()
+	options.MaxDepth = 1
+	options.CaptureErrorPages = true
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	if len(result.FailedPages) != 0 {
+		t.Errorf("FailedPages = %v, want error pages captured", result.FailedPages)
+	}
+	var missing *PageResult
+	for i, page := range result.Pages {
+		if page.URL == srv.URL+"/missing" {
+			missing = &result.Pages[i]
+		} else if page.StatusCode != http.StatusOK {
+			t.Errorf("%s has StatusCode %d", page.URL, page.StatusCode)
+		}
+	}
+	if missing == nil || missing.StatusCode != http.StatusNotFound || !strings.Contains(missing.Content, "404 page not found") {
+		t.Errorf("/missing page = %+v", missing)
+	}
+	if err := result.Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+}
+
+func TestSpiderWebsiteProcessConcurrency(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	var inProcess, maxInProcess atomic.Int32
+	options := testOptions()
+	options.Concurrency = 5
+	options.ProcessConcurrency = 1
+	options.ContentScorer = func(page PageResult) float64 {
+		n := inProcess.Add(1)
+		defer inProcess.Add(-1)
+		for {
+			seen := maxInProcess.Load()
+			if n <= seen || maxInProcess.CompareAndSwap(seen, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		return 1
+	}
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+	if len(result.Pages) < 2 {
+		t.Fatalf("got %d pages, want several", len(result.Pages))
+	}
+	if got := maxInProcess.Load(); got != 1 {
+		t.Errorf("%d pages processed at once, want 1", got)
+	}
+}
+
+func TestSpiderWebsiteNearDuplicates(t *testing.T) {
+	t.Parallel()
+
+	var article strings.Builder
+	for i := 0; i < 40; i++ {
+		fmt.Fprintf(&article, "Section %d of the release notes describes change number %d in detail. ", i, i*7)
+	}
+	pages := map[string]string{
+		"/":      fixturePage("Index", "/v1", "/v2", "/other"),
+		"/v1":    fixturePage(article.String() + "Generated at 10:04:31 on Monday."),
+		"/v2":    fixturePage(article.String() + "Generated at 17:45:02 on Tuesday."),
+		"/other": fixturePage(strings.Repeat("An unrelated page about gardening, soil and the best time to plant tomatoes. ", 30)),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, pages[r.URL.Path])
+	}))
+	t.Cleanup(srv.Close)
+
+	options := testOptions()
+	options.DetectNearDuplicates = true
+	options.Concurrency = 1
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	if len(result.NearDuplicates) != 1 {
+		t.Fatalf("NearDuplicates = %v, want one group", result.NearDuplicates)
+	}
+	for original, duplicates := range result.NearDuplicates {
+		group := append([]string{original}, duplicates...)
+		sort.Strings(group)
+		if want := []string{srv.URL + "/v1", srv.URL + "/v2"}; fmt.Sprint(group) != fmt.Sprint(want) {
+			t.Errorf("near duplicate group = %v, want %v", group, want)
+		}
+	}
+}
+
+func TestSpiderWebsiteDuplicateTitles(t *testing.T) {
+	t.Parallel()
+
+	titled := func(title, text string, links ...string) string {
+		return strings.Replace(fixturePage(text, links...), "<html>", "<html><head><title>"+title+"</title></head>", 1)
+	}
+	pages := map[string]string{
+		"/":      titled("Home", "Index", "/shoes", "/hats?page=2", "/about"),
+		"/shoes": titled("Products | Shop", "Shoes"),
+		"/hats":  titled("Products  |  Shop", "Hats"),
+		"/about": titled("About", "About us"),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, pages[r.URL.Path])
+	}))
+	t.Cleanup(srv.Close)
+
+	options := testOptions()
+	options.DuplicateTitles = DuplicateTitlesReport
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+	want := map[string][]string{"Products | Shop": {srv.URL + "/hats?page=2", srv.URL + "/shoes"}}
+	if fmt.Sprint(result.DuplicateTitles) != fmt.Sprint(want) {
+		t.Errorf("DuplicateTitles = %v, want %v", result.DuplicateTitles, want)
+	}
+
+	options.DuplicateTitles = DuplicateTitlesSuffix
+	result, err = SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+	titles := make(map[string]string)
+	for _, page := range result.Pages {
+		titles[strings.TrimPrefix(page.URL, srv.URL)] = page.Title
+	}
+	if titles["/shoes"] != "Products | Shop (/shoes)" || titles["/hats?page=2"] != "Products | Shop (/hats?page=2)" || titles["/about"] != "About" {
+		t.Errorf("titles = %v", titles)
+	}
+}
+
+func TestSpiderWebsiteSpillToDir(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	dir := filepath.Join(t.TempDir(), "pages")
+	options := testOptions()
+	options.SpillToDir = dir
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+	if len(result.Pages) == 0 {
+		t.Fatal("no pages crawled")
+	}
+	if result.Content != "" {
+		t.Errorf("Content = %q, want empty when spilling", result.Content)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(files) != len(result.Pages) {
+		t.Errorf("got %d spilled files, want %d", len(files), len(result.Pages))
+	}
+	for _, page := range result.Pages {
+		if page.Content != "" || page.ContentFile == "" {
+			t.Errorf("%s: Content = %q, ContentFile = %q; want content on disk", page.URL, page.Content, page.ContentFile)
+			continue
+		}
+		content, err := page.LoadContent()
+		if err != nil {
+			t.Fatalf("LoadContent() error = %v", err)
+		}
+		if contentHash(content) != page.ContentHash {
+			t.Errorf("%s: loaded content does not match ContentHash", page.URL)
+		}
+	}
+}
+
+func TestSpiderWebsitePostSeeds(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	options := testOptions()
+	options.MaxDepth = 1
+	form := "application/x-www-form-urlencoded"
+	options.PostSeeds = []PostSeed{
+		{URL: srv.URL + "/search", Body: []byte("q=alpha"), ContentType: form},
+		{URL: srv.URL + "/search", Body: []byte("q=beta"), ContentType: form},
+	}
+
+	result, err := SpiderWebsite(srv.URL+"/b", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	var results []string
+	for _, page := range result.Pages {
+		if page.URL == srv.URL+"/search" {
+			results = append(results, strings.TrimSpace(page.Content))
+		}
+	}
+	sort.Strings(results)
+	if len(results) != 2 || !strings.Contains(results[0], "alpha") || !strings.Contains(results[1], "beta") {
+		t.Errorf("search result pages = %q", results)
+	}
+	// Links on the POST responses are followed like any other
+	if !strings.Contains(strings.Join(crawledPaths(t, result), " "), "/a") {
+		t.Errorf("crawled = %v, want /a from the search results", crawledPaths(t, result))
+	}
+}
+
+func TestSpiderWebsiteEffectiveOptions(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	options := testOptions()
+	options.MaxDepth = 0
+	options.MaxPages = 0
+	options.OnEvent = func(CrawlEvent) {}
+
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+
+	effective := result.EffectiveOptions
+	if effective == nil || effective.MaxPages != 1 || effective.PageHeaderTemplate != DefaultPageHeaderTemplate {
+		t.Fatalf("EffectiveOptions = %+v, want defaults applied", effective)
+	}
+	if options.MaxPages != 0 || options.PageHeaderTemplate != "" {
+		t.Errorf("caller's options changed to %+v", options)
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("json.Marshal(result) error = %v", err)
+	}
+	if !strings.Contains(string(data), `"MaxPages":1`) {
+		t.Errorf("JSON output is missing the effective options: %s", data)
+	}
+}
+
+func TestParseRobots(t *testing.T) {
+	t.Parallel()
+	robots := `# comment
+User-agent: otherbot
+Disallow: /
+
+User-agent: examplebot
+User-agent: *
+Disallow: /private/
+Allow: /private/open
+Disallow: /*.json$
+Crawl-delay: 2.5
+
+Sitemap: https://example.com/sitemap.xml
+`
+	rules := parseRobots(strings.NewReader(robots), "Mozilla/5.0")
+	tests := map[string]bool{
+		"/":                  true,
+		"/private/":          false,
+		"/private/open/page": true,
+		"/data.json":         false,
+		"/data.json?x=1":     true,
+	}
+	for path, want := range tests {
+		if got := rules.allowed(path); got != want {
+			t.Errorf("allowed(%q) = %v, want %v", path, got, want)
+		}
+	}
+	if rules.crawlDelay != 2500*time.Millisecond {
+		t.Errorf("crawlDelay = %v, want 2.5s", rules.crawlDelay)
+	}
+	if fmt.Sprint(rules.sitemaps) != "[https://example.com/sitemap.xml]" {
+		t.Errorf("sitemaps = %v", rules.sitemaps)
+	}
+
+	if parseRobots(strings.NewReader(robots), "OtherBot/1.0").allowed("/a") {
+		t.Error("rules for a named agent should replace the * rules")
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	report, err := Preflight(srv.URL+"/moved", nil)
+	if err != nil {
+		t.Fatalf("Preflight() error = %v", err)
+	}
+	if !report.OK() || !report.Reachable || report.StatusCode != http.StatusOK {
+		t.Errorf("fixture site should pass preflight: %+v", report)
+	}
+	if report.FinalURL != srv.URL+"/c" || !report.RobotsFound || !report.RobotsAllowed {
+		t.Errorf("report = %+v", report)
+	}
+
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprint(w, "User-agent: *\nDisallow: /app\nSitemap: /sitemap-index.xml\n")
+		case "/away":
+			http.Redirect(w, r, srv.URL+"/", http.StatusFound)
+		default:
+			fmt.Fprint(w, `<html><body><div id="root"></div><script src="/bundle.js"></script></body></html>`)
+		}
+	}))
+	t.Cleanup(app.Close)
+
+	report, err = Preflight(app.URL+"/app", nil)
+	if err != nil {
+		t.Fatalf("Preflight() error = %v", err)
+	}
+	if report.OK() || !report.LikelyRequiresJS || report.RobotsAllowed || len(report.Problems) != 2 {
+		t.Errorf("expected JS and robots problems: %+v", report)
+	}
+	if fmt.Sprint(report.Sitemaps) != "[/sitemap-index.xml]" {
+		t.Errorf("Sitemaps = %v", report.Sitemaps)
+	}
+
+	report, err = Preflight(app.URL+"/away", nil)
+	if err != nil {
+		t.Fatalf("Preflight() error = %v", err)
+	}
+	if !report.RedirectLeavesScope {
+		t.Errorf("redirect to %s should leave the scope: %+v", srv.URL, report)
+	}
+
+	if _, err := Preflight("ftp://example.com/", nil); err == nil {
+		t.Error("Preflight() should reject non-HTTP URLs")
+	}
+
+	// Judged with the crawl's UserAgent, headers and robots.txt policy
+	var robotsStatus atomic.Int32
+	robotsStatus.Store(http.StatusOK)
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "secret" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if r.URL.Path == "/robots.txt" {
+			w.WriteHeader(int(robotsStatus.Load()))
+			fmt.Fprint(w, "User-agent: Mozilla\nDisallow: /\n")
+			return
+		}
+		fmt.Fprint(w, fixturePage("Index"))
+	}))
+	t.Cleanup(site.Close)
+	options := testOptions()
+	options.Headers = map[string]string{"X-Api-Key": "secret"}
+	if report, err := Preflight(site.URL+"/", options); err != nil || !report.OK() || !report.RobotsFound {
+		t.Errorf("Preflight() = %+v, %v; want the * group to apply to the default UserAgent", report, err)
+	}
+	robotsStatus.Store(http.StatusServiceUnavailable)
+	if report, err := Preflight(site.URL+"/", options); err != nil || report.OK() || report.RobotsAllowed {
+		t.Errorf("Preflight() = %+v, %v; want a failing robots.txt reported", report, err)
+	}
+	options.RobotsFetchFailurePolicy = RobotsFailureAllow
+	if report, err := Preflight(site.URL+"/", options); err != nil || !report.OK() {
+		t.Errorf("Preflight() = %+v, %v; want RobotsFailureAllow respected", report, err)
+	}
+}
+
+func TestSpiderResultDownloadFiles(t *testing.T) {
+	t.Parallel()
+	srv := newFixtureSite(t)
+
+	var flakyCalls atomic.Int32
+	var mu sync.Mutex
+	var requests []time.Time
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ua := r.Header.Get("User-Agent"); ua != "TestBot/1.0" {
+			t.Errorf("User-Agent = %q, want the crawl's", ua)
+		}
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		requests = append(requests, time.Now())
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/flaky.csv":
+			if flakyCalls.Add(1) == 1 {
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, "a,b\n")
+		case "/later.csv":
+			w.Header().Set("Retry-After", "3600")
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(files.Close)
+
+	options := testOptions()
+	options.UserAgent = "TestBot/1.0"
+	result, err := SpiderWebsite(srv.URL+"/", options)
+	if err != nil {
+		t.Fatalf("SpiderWebsite() error = %v", err)
+	}
+	// A file linked from several pages is downloaded once
+	result.DetectedFileUrls = append(result.DetectedFileUrls, result.DetectedFileUrls...)
+	result.DetectedFiles = append(result.DetectedFiles,
+		webcrawl.FileInfo{URL: files.URL + "/flaky.csv"},
+		webcrawl.FileInfo{URL: files.URL + "/missing.zip"},
+		webcrawl.FileInfo{URL: files.URL + "/later.csv"},
+		webcrawl.FileInfo{URL: "http://bad host/file.zip"},
+	)
+	result.EffectiveOptions.DelayBetween = 50 * time.Millisecond
+
+	dir := t.TempDir()
+	manifest, err := result.DownloadFiles(dir, 2)
+	if err != nil {
+		t.Fatalf("DownloadFiles() error = %v", err)
+	}
+	if len(manifest.Files) != 5 || manifest.Downloaded != 2 || manifest.Failed != 3 {
+		t.Fatalf("manifest = %+v", manifest)
+	}
+
+	report := manifest.Files[0]
+	if report.Path != filepath.Join(dir, "0001-annual-report.pdf") || report.Size != int64(len("%PDF-1.4 fixture")) {
+		t.Errorf("report entry = %+v", report)
+	}
+	if data, err := os.ReadFile(report.Path); err != nil || string(data) != "%PDF-1.4 fixture" {
+		t.Errorf("downloaded report = %q, %v", data, err)
+	}
+	if flaky := manifest.Files[1]; flaky.Attempts != 2 || flaky.Error != "" {
+		t.Errorf("flaky file should succeed on retry: %+v", flaky)
+	}
+	if missing := manifest.Files[2]; missing.Attempts != 1 || missing.Path != "" || missing.Error == "" {
+		t.Errorf("404 should fail without retrying: %+v", missing)
+	}
+	// Neither a Retry-After too long to wait nor a bad URL is retried
+	for _, failed := range manifest.Files[3:] {
+		if failed.Attempts != 1 || failed.Error == "" {
+			t.Errorf("%s should fail without retrying: %+v", failed.URL, failed)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("download directory has %d entries, want 2 files and manifest.json", len(entries))
+	}
+
+	// Requests to one host are spaced by DelayBetween across workers
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Slice(requests, func(i, j int) bool { return requests[i].Before(requests[j]) })
+	for i := 1; i < len(requests); i++ {
+		if gap := requests[i].Sub(requests[i-1]); gap < 40*time.Millisecond {
+			t.Errorf("request %d came %v after the previous one, want about 50ms", i, gap)
+		}
+	}
+}
+
+func TestSpiderResultDownloadFilesRobotsTxt(t *testing.T) {
+	t.Parallel()
+
+	var fetched []string
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private/\n")
+			return
+		}
+		mu.Lock()
+		fetched = append(fetched, r.URL.Path)
+		mu.Unlock()
+		fmt.Fprint(w, "data")
+	}))
+	t.Cleanup(srv.Close)
+
+	result := &SpiderResult{
+		DetectedFiles:    []webcrawl.FileInfo{{URL: srv.URL + "/private/a.csv"}, {URL: srv.URL + "/public/b.csv"}},
+		EffectiveOptions: testOptions(),
+	}
+	manifest, err := result.DownloadFiles(t.TempDir(), -1)
+	if err != nil {
+		t.Fatalf("DownloadFiles() error = %v", err)
+	}
+	if manifest.Files[1].Attempts != 1 || manifest.Files[1].Path == "" {
+		t.Errorf("public file = %+v, want one attempt even with negative retries", manifest.Files[1])
+	}
+	if manifest.Skipped != 1 || manifest.Downloaded != 1 || !manifest.Files[0].SkippedByRobots || manifest.Files[0].Attempts != 0 {
+		t.Errorf("manifest = %+v, want the private file skipped", manifest)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(fetched) != "[/public/b.csv]" {
+		t.Errorf("fetched %v, want only /public/b.csv", fetched)
+	}
+}
+
+func TestSpiderResultDownloadFilesContextCancel(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		fmt.Fprint(w, "data")
+	}))
+	t.Cleanup(srv.Close)
+
+	result := &SpiderResult{DetectedFiles: []webcrawl.FileInfo{{URL: srv.URL + "/a.csv"}, {URL: srv.URL + "/b.csv"}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	manifest, err := result.DownloadFilesContext(ctx, t.TempDir(), 2)
+	if err != nil {
+		t.Fatalf("DownloadFilesContext() error = %v", err)
+	}
+	if manifest.Failed != 2 || manifest.Files[0].Attempts != 0 || requests.Load() != 0 {
+		t.Errorf("manifest = %+v after %d requests, want nothing fetched", manifest, requests.Load())
+	}
+}